@@ -0,0 +1,150 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An AccessLogRecord holds the fields of a request that access logs
+// commonly record, as in the Apache Combined Log Format. It is created
+// with NewAccessLogRecord when a request arrives and completed with
+// Finish once the response has been written.
+type AccessLogRecord struct {
+	// RemoteAddr is the network address of the client, from
+	// Request.RemoteAddr.
+	RemoteAddr string
+
+	// ClientIP is the address of the client that originated the
+	// request: the first valid address in the Forwarded,
+	// X-Forwarded-For or X-Real-Ip header, in that order of
+	// preference, or the host of RemoteAddr. The forwarding
+	// headers are set by the client or any proxy on the way, so
+	// ClientIP is only trustworthy behind a proxy that overwrites
+	// them.
+	ClientIP string
+
+	// User is the user name from Basic authentication, if any.
+	User string
+
+	Time       time.Time // when the record was created
+	Method     string
+	RequestURI string
+	Proto      string
+	Referer    string
+	UserAgent  string
+
+	// Status, Bytes and Duration are set by Finish: the response
+	// status code, the number of response body bytes written and
+	// the time since Time.
+	Status   int
+	Bytes    int64
+	Duration time.Duration
+}
+
+// NewAccessLogRecord returns a record of req, with Time set to the
+// current time.
+func NewAccessLogRecord(req *http.Request) *AccessLogRecord {
+	user, _, _ := req.BasicAuth()
+	requestURI := req.RequestURI
+	if requestURI == "" && req.URL != nil {
+		requestURI = req.URL.RequestURI()
+	}
+	return &AccessLogRecord{
+		RemoteAddr: req.RemoteAddr,
+		ClientIP:   clientIP(req),
+		User:       user,
+		Time:       time.Now(),
+		Method:     valueOrDefault(req.Method, "GET"),
+		RequestURI: requestURI,
+		Proto:      req.Proto,
+		Referer:    getFromHeader(req.Header, "Referer"),
+		UserAgent:  getFromHeader(req.Header, "User-Agent"),
+	}
+}
+
+// Finish records the response status and body size, and the time
+// elapsed since the record was created.
+func (r *AccessLogRecord) Finish(status int, bytes int64) {
+	r.Status = status
+	r.Bytes = bytes
+	r.Duration = time.Since(r.Time)
+}
+
+// AppendCombined appends the record to dst as a line in the Apache
+// Combined Log Format, without the trailing newline, and returns the
+// extended buffer.
+func (r *AccessLogRecord) AppendCombined(dst []byte) []byte {
+	dst = append(dst, valueOrDefault(r.ClientIP, "-")...)
+	dst = append(dst, " - "...)
+	dst = append(dst, valueOrDefault(r.User, "-")...)
+	dst = append(dst, " ["...)
+	dst = r.Time.AppendFormat(dst, "02/Jan/2006:15:04:05 -0700")
+	dst = append(dst, "] "...)
+	dst = strconv.AppendQuote(dst, r.Method+" "+r.RequestURI+" "+r.Proto)
+	dst = append(dst, ' ')
+	dst = strconv.AppendInt(dst, int64(r.Status), 10)
+	dst = append(dst, ' ')
+	if r.Bytes > 0 {
+		dst = strconv.AppendInt(dst, r.Bytes, 10)
+	} else {
+		dst = append(dst, '-')
+	}
+	dst = append(dst, ' ')
+	dst = strconv.AppendQuote(dst, valueOrDefault(r.Referer, "-"))
+	dst = append(dst, ' ')
+	dst = strconv.AppendQuote(dst, valueOrDefault(r.UserAgent, "-"))
+	return dst
+}
+
+// String returns the record in the Apache Combined Log Format.
+func (r *AccessLogRecord) String() string {
+	return string(r.AppendCombined(nil))
+}
+
+// clientIP returns the address of the client that originated req, as
+// described for AccessLogRecord.ClientIP.
+func clientIP(req *http.Request) string {
+	if v := getFromHeader(req.Header, "Forwarded"); v != "" {
+		elem, _, _ := strings.Cut(v, ",")
+		for pair := range strings.SplitSeq(elem, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			if strings.EqualFold(k, "for") {
+				if ip, ok := parseForwardedNode(strings.Trim(v, `"`)); ok {
+					return ip
+				}
+			}
+		}
+	}
+	if v := getFromHeader(req.Header, "X-Forwarded-For"); v != "" {
+		first, _, _ := strings.Cut(v, ",")
+		if ip, ok := parseForwardedNode(strings.TrimSpace(first)); ok {
+			return ip
+		}
+	}
+	if ip, ok := parseForwardedNode(strings.TrimSpace(getFromHeader(req.Header, "X-Real-Ip"))); ok {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// parseForwardedNode returns the IP address in v, which may carry a
+// port and brackets around an IPv6 address, as in the Forwarded
+// header's node syntax. Obfuscated identifiers and "unknown" are not
+// addresses.
+func parseForwardedNode(v string) (string, bool) {
+	if ap, err := netip.ParseAddrPort(v); err == nil {
+		return ap.Addr().String(), true
+	}
+	v = strings.TrimSuffix(strings.TrimPrefix(v, "["), "]")
+	if a, err := netip.ParseAddr(v); err == nil {
+		return a.String(), true
+	}
+	return "", false
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		h    http.Header
+		want string
+	}{
+		{nil, "192.0.2.1"},
+		{http.Header{"Forwarded": {`for="[2001:db8::1]:4711";proto=https, for=198.51.100.2`}}, "2001:db8::1"},
+		{http.Header{"Forwarded": {"proto=http;For=198.51.100.3"}}, "198.51.100.3"},
+		{http.Header{"Forwarded": {"for=_hidden"}, "X-Forwarded-For": {"198.51.100.4, 10.0.0.1"}}, "198.51.100.4"},
+		{http.Header{"Forwarded": {"for=unknown"}, "X-Real-Ip": {" 198.51.100.5 "}}, "198.51.100.5"},
+		{http.Header{"X-Forwarded-For": {"garbage"}}, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		req := &http.Request{Header: tt.h, RemoteAddr: "192.0.2.1:1234"}
+		if got := clientIP(req); got != tt.want {
+			t.Errorf("clientIP with %v = %q; want %q", tt.h, got, tt.want)
+		}
+	}
+}
+
+func TestAccessLogRecord(t *testing.T) {
+	req := mustNewRequest(t, "GET", "http://example.com/a?b=1", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Proto = "HTTP/1.1"
+	req.SetBasicAuth("frank", "pw")
+	req.Header.Set("Referer", "http://example.com/start")
+	req.Header.Set("User-Agent", `Agent "1"`)
+
+	r := NewAccessLogRecord(req)
+	if r.ClientIP != "192.0.2.1" || r.User != "frank" || r.RequestURI != "/a?b=1" {
+		t.Errorf("record = %+v", r)
+	}
+	r.Finish(200, 2326)
+	if r.Status != 200 || r.Bytes != 2326 || r.Duration < 0 {
+		t.Errorf("after Finish, record = %+v", r)
+	}
+	r.Time = time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600))
+	const want = `192.0.2.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a?b=1 HTTP/1.1" 200 2326 "http://example.com/start" "Agent \"1\""`
+	if got := r.String(); got != want {
+		t.Errorf("String() =\n%s\nwant\n%s", got, want)
+	}
+
+	r = &AccessLogRecord{Time: r.Time, Method: "GET", RequestURI: "/", Proto: "HTTP/1.0", Status: 304}
+	if got := r.String(); !strings.HasPrefix(got, "- - - [") || !strings.HasSuffix(got, `" 304 - "-" "-"`) {
+		t.Errorf("String() of an empty record = %q", got)
+	}
+}
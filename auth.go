@@ -0,0 +1,359 @@
+package http
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/puernya/go-http/internal/ascii"
+)
+
+// A BasicAuthError is returned by SetBasicAuth for credentials that
+// cannot be sent with Basic authentication.
+type BasicAuthError struct {
+	Reason string
+}
+
+func (e *BasicAuthError) Error() string {
+	return "http: invalid basic auth credentials: " + e.Reason
+}
+
+// SetBasicAuth sets the Authorization header of req to use Basic
+// authentication with the given user name and password. Unlike
+// http.Request.SetBasicAuth, it rejects a user name containing a colon,
+// which would be read back as part of the password, and credentials
+// containing control characters.
+func SetBasicAuth(req *http.Request, user, pass string) error {
+	if err := checkBasicAuth(user, pass); err != nil {
+		return err
+	}
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	req.Header.Set("Authorization", "Basic "+basicAuth(user, pass))
+	return nil
+}
+
+// ParseBasicAuth returns the user name and password from the Basic
+// Authorization header of req. ok is false if there is none, or if it
+// is malformed or holds credentials SetBasicAuth would reject.
+func ParseBasicAuth(req *http.Request) (user, pass string, ok bool) {
+	auth := req.Header.Get("Authorization")
+	const prefix = "Basic "
+	if len(auth) < len(prefix) || !ascii.EqualFold(auth[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	c, err := base64.StdEncoding.DecodeString(strings.TrimSpace(auth[len(prefix):]))
+	if err != nil {
+		return "", "", false
+	}
+	user, pass, ok = strings.Cut(string(c), ":")
+	if !ok || checkBasicAuth(user, pass) != nil {
+		return "", "", false
+	}
+	return user, pass, true
+}
+
+// BasicAuth answers Basic authentication challenges with a user name
+// and password. It implements Authenticator, and can also be used for
+// Transport.ProxyAuth.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// Authenticate returns the Authorization header value for the Basic
+// challenge in the WWW-Authenticate header of res, or in its
+// Proxy-Authenticate header for a 407 Proxy Authentication Required.
+// ok is false if res has no Basic challenge.
+func (b *BasicAuth) Authenticate(req *http.Request, res *http.Response) (authorization string, ok bool, err error) {
+	cs, err := responseChallenges(res)
+	if err != nil {
+		return "", false, err
+	}
+	for _, c := range cs {
+		if ascii.EqualFold(c.Scheme, "Basic") {
+			if err := checkBasicAuth(b.Username, b.Password); err != nil {
+				return "", false, err
+			}
+			return "Basic " + basicAuth(b.Username, b.Password), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// responseChallenges returns the challenges in the WWW-Authenticate
+// header of res, or its Proxy-Authenticate header for a 407 response.
+func responseChallenges(res *http.Response) ([]AuthChallenge, error) {
+	key := "Www-Authenticate"
+	if res.StatusCode == http.StatusProxyAuthRequired {
+		key = "Proxy-Authenticate"
+	}
+	return parseAuthChallenges(res.Header[key])
+}
+
+func checkBasicAuth(user, pass string) error {
+	if strings.Contains(user, ":") {
+		return &BasicAuthError{"user name contains a colon"}
+	}
+	if strings.ContainsFunc(user, isControl) || strings.ContainsFunc(pass, isControl) {
+		return &BasicAuthError{"control character in credentials"}
+	}
+	return nil
+}
+
+func isControl(r rune) bool {
+	return r < ' ' || r == 0x7f || (0x80 <= r && r < 0xa0)
+}
+
+// An AuthChallenge is one challenge of a WWW-Authenticate or
+// Proxy-Authenticate header (RFC 9110, Section 11.6.1).
+type AuthChallenge struct {
+	// Scheme is the authentication scheme, as sent. Schemes
+	// compare case-insensitively.
+	Scheme string
+
+	// Token68 holds the challenge's token68, for schemes that use
+	// one instead of parameters.
+	Token68 string
+
+	// Params holds the challenge's parameters, with lowercased
+	// names and unquoted values.
+	Params map[string]string
+}
+
+// ParseAuthChallenges parses the challenges in the WWW-Authenticate
+// and Proxy-Authenticate fields of h, in that order. A field may be
+// sent several times and each field value may hold several
+// comma-separated challenges, whose parameter values may be quoted
+// strings containing commas. Names of parameters are lowercased; a
+// parameter repeated within a challenge keeps its last value.
+func ParseAuthChallenges(h http.Header) ([]AuthChallenge, error) {
+	www, err := parseAuthChallenges(h["Www-Authenticate"])
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := parseAuthChallenges(h["Proxy-Authenticate"])
+	if err != nil {
+		return nil, err
+	}
+	return append(www, proxy...), nil
+}
+
+// parseAuthChallenges parses the challenges in the header field values
+// vv. Each value may hold several comma-separated challenges:
+//
+//	challenge  = auth-scheme [ 1*SP ( token68 / #auth-param ) ]
+//	auth-param = token BWS "=" BWS ( token / quoted-string )
+func parseAuthChallenges(vv []string) ([]AuthChallenge, error) {
+	var cs []AuthChallenge
+	for _, v := range vv {
+		p := authParser{s: v}
+		for {
+			p.skip(", \t")
+			if p.done() {
+				break
+			}
+			scheme := p.token()
+			if scheme == "" {
+				return nil, fmt.Errorf("http: malformed auth challenge %q", v)
+			}
+			c := AuthChallenge{Scheme: scheme, Params: map[string]string{}}
+			p.skip(" \t")
+			if t, ok := p.token68(); ok {
+				c.Token68 = t
+			} else {
+				for p.atParam() {
+					name := strings.ToLower(p.token())
+					p.skip(" \t")
+					p.i++ // '='
+					p.skip(" \t")
+					value, ok := p.value()
+					if !ok {
+						return nil, fmt.Errorf("http: malformed auth challenge %q", v)
+					}
+					c.Params[name] = value
+					p.skip(" \t")
+					if !p.done() && p.s[p.i] != ',' {
+						return nil, fmt.Errorf("http: malformed auth challenge %q", v)
+					}
+					p.skip(", \t")
+				}
+			}
+			cs = append(cs, c)
+		}
+	}
+	return cs, nil
+}
+
+type authParser struct {
+	s string
+	i int
+}
+
+func (p *authParser) done() bool { return p.i >= len(p.s) }
+
+func (p *authParser) skip(set string) {
+	for !p.done() && strings.IndexByte(set, p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+func (p *authParser) token() string {
+	start := p.i
+	for !p.done() && isTokenByte(p.s[p.i]) {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+// token68 consumes and returns a token68 if one follows, up to the
+// end of the value or the next comma.
+func (p *authParser) token68() (string, bool) {
+	j := p.i
+	for j < len(p.s) && (isAlnum(p.s[j]) || strings.IndexByte("-._~+/", p.s[j]) >= 0) {
+		j++
+	}
+	if j == p.i {
+		return "", false
+	}
+	for j < len(p.s) && p.s[j] == '=' {
+		j++
+	}
+	end := j
+	for j < len(p.s) && (p.s[j] == ' ' || p.s[j] == '\t') {
+		j++
+	}
+	if j < len(p.s) && p.s[j] != ',' {
+		return "", false
+	}
+	t := p.s[p.i:end]
+	p.i = j
+	return t, true
+}
+
+// atParam reports whether an auth-param, rather than the next
+// challenge, follows.
+func (p *authParser) atParam() bool {
+	j := p.i
+	for j < len(p.s) && isTokenByte(p.s[j]) {
+		j++
+	}
+	if j == p.i {
+		return false
+	}
+	for j < len(p.s) && (p.s[j] == ' ' || p.s[j] == '\t') {
+		j++
+	}
+	return j < len(p.s) && p.s[j] == '='
+}
+
+// value consumes a token or a quoted-string, which it unquotes.
+func (p *authParser) value() (string, bool) {
+	if p.done() || p.s[p.i] != '"' {
+		t := p.token()
+		return t, t != ""
+	}
+	var b strings.Builder
+	for p.i++; !p.done(); p.i++ {
+		switch c := p.s[p.i]; c {
+		case '"':
+			p.i++
+			return b.String(), true
+		case '\\':
+			if p.i++; p.done() {
+				return "", false
+			}
+			b.WriteByte(p.s[p.i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", false
+}
+
+func isTokenByte(c byte) bool {
+	return isAlnum(c) || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+func isAlnum(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// A RequestAuthorizer is an Authenticator that can also authorize
+// requests before they are sent, without waiting for a challenge.
+type RequestAuthorizer interface {
+	Authenticator
+
+	// Authorize returns the value of the Authorization header to
+	// send req with, or "" to send it without one.
+	Authorize(req *http.Request) (authorization string, err error)
+}
+
+// BearerAuth authorizes requests with OAuth 2.0 bearer tokens (RFC
+// 6750) obtained from Token. It implements RequestAuthorizer and is
+// safe for concurrent use.
+//
+// The token is fetched when the first request is sent and reused for
+// later requests. When a request is rejected with 401 Unauthorized,
+// Token is called again for a fresh token, which the request is sent
+// once more with; requests that were rejected with an older token
+// than the current one just use the current one.
+type BearerAuth struct {
+	// Token returns a token to use. It is called with the context
+	// of the request the token is needed for.
+	Token func(ctx context.Context) (token string, err error)
+
+	mu    sync.Mutex
+	token string
+}
+
+// Authorize returns the Authorization header value with the current
+// token, fetching one first if needed.
+func (b *BearerAuth) Authorize(req *http.Request) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.token == "" {
+		t, err := b.Token(req.Context())
+		if err != nil {
+			return "", err
+		}
+		b.token = t
+	}
+	return "Bearer " + b.token, nil
+}
+
+// Authenticate returns the Authorization header value with a fresh
+// token for req, which res rejected. ok is false if res challenges
+// for another scheme than Bearer, or if req had no bearer token.
+func (b *BearerAuth) Authenticate(req *http.Request, res *http.Response) (authorization string, ok bool, err error) {
+	cs, err := responseChallenges(res)
+	if err != nil {
+		return "", false, err
+	}
+	if len(cs) > 0 && !slices.ContainsFunc(cs, func(c AuthChallenge) bool { return ascii.EqualFold(c.Scheme, "Bearer") }) {
+		return "", false, nil
+	}
+	rejected, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", false, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.token == rejected || b.token == "" {
+		t, err := b.Token(req.Context())
+		if err != nil {
+			return "", false, err
+		}
+		if t == rejected {
+			return "", false, nil
+		}
+		b.token = t
+	}
+	return "Bearer " + b.token, true, nil
+}
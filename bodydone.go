@@ -0,0 +1,57 @@
+package http
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// ErrBodyClosedEarly is passed to the callback of OnBodyDone when the
+// body is closed before it was read to the end.
+var ErrBodyClosedEarly = errors.New("http: response body closed before EOF")
+
+// OnBodyDone wraps res.Body so that fn is called once the body is
+// done with, with the number of bytes read from it and nil if it was
+// read to a clean EOF. A body that ends before its announced length,
+// or with a broken chunked encoding, is reported with the read error,
+// such as io.ErrUnexpectedEOF, and a body closed before EOF with
+// ErrBodyClosedEarly. fn is called at most once, from the goroutine
+// that reads or closes the body. A body without content, such as that
+// of a 204 response, counts as read to the end.
+func OnBodyDone(res *http.Response, fn func(n int64, err error)) {
+	if res.Body == nil {
+		res.Body = http.NoBody
+	}
+	res.Body = &doneBody{ReadCloser: res.Body, fn: fn}
+}
+
+type doneBody struct {
+	io.ReadCloser
+	fn   func(n int64, err error)
+	n    int64
+	once sync.Once
+}
+
+func (b *doneBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err == io.EOF {
+		b.done(nil)
+	} else if err != nil {
+		b.done(err)
+	}
+	return n, err
+}
+
+func (b *doneBody) Close() error {
+	if b.ReadCloser == http.NoBody {
+		b.done(nil)
+	}
+	b.done(ErrBodyClosedEarly)
+	return b.ReadCloser.Close()
+}
+
+func (b *doneBody) done(err error) {
+	b.once.Do(func() { b.fn(b.n, err) })
+}
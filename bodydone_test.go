@@ -0,0 +1,70 @@
+package http
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOnBodyDone(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		close bool // close after reading one byte instead of reading to EOF
+		n     int64
+		err   error
+	}{
+		{"complete", "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello", false, 5, nil},
+		{"chunked", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n", false, 5, nil},
+		{"truncated", "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello", false, 5, io.ErrUnexpectedEOF},
+		{"closed early", "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello", true, 1, ErrBodyClosedEarly},
+		{"no content", "HTTP/1.1 204 No Content\r\n\r\n", true, 0, nil},
+	}
+	for _, tt := range tests {
+		res, err := http.ReadResponse(bufio.NewReader(strings.NewReader(tt.raw)), nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		calls := 0
+		var gotN int64
+		var gotErr error
+		OnBodyDone(res, func(n int64, err error) {
+			calls++
+			gotN, gotErr = n, err
+		})
+		if tt.close {
+			if tt.n > 0 {
+				res.Body.Read(make([]byte, tt.n))
+			}
+		} else {
+			io.ReadAll(res.Body)
+		}
+		res.Body.Close()
+		if calls != 1 {
+			t.Errorf("%s: callback called %d times; want 1", tt.name, calls)
+		}
+		if gotN != tt.n || gotErr != tt.err {
+			t.Errorf("%s: callback(%d, %v); want (%d, %v)", tt.name, gotN, gotErr, tt.n, tt.err)
+		}
+	}
+}
+
+func TestOnBodyDoneNilBody(t *testing.T) {
+	res := &http.Response{StatusCode: 200}
+	var called bool
+	OnBodyDone(res, func(n int64, err error) {
+		called = true
+		if n != 0 || err != nil {
+			t.Errorf("callback(%d, %v); want (0, nil)", n, err)
+		}
+	})
+	if res.Body == nil {
+		t.Fatal("Body is nil")
+	}
+	res.Body.Close()
+	if !called {
+		t.Error("callback not called")
+	}
+}
@@ -0,0 +1,86 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrByteBudgetExceeded is returned when an exchange transfers more
+// bytes than the budget set with WithByteBudget.
+var ErrByteBudgetExceeded = errors.New("http: exchange byte budget exceeded")
+
+var byteBudgetContextKey = &contextKey{"byte-budget"}
+
+// WithByteBudget returns a copy of ctx that limits a request sent with
+// it through Transport to n bytes in total, counting the request line
+// and header fields, the request body, the response header fields and
+// the response body.
+//
+// Header bytes are counted as the lengths of the field names and
+// values, for both HTTP/1 and HTTP/2, not as their encoded size on the
+// wire. Bytes of a request body that is resent on a new connection are
+// counted again. The data exchanged after a 101 Switching Protocols
+// response is not counted.
+//
+// Once the budget is crossed, the request fails, or reading the
+// response body fails, with ErrByteBudgetExceeded and the response
+// body is closed.
+func WithByteBudget(ctx context.Context, n int64) context.Context {
+	b := &byteBudget{}
+	b.remaining.Store(n)
+	return context.WithValue(ctx, byteBudgetContextKey, b)
+}
+
+type byteBudget struct {
+	remaining atomic.Int64
+}
+
+func contextByteBudget(ctx context.Context) *byteBudget {
+	b, _ := ctx.Value(byteBudgetContextKey).(*byteBudget)
+	return b
+}
+
+// charge deducts n bytes from the budget. It is a no-op on a nil
+// budget.
+func (b *byteBudget) charge(n int64) error {
+	if b == nil || n <= 0 {
+		return nil
+	}
+	if b.remaining.Add(-n) < 0 {
+		return ErrByteBudgetExceeded
+	}
+	return nil
+}
+
+// exceeded reports whether the budget has been crossed. It is false
+// for a nil budget.
+func (b *byteBudget) exceeded() bool {
+	return b != nil && b.remaining.Load() < 0
+}
+
+// requestHeadSize estimates the size of req's request line and header.
+func requestHeadSize(req *http.Request) int64 {
+	n := int64(len(valueOrDefault(req.Method, "GET")) + len(req.Host))
+	if req.URL != nil {
+		n += int64(len(req.URL.RequestURI()) + len(req.URL.Host))
+	}
+	return n + responseHeaderStats(req.Header).Bytes
+}
+
+// budgetBody charges the bytes read from a response body to a budget.
+type budgetBody struct {
+	io.ReadCloser
+	b *byteBudget
+}
+
+func (bb *budgetBody) Read(p []byte) (int, error) {
+	n, err := bb.ReadCloser.Read(p)
+	if berr := bb.b.charge(int64(n)); berr != nil {
+		bb.ReadCloser.Close()
+		return n, berr
+	}
+	return n, err
+}
@@ -0,0 +1,53 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWithByteBudget(t *testing.T) {
+	body := strings.Repeat("x", 1000)
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, body)
+	}))
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	tests := []struct {
+		name    string
+		budget  int64
+		reqBody string
+		wantErr bool
+	}{
+		{name: "ample", budget: 1 << 20},
+		{name: "request head", budget: 10, wantErr: true},
+		{name: "request body", budget: 200, reqBody: strings.Repeat("y", 500), wantErr: true},
+		{name: "response body", budget: 600, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := WithByteBudget(context.Background(), tt.budget)
+			req, _ := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(tt.reqBody))
+			res, err := tr.RoundTrip(req)
+			if err == nil {
+				var got []byte
+				got, err = io.ReadAll(res.Body)
+				res.Body.Close()
+				if err == nil && string(got) != body {
+					t.Fatalf("body length %d; want %d", len(got), len(body))
+				}
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrByteBudgetExceeded) {
+					t.Errorf("err = %v; want ErrByteBudgetExceeded", err)
+				}
+			} else if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package http
+
+import (
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResponseFreshness computes, following RFC 9111 (formerly RFC 7234),
+// how long res stays fresh in a shared cache, as of responseTime.
+// requestTime and responseTime are when the request was sent and the
+// response received; they bound the delay to add to the response's
+// Age.
+//
+// The freshness lifetime comes from the s-maxage or max-age
+// Cache-Control directive, else from Expires minus Date, else, for a
+// response with Last-Modified and a status code that is cacheable by
+// default, from the usual 10% heuristic. ttl is that lifetime minus
+// the response's current age, and never negative.
+//
+// cacheable is false if the response may not be stored: when the
+// request or response has Cache-Control: no-store, the response has
+// Cache-Control: private, the request method is neither GET nor HEAD,
+// or the response has no explicit freshness and a status code that is
+// not cacheable by default. A response with Cache-Control: no-cache is
+// cacheable but has a zero ttl, since it must be revalidated before
+// every use.
+func ResponseFreshness(res *http.Response, requestTime, responseTime time.Time) (ttl time.Duration, cacheable bool) {
+	cc := parseCacheControl(res.Header["Cache-Control"])
+	if req := res.Request; req != nil {
+		if m := valueOrDefault(req.Method, "GET"); m != "GET" && m != "HEAD" {
+			return 0, false
+		}
+		if _, ok := parseCacheControl(req.Header["Cache-Control"])["no-store"]; ok {
+			return 0, false
+		}
+	}
+	if _, ok := cc["no-store"]; ok {
+		return 0, false
+	}
+	if _, ok := cc["private"]; ok {
+		return 0, false
+	}
+
+	date, err := http.ParseTime(res.Header.Get("Date"))
+	if err != nil {
+		date = responseTime
+	}
+
+	var lifetime time.Duration
+	if d, ok := cacheControlSeconds(cc, "s-maxage"); ok {
+		lifetime = d
+	} else if d, ok := cacheControlSeconds(cc, "max-age"); ok {
+		lifetime = d
+	} else if v, ok := res.Header["Expires"]; ok {
+		// An invalid Expires, such as "0", means already expired.
+		if expires, err := http.ParseTime(v[0]); err == nil {
+			lifetime = expires.Sub(date)
+		}
+	} else {
+		if !cacheableByDefault(res.StatusCode) {
+			return 0, false
+		}
+		if lm, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil && lm.Before(date) {
+			lifetime = date.Sub(lm) / 10
+		}
+	}
+	if _, ok := cc["no-cache"]; ok {
+		return 0, true
+	}
+
+	// RFC 9111, Section 4.2.3.
+	apparentAge := max(responseTime.Sub(date), 0)
+	var ageValue time.Duration
+	if secs, err := strconv.ParseInt(strings.TrimSpace(res.Header.Get("Age")), 10, 64); err == nil && secs > 0 {
+		ageValue = secondsDuration(secs)
+	}
+	correctedAge := ageValue + max(responseTime.Sub(requestTime), 0)
+	currentAge := max(apparentAge, correctedAge)
+	return max(lifetime-currentAge, 0), true
+}
+
+// cacheableByDefault reports whether responses with the status code
+// can be cached without explicit freshness information (RFC 9110,
+// Section 15.1).
+func cacheableByDefault(code int) bool {
+	switch code {
+	case 200, 203, 204, 206, 300, 301, 308, 404, 405, 410, 414, 501:
+		return true
+	}
+	return false
+}
+
+// parseCacheControl returns the directives in the Cache-Control field
+// values vv, with lowercased names and unquoted values.
+func parseCacheControl(vv []string) map[string]string {
+	cc := make(map[string]string)
+	for _, v := range vv {
+		for part := range strings.SplitSeq(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return cc
+}
+
+// cacheControlSeconds returns the delta-seconds value of the directive
+// name in cc.
+func cacheControlSeconds(cc map[string]string, name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	secs, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || secs < 0 {
+		return 0, false
+	}
+	return secondsDuration(secs), true
+}
+
+// secondsDuration converts secs to a Duration, saturating on overflow.
+func secondsDuration(secs int64) time.Duration {
+	if secs > maxInt64/int64(time.Second) {
+		return time.Duration(maxInt64)
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// SetConditionalHeaders makes req conditional on the representation
+// the caller has cached: If-None-Match is set to etag, the entity tag
+// as received in an ETag field, including its quotes and any W/
+// prefix, and If-Modified-Since to lastModified. An etag without its
+// quotes is quoted; one that is not a valid entity tag even then, as
+// it contains a double quote or a control character, is ignored. An
+// empty etag or a zero lastModified leaves the corresponding field
+// unset.
+func SetConditionalHeaders(req *http.Request, etag string, lastModified time.Time) {
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	if etag != "" {
+		if _, _, ok := parseETag(etag); !ok {
+			etag = `"` + etag + `"`
+		}
+		if _, _, ok := parseETag(etag); ok {
+			req.Header.Set("If-None-Match", etag)
+		}
+	}
+	if !lastModified.IsZero() {
+		req.Header.Set("If-Modified-Since", lastModified.UTC().Format(TimeFormat))
+	}
+}
+
+// IsNotModified reports whether res is a 304 Not Modified, meaning that
+// the representation cached by the client is still current. If the
+// request had an If-None-Match field and the 304 carries an ETag, the
+// ETag must also weakly match one of the requested entity tags, as
+// RFC 7232, Section 3.2 compares them; otherwise the 304 is for some
+// other representation.
+func IsNotModified(res *http.Response) bool {
+	if res.StatusCode != http.StatusNotModified {
+		return false
+	}
+	etag := res.Header.Get("Etag")
+	if etag == "" || res.Request == nil {
+		return true
+	}
+	inm := res.Request.Header["If-None-Match"]
+	if len(inm) == 0 {
+		return true
+	}
+	for _, v := range inm {
+		for {
+			v = textproto.TrimString(v)
+			if v == "" {
+				break
+			}
+			if v[0] == ',' {
+				v = v[1:]
+				continue
+			}
+			if v[0] == '*' {
+				return true
+			}
+			tag, remain := scanETag(v)
+			if tag == "" {
+				break
+			}
+			if ETagWeakMatch(tag, etag) {
+				return true
+			}
+			v = remain
+		}
+	}
+	return false
+}
+
+// ETagStrongMatch reports whether the entity tags a and b match under
+// the strong comparison of RFC 7232, Section 2.3.2: neither is weak
+// and their opaque tags are identical. Tags that are not valid entity
+// tags never match.
+func ETagStrongMatch(a, b string) bool {
+	oa, wa, ok := parseETag(a)
+	if !ok || wa {
+		return false
+	}
+	ob, wb, ok := parseETag(b)
+	return ok && !wb && oa == ob
+}
+
+// ETagWeakMatch reports whether the entity tags a and b match under
+// the weak comparison of RFC 7232, Section 2.3.2: their opaque tags are
+// identical, whether or not either is marked weak with W/. Tags that
+// are not valid entity tags never match.
+func ETagWeakMatch(a, b string) bool {
+	oa, _, ok := parseETag(a)
+	if !ok {
+		return false
+	}
+	ob, _, ok := parseETag(b)
+	return ok && oa == ob
+}
+
+// parseETag splits the entity tag s into its opaque tag, including the
+// quotes, and whether it has the W/ prefix. ok is false if s, ignoring
+// surrounding whitespace, is not exactly one entity tag.
+func parseETag(s string) (opaque string, weak, ok bool) {
+	tag, remain := scanETag(textproto.TrimString(s))
+	if tag == "" || remain != "" {
+		return "", false, false
+	}
+	if weak = strings.HasPrefix(tag, "W/"); weak {
+		tag = tag[2:]
+	}
+	return tag, weak, true
+}
+
+// scanETag determines if a syntactically valid entity tag is at the
+// start of s and returns it along with the rest of s. It returns an
+// empty tag if there is none.
+func scanETag(s string) (etag, remain string) {
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+	if len(s[start:]) < 2 || s[start] != '"' {
+		return "", ""
+	}
+	// RFC 7232, Section 2.3: etagc = %x21 / %x23-7E / obs-text.
+	for i := start + 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == 0x21 || c >= 0x23 && c <= 0x7E || c >= 0x80:
+		case c == '"':
+			return s[:i+1], s[i+1:]
+		default:
+			return "", ""
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,157 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestResponseFreshness(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	date := now.Format(TimeFormat)
+	tests := []struct {
+		name      string
+		method    string
+		reqCC     string
+		status    int
+		h         http.Header
+		want      time.Duration
+		cacheable bool
+	}{
+		{name: "max-age", h: http.Header{"Cache-Control": {"max-age=60"}}, want: time.Minute, cacheable: true},
+		{name: "s-maxage wins", h: http.Header{"Cache-Control": {"max-age=60, s-maxage=120"}}, want: 2 * time.Minute, cacheable: true},
+		{name: "quoted", h: http.Header{"Cache-Control": {`MAX-AGE="30"`}}, want: 30 * time.Second, cacheable: true},
+		{name: "age", h: http.Header{"Cache-Control": {"max-age=60"}, "Age": {"20"}}, want: 40 * time.Second, cacheable: true},
+		{name: "age past lifetime", h: http.Header{"Cache-Control": {"max-age=60"}, "Age": {"90"}}, want: 0, cacheable: true},
+		{name: "expires", h: http.Header{"Date": {date}, "Expires": {now.Add(time.Hour).Format(TimeFormat)}}, want: time.Hour, cacheable: true},
+		{name: "invalid expires", h: http.Header{"Expires": {"0"}}, want: 0, cacheable: true},
+		{name: "heuristic", h: http.Header{"Date": {date}, "Last-Modified": {now.Add(-10 * time.Hour).Format(TimeFormat)}}, want: time.Hour, cacheable: true},
+		{name: "no freshness", status: 200, h: http.Header{}, want: 0, cacheable: true},
+		{name: "not cacheable by default", status: 302, h: http.Header{}, cacheable: false},
+		{name: "explicit on 302", status: 302, h: http.Header{"Cache-Control": {"max-age=5"}}, want: 5 * time.Second, cacheable: true},
+		{name: "no-cache", h: http.Header{"Cache-Control": {"no-cache, max-age=60"}}, want: 0, cacheable: true},
+		{name: "no-store", h: http.Header{"Cache-Control": {"no-store, max-age=60"}}, cacheable: false},
+		{name: "private", h: http.Header{"Cache-Control": {"private, max-age=60"}}, cacheable: false},
+		{name: "request no-store", reqCC: "no-store", h: http.Header{"Cache-Control": {"max-age=60"}}, cacheable: false},
+		{name: "POST", method: "POST", h: http.Header{"Cache-Control": {"max-age=60"}}, cacheable: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &http.Request{Method: tt.method, Header: http.Header{}}
+			if tt.reqCC != "" {
+				req.Header.Set("Cache-Control", tt.reqCC)
+			}
+			res := &http.Response{StatusCode: 200, Header: tt.h, Request: req}
+			if tt.status != 0 {
+				res.StatusCode = tt.status
+			}
+			ttl, cacheable := ResponseFreshness(res, now, now)
+			if cacheable != tt.cacheable || (cacheable && ttl != tt.want) {
+				t.Errorf("ResponseFreshness = %v, %v; want %v, %v", ttl, cacheable, tt.want, tt.cacheable)
+			}
+		})
+	}
+
+	// The time the request took counts toward the age.
+	res := &http.Response{StatusCode: 200, Header: http.Header{"Cache-Control": {"max-age=60"}, "Date": {date}}}
+	if ttl, _ := ResponseFreshness(res, now.Add(-10*time.Second), now); ttl != 50*time.Second {
+		t.Errorf("ttl with a 10s response delay = %v; want 50s", ttl)
+	}
+}
+
+func TestSetConditionalHeaders(t *testing.T) {
+	lm := time.Date(2024, 1, 1, 12, 0, 0, 0, time.FixedZone("", 3600))
+	req := &http.Request{}
+	SetConditionalHeaders(req, `W/"abc"`, lm)
+	if got := req.Header.Get("If-None-Match"); got != `W/"abc"` {
+		t.Errorf("If-None-Match = %q", got)
+	}
+	if got, want := req.Header.Get("If-Modified-Since"), "Mon, 01 Jan 2024 11:00:00 GMT"; got != want {
+		t.Errorf("If-Modified-Since = %q; want %q", got, want)
+	}
+
+	req = &http.Request{Header: http.Header{}}
+	SetConditionalHeaders(req, "", time.Time{})
+	if len(req.Header) != 0 {
+		t.Errorf("header = %v; want empty", req.Header)
+	}
+
+	for etag, want := range map[string]string{
+		`"abc"`:   `"abc"`,
+		`W/"abc"`: `W/"abc"`,
+		"abc":     `"abc"`,
+		`a"bc`:    "",
+		"a\x01bc": "",
+	} {
+		req := &http.Request{}
+		SetConditionalHeaders(req, etag, time.Time{})
+		if got := req.Header.Get("If-None-Match"); got != want {
+			t.Errorf("SetConditionalHeaders(%q): If-None-Match = %q; want %q", etag, got, want)
+		}
+	}
+}
+
+func TestETagMatch(t *testing.T) {
+	// RFC 7232, Section 2.3.2.
+	tests := []struct {
+		a, b         string
+		strong, weak bool
+	}{
+		{`W/"1"`, `W/"1"`, false, true},
+		{`W/"1"`, `W/"2"`, false, false},
+		{`W/"1"`, `"1"`, false, true},
+		{`"1"`, `"1"`, true, true},
+		{`"a"`, `W/"a"`, false, true},
+		{`"a"`, `"b"`, false, false},
+		{`""`, `""`, true, true},
+		{`a`, `a`, false, false},
+		{`"a"`, `"a" x`, false, false},
+		{`w/"a"`, `"a"`, false, false},
+	}
+	for _, tt := range tests {
+		if got := ETagStrongMatch(tt.a, tt.b); got != tt.strong {
+			t.Errorf("ETagStrongMatch(%q, %q) = %v; want %v", tt.a, tt.b, got, tt.strong)
+		}
+		if got := ETagWeakMatch(tt.a, tt.b); got != tt.weak {
+			t.Errorf("ETagWeakMatch(%q, %q) = %v; want %v", tt.a, tt.b, got, tt.weak)
+		}
+	}
+}
+
+func TestIsNotModified(t *testing.T) {
+	req := &http.Request{Header: http.Header{"If-None-Match": {`"abc"`}}}
+	for code, want := range map[int]bool{304: true, 200: false, 412: false} {
+		res := &http.Response{StatusCode: code, Header: http.Header{"Etag": {`"abc"`}}, Request: req}
+		if got := IsNotModified(res); got != want {
+			t.Errorf("IsNotModified(%d) = %v; want %v", code, got, want)
+		}
+	}
+}
+
+func TestIsNotModifiedETag(t *testing.T) {
+	tests := []struct {
+		inm, etag string
+		want      bool
+	}{
+		{`W/"a"`, `"a"`, true},
+		{`"a"`, `W/"a"`, true},
+		{`"x", W/"a"`, `"a"`, true},
+		{`*`, `"a"`, true},
+		{`"b"`, `"a"`, false},
+		{`"a"`, ``, true},
+		{``, `"a"`, true},
+	}
+	for _, tt := range tests {
+		req := &http.Request{Header: http.Header{}}
+		if tt.inm != "" {
+			req.Header.Set("If-None-Match", tt.inm)
+		}
+		res := &http.Response{StatusCode: 304, Header: http.Header{}, Request: req}
+		if tt.etag != "" {
+			res.Header.Set("Etag", tt.etag)
+		}
+		if got := IsNotModified(res); got != tt.want {
+			t.Errorf("If-None-Match %q, ETag %q: IsNotModified = %v; want %v", tt.inm, tt.etag, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/puernya/go-http/internal"
+)
+
+// chunkedResponseBufferSize is how much body data a
+// ChunkedResponseWriter gathers into one chunk.
+const chunkedResponseBufferSize = 4 << 10
+
+var errChunkedResponseClosed = errors.New("http: write on closed ChunkedResponseWriter")
+
+// A ChunkedResponseWriter writes an HTTP/1.1 response whose body is
+// streamed with chunked transfer coding, followed by trailers. It is
+// used like an http.ResponseWriter: set header fields with Header,
+// call WriteHeader, then Write the body, Flush as needed, and Close to
+// end the response.
+//
+// Trailers are declared by listing their names in the Trailer header
+// field before WriteHeader, and their values are set in the map
+// returned by Header after WriteHeader, as with http.ResponseWriter.
+// Only declared trailers are sent.
+//
+// Body data is gathered into chunks of up to 4 KB; Flush sends the
+// data written so far as a chunk.
+type ChunkedResponseWriter struct {
+	wire        *bufio.Writer
+	cw          io.WriteCloser
+	body        *bufio.Writer
+	header      http.Header
+	trailer     http.Header // declared trailer names
+	wroteHeader bool
+	bodyless    bool // status code does not allow a body
+	closed      bool
+	err         error // sticky write error
+}
+
+// NewChunkedResponseWriter returns a ChunkedResponseWriter writing the
+// response to w.
+func NewChunkedResponseWriter(w io.Writer) *ChunkedResponseWriter {
+	wire := bufio.NewWriter(w)
+	cw := internal.NewChunkedWriter(wire)
+	return &ChunkedResponseWriter{
+		wire:   wire,
+		cw:     cw,
+		body:   bufio.NewWriterSize(cw, chunkedResponseBufferSize),
+		header: make(http.Header),
+	}
+}
+
+// Header returns the header map sent by WriteHeader, and after it the
+// map holding the trailer values.
+func (w *ChunkedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader writes the status line and header with the given status
+// code. Informational (1xx) codes are sent as interim responses, after
+// which WriteHeader must be called again; 101 Switching Protocols is
+// not supported. A 204 or 304 response is sent without a body. Calling
+// WriteHeader after the final header is written does nothing.
+//
+// It fails if the Trailer field names a field that may not be sent as
+// a trailer, such as Content-Length or Transfer-Encoding.
+func (w *ChunkedResponseWriter) WriteHeader(code int) error {
+	if w.wroteHeader || w.closed {
+		return w.err
+	}
+	if code < 100 || code > 999 || code == http.StatusSwitchingProtocols {
+		return fmt.Errorf("http: invalid status code %d", code)
+	}
+	if code < 200 {
+		w.writeStatusLine(code)
+		w.header.Write(w.wire)
+		w.wire.WriteString("\r\n")
+		return w.flushWire()
+	}
+
+	trailer, err := fixTrailer(w.header, true)
+	if err != nil {
+		return err
+	}
+	w.trailer = trailer
+	w.wroteHeader = true
+	w.bodyless = !bodyAllowedForStatus(code)
+
+	h := w.header.Clone()
+	h.Del("Content-Length")
+	if w.bodyless {
+		h.Del("Trailer")
+	} else {
+		h.Set("Transfer-Encoding", "chunked")
+	}
+	w.writeStatusLine(code)
+	h.Write(w.wire)
+	w.wire.WriteString("\r\n")
+	return w.err
+}
+
+func (w *ChunkedResponseWriter) writeStatusLine(code int) {
+	text := http.StatusText(code)
+	if text == "" {
+		text = "status code " + strconv.Itoa(code)
+	}
+	fmt.Fprintf(w.wire, "HTTP/1.1 %03d %s\r\n", code, text)
+}
+
+// Write writes p as part of the body, writing a 200 OK header first
+// if WriteHeader has not been called.
+func (w *ChunkedResponseWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errChunkedResponseClosed
+	}
+	if !w.wroteHeader {
+		if err := w.WriteHeader(http.StatusOK); err != nil {
+			return 0, err
+		}
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.bodyless {
+		return 0, http.ErrBodyNotAllowed
+	}
+	n, err := w.body.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// Flush sends the header, if not yet sent, and the body data written
+// so far to the underlying writer.
+func (w *ChunkedResponseWriter) Flush() error {
+	if w.closed {
+		return errChunkedResponseClosed
+	}
+	if !w.wroteHeader {
+		if err := w.WriteHeader(http.StatusOK); err != nil {
+			return err
+		}
+	}
+	if err := w.body.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.flushWire()
+}
+
+// Close ends the body and writes the declared trailers whose values
+// are set in Header, completing the response. It does not close the
+// underlying writer.
+func (w *ChunkedResponseWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	if !w.wroteHeader {
+		if err := w.WriteHeader(http.StatusOK); err != nil {
+			return err
+		}
+	}
+	w.closed = true
+	if err := w.body.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if w.bodyless {
+		return w.flushWire()
+	}
+	w.cw.Close()
+	trailer := make(http.Header, len(w.trailer))
+	for k := range w.trailer {
+		if vv, ok := w.header[k]; ok {
+			trailer[k] = vv
+		}
+	}
+	trailer.Write(w.wire)
+	w.wire.WriteString("\r\n")
+	return w.flushWire()
+}
+
+func (w *ChunkedResponseWriter) flushWire() error {
+	if err := w.wire.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
@@ -0,0 +1,109 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestChunkedResponseWriter(t *testing.T) {
+	var b strings.Builder
+	w := NewChunkedResponseWriter(&b)
+	w.Header().Set("Link", "</style.css>; rel=preload")
+	if err := w.WriteHeader(http.StatusEarlyHints); err != nil {
+		t.Fatal(err)
+	}
+	w.Header().Del("Link")
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Length", "99")
+	w.Header().Set("Trailer", "X-Checksum")
+	if _, err := io.WriteString(w, "hello, "); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "world")
+	w.Header().Set("X-Checksum", "abc")
+	w.Header().Set("X-Undeclared", "1")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(strings.NewReader(b.String()))
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusEarlyHints || res.Header.Get("Link") == "" {
+		t.Fatalf("interim response = %d %v; want 103 with Link", res.StatusCode, res.Header)
+	}
+	res, err = http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || res.ContentLength != -1 || len(res.TransferEncoding) != 1 {
+		t.Errorf("response = %d, ContentLength %d, TransferEncoding %q; want 200 chunked", res.StatusCode, res.ContentLength, res.TransferEncoding)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil || string(body) != "hello, world" {
+		t.Errorf("body = %q, %v; want %q", body, err, "hello, world")
+	}
+	if got := res.Trailer.Get("X-Checksum"); got != "abc" {
+		t.Errorf("X-Checksum trailer = %q; want %q", got, "abc")
+	}
+	if _, ok := res.Trailer["X-Undeclared"]; ok {
+		t.Error("undeclared trailer was sent")
+	}
+
+	if _, err := w.Write([]byte("x")); !errors.Is(err, errChunkedResponseClosed) {
+		t.Errorf("Write after Close error = %v; want %v", err, errChunkedResponseClosed)
+	}
+}
+
+func TestChunkedResponseWriterDefaults(t *testing.T) {
+	var b strings.Builder
+	w := NewChunkedResponseWriter(&b)
+	io.WriteString(w, "ok")
+	w.Close()
+	const want = "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\n\r\n"
+	if b.String() != want {
+		t.Errorf("wrote %q; want %q", b.String(), want)
+	}
+}
+
+func TestChunkedResponseWriterBodyless(t *testing.T) {
+	for _, code := range []int{http.StatusNoContent, http.StatusNotModified} {
+		var b strings.Builder
+		w := NewChunkedResponseWriter(&b)
+		w.Header().Set("Trailer", "X-Checksum")
+		w.WriteHeader(code)
+		if _, err := w.Write([]byte("x")); err != http.ErrBodyNotAllowed {
+			t.Errorf("%d: Write error = %v; want ErrBodyNotAllowed", code, err)
+		}
+		w.Close()
+		want := fmt.Sprintf("HTTP/1.1 %d %s\r\n\r\n", code, http.StatusText(code))
+		if b.String() != want {
+			t.Errorf("%d: wrote %q; want %q", code, b.String(), want)
+		}
+	}
+}
+
+func TestChunkedResponseWriterInvalid(t *testing.T) {
+	var b strings.Builder
+	w := NewChunkedResponseWriter(&b)
+	if err := w.WriteHeader(http.StatusSwitchingProtocols); err == nil {
+		t.Error("WriteHeader(101) succeeded")
+	}
+	w.Header().Set("Trailer", "Content-Length")
+	if err := w.WriteHeader(http.StatusOK); err == nil {
+		t.Error("WriteHeader with Trailer: Content-Length succeeded")
+	}
+	if b.Len() != 0 {
+		t.Errorf("wrote %q; want nothing", b.String())
+	}
+}
@@ -193,11 +193,11 @@ func validateClientConnRequest(req *http.Request) error {
 		return errors.New("http: nil Request.Header")
 	}
 	// Validate the outgoing headers.
-	if err := validateHeaders(req.Header); err != "" {
+	if err := validateHeaders(req.Header, false); err != "" {
 		return fmt.Errorf("http: invalid header %s", err)
 	}
 	// Validate the outgoing trailers too.
-	if err := validateHeaders(req.Trailer); err != "" {
+	if err := validateHeaders(req.Trailer, false); err != "" {
 		return fmt.Errorf("http: invalid trailer %s", err)
 	}
 	if req.Method != "" && !validMethod(req.Method) {
@@ -233,6 +233,18 @@ func (cc *ClientConn) Available() int {
 	return cc.cc.Available()
 }
 
+// ExtendedConnectEnabled reports whether the server advertised
+// SETTINGS_ENABLE_CONNECT_PROTOCOL (RFC 8441) in its first SETTINGS
+// frame, allowing Extended CONNECT requests on the connection. It
+// returns false for HTTP/1 connections, and until the server's
+// SETTINGS frame has been received.
+func (cc *ClientConn) ExtendedConnectEnabled() bool {
+	if ec, ok := cc.cc.(interface{ ExtendedConnectEnabled() bool }); ok {
+		return ec.ExtendedConnectEnabled()
+	}
+	return false
+}
+
 // InFlight reports the number of requests in flight,
 // including reserved requests.
 // It returns 0 if the connection is closed.
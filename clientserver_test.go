@@ -0,0 +1,207 @@
+package http
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newTestServer starts a Server serving h on a loopback listener and
+// returns it with its base URL. Options may adjust the Server before
+// it starts. The Server is closed when the test ends.
+func newTestServer(t testing.TB, h http.Handler, opts ...func(*Server)) (*Server, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Handler: h}
+	for _, opt := range opts {
+		opt(srv)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return srv, "http://" + ln.Addr().String()
+}
+
+// h2cProtocols returns Protocols for a test Server or Transport that
+// speaks unencrypted HTTP/2 with prior knowledge.
+func h2cProtocols() *Protocols {
+	p := new(Protocols)
+	p.SetUnencryptedHTTP2(true)
+	return p
+}
+
+// newRawServer accepts connections on a loopback listener and hands
+// each to serve in its own goroutine. It returns the listener address.
+func newRawServer(t testing.TB, serve func(c net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				serve(c)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// readRawRequest reads one request from the connection of a raw
+// server, discarding its body.
+func readRawRequest(br *bufio.Reader) (*http.Request, error) {
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(io.Discard, req.Body)
+	return req, err
+}
+
+// newH2CaptureServer accepts connections that start with the HTTP/2
+// client preface and calls onFrame with each frame read from them,
+// after acknowledging the client's SETTINGS. A connection is closed
+// when onFrame returns false. It returns the listener address.
+func newH2CaptureServer(t testing.TB, onFrame func(fr *http2Framer, f http2Frame) bool) string {
+	return newRawServer(t, func(c net.Conn) {
+		preface := make([]byte, len(http2ClientPreface))
+		if _, err := io.ReadFull(c, preface); err != nil {
+			return
+		}
+		fr := http2NewFramer(c, c)
+		fr.WriteSettings()
+		for {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				return
+			}
+			if sf, ok := f.(*http2SettingsFrame); ok && !sf.IsAck() {
+				fr.WriteSettingsAck()
+			}
+			if !onFrame(fr, f) {
+				return
+			}
+		}
+	})
+}
+
+func mustNewRequest(t testing.TB, method, url string, body io.Reader) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+// newTestCert returns a self-signed certificate valid for dnsNames and
+// 127.0.0.1, and a pool trusting it.
+func newTestCert(t testing.TB, dnsNames ...string) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              dnsNames,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// newTLSTestServer is like newTestServer, but serves HTTPS with cert.
+// It returns the "https" base URL.
+func newTLSTestServer(t testing.TB, h http.Handler, cert tls.Certificate, opts ...func(*Server)) (*Server, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Handler: h, TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}}}
+	for _, opt := range opts {
+		opt(srv)
+	}
+	go srv.ServeTLS(ln, "", "")
+	t.Cleanup(func() { srv.Close() })
+	return srv, "https://" + ln.Addr().String()
+}
+
+// newConnectProxy starts a proxy that serves CONNECT requests by
+// tunneling to the requested address, over TLS with cert if it is not
+// nil. If authorize is non-nil, requests it rejects get a 407 response
+// asking for Basic credentials. It returns the proxy URL.
+func newConnectProxy(t testing.TB, cert *tls.Certificate, authorize func(*http.Request) bool) string {
+	scheme := "http"
+	if cert != nil {
+		scheme = "https"
+	}
+	addr := newRawServer(t, func(c net.Conn) {
+		if cert != nil {
+			c = tls.Server(c, &tls.Config{Certificates: []tls.Certificate{*cert}})
+		}
+		br := bufio.NewReader(c)
+		for {
+			req, err := readRawRequest(br)
+			if err != nil {
+				return
+			}
+			if req.Method != "CONNECT" {
+				io.WriteString(c, "HTTP/1.1 405 Method Not Allowed\r\nContent-Length: 0\r\n\r\n")
+				continue
+			}
+			if authorize != nil && !authorize(req) {
+				io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n"+
+					"Proxy-Authenticate: Basic realm=\"test\"\r\nContent-Length: 0\r\n\r\n")
+				continue
+			}
+			upstream, err := net.Dial("tcp", req.Host)
+			if err != nil {
+				io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+				return
+			}
+			defer upstream.Close()
+			io.WriteString(c, "HTTP/1.1 200 OK\r\n\r\n")
+			go io.Copy(upstream, br)
+			io.Copy(c, upstream)
+			return
+		}
+	})
+	return scheme + "://" + addr
+}
@@ -0,0 +1,132 @@
+package http
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// PhaseTimeouts holds the timeouts for the phases of a request, as set
+// in Transport.PhaseTimeouts. Zero means no timeout for the phase.
+type PhaseTimeouts struct {
+	// Connect bounds a single dial, including the DNS lookup, and
+	// for DialTLSContext the TLS handshake it performs.
+	Connect time.Duration
+
+	// TLSHandshake bounds the TLS handshake with the server.
+	TLSHandshake time.Duration
+
+	// ResponseHeader bounds the wait for the response headers after
+	// the request, including its body, has been written.
+	ResponseHeader time.Duration
+
+	// ResponseBody bounds the time from receiving the response
+	// headers to reading the end of the body.
+	ResponseBody time.Duration
+}
+
+// A TimeoutPhase names the phase of a request a PhaseTimeoutError
+// occurred in.
+type TimeoutPhase string
+
+const (
+	PhaseConnect        TimeoutPhase = "connect"
+	PhaseTLSHandshake   TimeoutPhase = "TLS handshake"
+	PhaseResponseHeader TimeoutPhase = "response header"
+	PhaseResponseBody   TimeoutPhase = "response body"
+)
+
+// A PhaseTimeoutError is returned when one of the Transport's
+// PhaseTimeouts expires. It implements net.Error, and matches
+// context.DeadlineExceeded with errors.Is.
+type PhaseTimeoutError struct {
+	Phase TimeoutPhase
+}
+
+func (e *PhaseTimeoutError) Error() string     { return "http: " + string(e.Phase) + " timeout" }
+func (e *PhaseTimeoutError) Timeout() bool     { return true }
+func (e *PhaseTimeoutError) Temporary() bool   { return true }
+func (e *PhaseTimeoutError) Is(err error) bool { return err == context.DeadlineExceeded }
+
+// phaseTimeoutCause returns the *PhaseTimeoutError that canceled ctx
+// in place of err, which a phase bounded by ctx failed with, if any.
+func phaseTimeoutCause(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if pe, ok := context.Cause(ctx).(*PhaseTimeoutError); ok {
+		return pe
+	}
+	return err
+}
+
+// responseHeaderTimeout returns the time to wait for response headers
+// and the error to fail with when it expires.
+func (t *Transport) responseHeaderTimeout() (time.Duration, error) {
+	if d := t.PhaseTimeouts.ResponseHeader; d > 0 {
+		return d, &PhaseTimeoutError{Phase: PhaseResponseHeader}
+	}
+	return t.ResponseHeaderTimeout, ErrResponseHeaderTimeout
+}
+
+var responseBodyDeadlineContextKey = &contextKey{"response-body-deadline"}
+
+// WithResponseBodyDeadline returns a copy of ctx that makes Transport
+// interrupt reading the body of the response to a request sent with
+// it once the time t is reached. Reads of the body then fail with
+// os.ErrDeadlineExceeded, including one blocked waiting for data from
+// a stalled server, and the connection is not reused.
+//
+// The deadline only concerns the response body, which the request
+// context does not bound on its own once it is no longer needed for
+// anything else; a context deadline still applies to the whole
+// exchange, and on HTTP/1 canceling the context closes the connection
+// as well.
+func WithResponseBodyDeadline(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, responseBodyDeadlineContextKey, t)
+}
+
+func contextResponseBodyDeadline(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(responseBodyDeadlineContextKey).(time.Time)
+	return t, ok
+}
+
+// deadlineBody is a response body that is closed when its deadline
+// passes, which interrupts a pending Read.
+type deadlineBody struct {
+	io.ReadCloser
+	timer   *time.Timer
+	expired atomic.Bool
+	err     error // returned by Read once expired
+}
+
+func newDeadlineBody(rc io.ReadCloser, deadline time.Time, err error) *deadlineBody {
+	b := &deadlineBody{ReadCloser: rc, err: err}
+	b.timer = time.AfterFunc(time.Until(deadline), func() {
+		b.expired.Store(true)
+		rc.Close()
+	})
+	return b
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	if b.expired.Load() {
+		return 0, b.err
+	}
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		if b.expired.Load() {
+			return n, b.err
+		}
+		if err == io.EOF {
+			b.timer.Stop()
+		}
+	}
+	return n, err
+}
+
+func (b *deadlineBody) Close() error {
+	b.timer.Stop()
+	return b.ReadCloser.Close()
+}
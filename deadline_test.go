@@ -0,0 +1,165 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// newStallingServer returns the URL of a server whose handler sends
+// the response headers and part of the body, then stalls until the
+// test ends.
+func newStallingServer(t *testing.T, opts ...func(*Server)) string {
+	stall := make(chan struct{})
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/full" {
+			io.WriteString(w, "done")
+			return
+		}
+		w.Header().Set("Content-Length", "100")
+		io.WriteString(w, "part")
+		w.(http.Flusher).Flush()
+		select {
+		case <-stall:
+		case <-r.Context().Done():
+		}
+	}), opts...)
+	t.Cleanup(func() { close(stall) })
+	return url
+}
+
+func TestWithResponseBodyDeadline(t *testing.T) {
+	for _, h2 := range []bool{false, true} {
+		name := "HTTP1"
+		if h2 {
+			name = "HTTP2"
+		}
+		t.Run(name, func(t *testing.T) {
+			var srvOpts []func(*Server)
+			tr := &Transport{}
+			if h2 {
+				srvOpts = append(srvOpts, func(s *Server) { s.Protocols = h2cProtocols() })
+				tr.Protocols = h2cProtocols()
+			}
+			defer tr.CloseIdleConnections()
+			url := newStallingServer(t, srvOpts...)
+
+			ctx := WithResponseBodyDeadline(context.Background(), time.Now().Add(100*time.Millisecond))
+			res, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil).WithContext(ctx))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			start := time.Now()
+			b, err := io.ReadAll(res.Body)
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Errorf("reading the body: %q, %v; want os.ErrDeadlineExceeded", b, err)
+			}
+			if d := time.Since(start); d > 5*time.Second {
+				t.Errorf("body read returned after %v", d)
+			}
+
+			// A body read in time is unaffected.
+			ctx = WithResponseBodyDeadline(context.Background(), time.Now().Add(5*time.Second))
+			res, err = tr.RoundTrip(mustNewRequest(t, "GET", url+"/full", nil).WithContext(ctx))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err = io.ReadAll(res.Body)
+			res.Body.Close()
+			if string(b) != "done" || err != nil {
+				t.Errorf("body = %q, %v; want \"done\"", b, err)
+			}
+		})
+	}
+}
+
+func TestTransportPhaseTimeouts(t *testing.T) {
+	stallHeaders := make(chan struct{})
+	defer close(stallHeaders)
+	_, headerURL := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-stallHeaders:
+		case <-r.Context().Done():
+		}
+	}))
+	bodyURL := newStallingServer(t)
+	// A server that accepts connections but never speaks TLS.
+	silentAddr := newRawServer(t, func(c net.Conn) { io.Copy(io.Discard, c) })
+
+	const d = 100 * time.Millisecond
+	tests := []struct {
+		phase TimeoutPhase
+		url   string
+		tr    *Transport
+	}{
+		{PhaseConnect, "http://example.com/", &Transport{
+			PhaseTimeouts: PhaseTimeouts{Connect: d},
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		}},
+		{PhaseTLSHandshake, "https://" + silentAddr + "/", &Transport{PhaseTimeouts: PhaseTimeouts{TLSHandshake: d}}},
+		{PhaseResponseHeader, headerURL, &Transport{PhaseTimeouts: PhaseTimeouts{ResponseHeader: d}, ResponseHeaderTimeout: time.Hour}},
+		{PhaseResponseBody, bodyURL, &Transport{PhaseTimeouts: PhaseTimeouts{ResponseBody: d}}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.phase), func(t *testing.T) {
+			defer tt.tr.CloseIdleConnections()
+			res, err := tt.tr.RoundTrip(mustNewRequest(t, "GET", tt.url, nil))
+			if err == nil {
+				_, err = io.ReadAll(res.Body)
+				res.Body.Close()
+			}
+			var pe *PhaseTimeoutError
+			if !errors.As(err, &pe) || pe.Phase != tt.phase {
+				t.Fatalf("error = %v; want a PhaseTimeoutError for %q", err, tt.phase)
+			}
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Error("error does not match context.DeadlineExceeded")
+			}
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				t.Error("error is not a net.Error timeout")
+			}
+		})
+	}
+}
+
+func TestTransportErrResponseHeaderTimeout(t *testing.T) {
+	for _, h2 := range []bool{false, true} {
+		name := "HTTP1"
+		if h2 {
+			name = "HTTP2"
+		}
+		t.Run(name, func(t *testing.T) {
+			stall := make(chan struct{})
+			defer close(stall)
+			var srvOpts []func(*Server)
+			tr := &Transport{ResponseHeaderTimeout: 100 * time.Millisecond}
+			if h2 {
+				srvOpts = append(srvOpts, func(s *Server) { s.Protocols = h2cProtocols() })
+				tr.Protocols = h2cProtocols()
+			}
+			defer tr.CloseIdleConnections()
+			_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-stall:
+				case <-r.Context().Done():
+				}
+			}), srvOpts...)
+			_, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil))
+			if !errors.Is(err, ErrResponseHeaderTimeout) {
+				t.Errorf("RoundTrip error = %v; want ErrResponseHeaderTimeout", err)
+			}
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Error("error does not match context.DeadlineExceeded")
+			}
+		})
+	}
+}
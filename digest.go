@@ -0,0 +1,155 @@
+package http
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/puernya/go-http/internal/ascii"
+)
+
+// An Authenticator answers authentication challenges for a Transport.
+// See Transport.Authenticator.
+type Authenticator interface {
+	// Authenticate is called with a 401 Unauthorized response res
+	// to req. It returns the value of the Authorization header to
+	// send req again with, or ok false to return res to the caller
+	// instead.
+	Authenticate(req *http.Request, res *http.Response) (authorization string, ok bool, err error)
+}
+
+// DigestAuth answers Digest authentication challenges (RFC 7616) with
+// a user name and password. It supports the MD5 and SHA-256
+// algorithms, their -sess variants, and the "auth" quality of
+// protection, or none for servers following RFC 2069. It implements
+// Authenticator and is safe for concurrent use.
+type DigestAuth struct {
+	Username string
+	Password string
+
+	mu sync.Mutex
+	nc map[string]uint32 // nonce count by nonce
+}
+
+// Authenticate returns the Authorization header value answering the
+// Digest challenge in the WWW-Authenticate header of res, or in its
+// Proxy-Authenticate header for a 407 Proxy Authentication Required.
+// ok is false if res has no Digest challenge DigestAuth supports.
+func (d *DigestAuth) Authenticate(req *http.Request, res *http.Response) (authorization string, ok bool, err error) {
+	cs, err := responseChallenges(res)
+	if err != nil {
+		return "", false, err
+	}
+	for _, c := range cs {
+		if !ascii.EqualFold(c.Scheme, "Digest") {
+			continue
+		}
+		auth, err := d.authorization(req, c)
+		if err == errDigestUnsupported {
+			continue
+		}
+		if err != nil {
+			return "", false, err
+		}
+		return auth, true, nil
+	}
+	return "", false, nil
+}
+
+// maxDigestNonces bounds the number of nonces DigestAuth counts.
+const maxDigestNonces = 64
+
+var errDigestUnsupported = errors.New("http: unsupported digest challenge")
+
+// authorization computes the Authorization header value answering the
+// Digest challenge c for req.
+func (d *DigestAuth) authorization(req *http.Request, c AuthChallenge) (string, error) {
+	realm, nonce := c.Params["realm"], c.Params["nonce"]
+	if nonce == "" {
+		return "", errDigestUnsupported
+	}
+	algorithm := valueOrDefault(c.Params["algorithm"], "MD5")
+	var newHash func() hash.Hash
+	base, sess := strings.CutSuffix(strings.ToUpper(algorithm), "-SESS")
+	switch base {
+	case "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", errDigestUnsupported
+	}
+	qop := ""
+	if v, ok := c.Params["qop"]; ok {
+		for q := range strings.SplitSeq(v, ",") {
+			if strings.TrimSpace(q) == "auth" {
+				qop = "auth"
+			}
+		}
+		if qop == "" {
+			return "", errDigestUnsupported // only auth-int offered
+		}
+	}
+	h := func(s string) string {
+		hh := newHash()
+		hh.Write([]byte(s))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+
+	uri := req.URL.RequestURI()
+	if req.Method == "CONNECT" && req.Host != "" {
+		uri = req.Host
+	}
+	var cnonceBytes [16]byte
+	rand.Read(cnonceBytes[:])
+	cnonce := hex.EncodeToString(cnonceBytes[:])
+	nc := fmt.Sprintf("%08x", d.nextNonceCount(nonce))
+
+	ha1 := h(d.Username + ":" + realm + ":" + d.Password)
+	if sess {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(valueOrDefault(req.Method, "GET") + ":" + uri)
+	var response string
+	if qop == "" {
+		response = h(ha1 + ":" + nonce + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%s, realm=%s, nonce=%s, uri=%s, algorithm=%s, response=%s`,
+		quoteAuthParam(d.Username), quoteAuthParam(realm), quoteAuthParam(nonce), quoteAuthParam(uri), algorithm, quoteAuthParam(response))
+	if opaque, ok := c.Params["opaque"]; ok {
+		fmt.Fprintf(&b, ", opaque=%s", quoteAuthParam(opaque))
+	}
+	if qop != "" {
+		fmt.Fprintf(&b, ", qop=%s, nc=%s, cnonce=%s", qop, nc, quoteAuthParam(cnonce))
+	}
+	return b.String(), nil
+}
+
+// nextNonceCount returns the number of times nonce has been used,
+// including this use.
+func (d *DigestAuth) nextNonceCount(nonce string) uint32 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.nc == nil || len(d.nc) >= maxDigestNonces {
+		// Old nonces are rarely reused; forget them all.
+		d.nc = make(map[string]uint32)
+	}
+	d.nc[nonce]++
+	return d.nc[nonce]
+}
+
+// quoteAuthParam returns s as a quoted-string.
+func quoteAuthParam(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// checkDigest reports whether the Digest credentials in auth answer a
+// challenge with nonce for method and uri with user and pass.
+func checkDigest(t *testing.T, auth, method, uri, user, pass string) bool {
+	t.Helper()
+	cs, err := parseAuthChallenges([]string{auth})
+	if err != nil || len(cs) != 1 || cs[0].Scheme != "Digest" {
+		t.Errorf("Authorization %q is not a Digest credential: %v", auth, err)
+		return false
+	}
+	p := cs[0].Params
+	var newHash func() hash.Hash
+	base, sess := strings.CutSuffix(p["algorithm"], "-sess")
+	switch base {
+	case "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		t.Errorf("algorithm = %q", p["algorithm"])
+		return false
+	}
+	h := func(s string) string {
+		hh := newHash()
+		hh.Write([]byte(s))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+	if p["username"] != user || p["uri"] != uri {
+		return false
+	}
+	ha1 := h(user + ":" + p["realm"] + ":" + pass)
+	if sess {
+		ha1 = h(ha1 + ":" + p["nonce"] + ":" + p["cnonce"])
+	}
+	ha2 := h(method + ":" + uri)
+	want := h(ha1 + ":" + p["nonce"] + ":" + ha2)
+	if p["qop"] != "" {
+		want = h(ha1 + ":" + p["nonce"] + ":" + p["nc"] + ":" + p["cnonce"] + ":" + p["qop"] + ":" + ha2)
+	}
+	return p["response"] == want
+}
+
+func TestDigestAuth(t *testing.T) {
+	req := mustNewRequest(t, "GET", "http://example.com/dir/index.html?x=1", nil)
+	challenge := func(v string) *http.Response {
+		return &http.Response{StatusCode: 401, Header: http.Header{"Www-Authenticate": {v}}}
+	}
+	d := &DigestAuth{Username: "Mufasa", Password: "Circle of Life"}
+	for _, v := range []string{
+		`Digest realm="testrealm@host.com", qop="auth,auth-int", nonce="dcd98b", opaque="5ccc"`,
+		`Digest realm="r", nonce="n1"`,
+		`Digest realm="r", nonce="n2", algorithm=SHA-256-sess, qop=auth`,
+		`Basic realm="r", Digest realm="r", nonce="n3", algorithm=MD5`,
+	} {
+		auth, ok, err := d.Authenticate(req, challenge(v))
+		if err != nil || !ok {
+			t.Fatalf("Authenticate(%q) = %v, %v", v, ok, err)
+		}
+		if !checkDigest(t, auth, "GET", "/dir/index.html?x=1", d.Username, d.Password) {
+			t.Errorf("Authenticate(%q) = %q; wrong response", v, auth)
+		}
+	}
+
+	auth, _, _ := d.Authenticate(req, challenge(`Digest realm="r", nonce="dcd98b", qop=auth`))
+	if !strings.Contains(auth, "nc=00000002") {
+		t.Errorf("second use of a nonce: %q; want nc=00000002", auth)
+	}
+
+	for _, v := range []string{
+		`Basic realm="r"`,
+		`Digest realm="r", nonce="n", qop="auth-int"`,
+		`Digest realm="r", nonce="n", algorithm=SHA-512`,
+		`Digest realm="r"`,
+	} {
+		if auth, ok, err := d.Authenticate(req, challenge(v)); ok || err != nil {
+			t.Errorf("Authenticate(%q) = %q, %v, %v; want not ok", v, auth, ok, err)
+		}
+	}
+}
+
+func TestTransportAuthenticator(t *testing.T) {
+	const user, pass = "user", "pass"
+	var calls atomic.Int32
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		auth := r.Header.Get("Authorization")
+		if auth == "" || !checkDigest(t, auth, r.Method, r.URL.RequestURI(), user, pass) {
+			w.Header().Set("Www-Authenticate", `Digest realm="test", nonce="abc", qop="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(body)
+	}))
+	tr := &Transport{Authenticator: &DigestAuth{Username: user, Password: pass}}
+	defer tr.CloseIdleConnections()
+
+	tests := []struct {
+		name      string
+		method    string
+		body      string
+		idemKey   bool
+		wantCode  int
+		wantCalls int32
+	}{
+		{"GET", "GET", "", false, 200, 2},
+		{"idempotent POST", "POST", "data", true, 200, 2},
+		{"POST", "POST", "data", false, 401, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls.Store(0)
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req := mustNewRequest(t, tt.method, url+"/p?q=1", body)
+			if tt.idemKey {
+				req.Header.Set("Idempotency-Key", "k")
+			}
+			res, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != tt.wantCode || calls.Load() != tt.wantCalls {
+				t.Errorf("status = %d after %d requests; want %d after %d", res.StatusCode, calls.Load(), tt.wantCode, tt.wantCalls)
+			}
+			if res.StatusCode == 200 && string(got) != tt.body {
+				t.Errorf("body echoed = %q; want %q", got, tt.body)
+			}
+			if req.Header.Get("Authorization") != "" {
+				t.Error("caller's request modified")
+			}
+		})
+	}
+}
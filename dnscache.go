@@ -0,0 +1,161 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// A HostResolver looks up the addresses of a host, as *net.Resolver
+// does.
+//
+// A HostResolver that also has a method
+//
+//	LookupHostTTL(ctx context.Context, host string) (addrs []string, ttl time.Duration, err error)
+//
+// reports how long its results may be cached, which DNSCache uses
+// instead of calling LookupHost.
+type HostResolver interface {
+	LookupHost(ctx context.Context, host string) (addrs []string, err error)
+}
+
+type hostResolverTTL interface {
+	LookupHostTTL(ctx context.Context, host string) (addrs []string, ttl time.Duration, err error)
+}
+
+// A DNSCache caches host name lookups for the dials of a Transport;
+// see Transport.DNSCache. It is safe for concurrent use, and
+// concurrent lookups of the same host share a single resolver call.
+// Expired results are dropped as new hosts are looked up.
+//
+// The fields must not be changed after the DNSCache is first used.
+type DNSCache struct {
+	// Resolver looks up host names.
+	// If nil, net.DefaultResolver is used.
+	Resolver HostResolver
+
+	// MinTTL and MaxTTL bound how long a successful lookup is
+	// cached. A result without a TTL from the resolver is cached
+	// for MaxTTL. Zero MaxTTL means one minute.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+
+	// NegativeTTL is how long a lookup that found no such host is
+	// cached. Other lookup errors are never cached.
+	// Zero means not to cache failed lookups.
+	NegativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*dnsCacheEntry
+	sweepAt int // len(entries) at which to next drop expired entries
+}
+
+type dnsCacheEntry struct {
+	done    chan struct{} // closed when the lookup completes
+	addrs   []string
+	err     error
+	expires time.Time
+}
+
+const (
+	defaultDNSCacheTTL = time.Minute
+	minDNSCacheSweep   = 64
+)
+
+// LookupHost returns the addresses of host, from the cache if a
+// lookup for it has not expired.
+func (c *DNSCache) LookupHost(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	if ok {
+		select {
+		case <-e.done:
+			if time.Now().After(e.expires) {
+				ok = false
+			}
+		default:
+		}
+	}
+	if !ok {
+		e = &dnsCacheEntry{done: make(chan struct{})}
+		if c.entries == nil {
+			c.entries = make(map[string]*dnsCacheEntry)
+		}
+		if len(c.entries) >= c.sweepAt {
+			c.sweepLocked()
+		}
+		c.entries[host] = e
+		go c.lookup(host, e)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-e.done:
+		return e.addrs, e.err
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
+
+// sweepLocked drops the expired entries, so that hosts that are no
+// longer dialed do not stay in the cache. The next sweep is deferred
+// until the cache has doubled in size, keeping the cost of sweeps
+// proportional to the number of lookups.
+func (c *DNSCache) sweepLocked() {
+	now := time.Now()
+	for host, e := range c.entries {
+		select {
+		case <-e.done:
+			if now.After(e.expires) {
+				delete(c.entries, host)
+			}
+		default:
+		}
+	}
+	c.sweepAt = max(2*len(c.entries), minDNSCacheSweep)
+}
+
+// lookup resolves host into e. It runs detached from any caller's
+// context, so that a canceled dial does not fail the lookups that
+// share it.
+func (c *DNSCache) lookup(host string, e *dnsCacheEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var r HostResolver = net.DefaultResolver
+	if c.Resolver != nil {
+		r = c.Resolver
+	}
+	ttl := time.Duration(-1)
+	if rt, ok := r.(hostResolverTTL); ok {
+		e.addrs, ttl, e.err = rt.LookupHostTTL(ctx, host)
+	} else {
+		e.addrs, e.err = r.LookupHost(ctx, host)
+	}
+
+	maxTTL := c.MaxTTL
+	if maxTTL <= 0 {
+		maxTTL = defaultDNSCacheTTL
+	}
+	var dnsErr *net.DNSError
+	switch {
+	case e.err == nil:
+		if ttl < 0 {
+			ttl = maxTTL
+		}
+		ttl = min(max(ttl, c.MinTTL), maxTTL)
+	case errors.As(e.err, &dnsErr) && dnsErr.IsNotFound:
+		ttl = c.NegativeTTL
+	default:
+		ttl = 0
+	}
+
+	c.mu.Lock()
+	e.expires = time.Now().Add(ttl)
+	if ttl <= 0 && c.entries[host] == e {
+		delete(c.entries, host)
+	}
+	c.mu.Unlock()
+	close(e.done)
+}
@@ -0,0 +1,189 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeResolver answers lookups from addrs, with an optional TTL, and
+// counts the calls.
+type fakeResolver struct {
+	addrs map[string][]string
+	ttl   time.Duration // if non-zero, returned by LookupHostTTL
+	calls atomic.Int32
+	block chan struct{} // if non-nil, lookups wait for it to close
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	addrs, _, err := r.LookupHostTTL(ctx, host)
+	return addrs, err
+}
+
+func (r *fakeResolver) LookupHostTTL(ctx context.Context, host string) ([]string, time.Duration, error) {
+	r.calls.Add(1)
+	if r.block != nil {
+		<-r.block
+	}
+	addrs, ok := r.addrs[host]
+	if !ok {
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	ttl := r.ttl
+	if ttl == 0 {
+		ttl = -1
+	}
+	return addrs, ttl, nil
+}
+
+func TestDNSCache(t *testing.T) {
+	r := &fakeResolver{addrs: map[string][]string{"a.test": {"192.0.2.1"}}, ttl: 50 * time.Millisecond}
+	c := &DNSCache{Resolver: r}
+	ctx := context.Background()
+	for range 3 {
+		addrs, err := c.LookupHost(ctx, "a.test")
+		if err != nil || len(addrs) != 1 || addrs[0] != "192.0.2.1" {
+			t.Fatalf("LookupHost = %q, %v; want [192.0.2.1]", addrs, err)
+		}
+	}
+	if n := r.calls.Load(); n != 1 {
+		t.Errorf("resolver called %d times; want 1", n)
+	}
+	time.Sleep(100 * time.Millisecond)
+	c.LookupHost(ctx, "a.test")
+	if n := r.calls.Load(); n != 2 {
+		t.Errorf("after TTL: resolver called %d times; want 2", n)
+	}
+}
+
+func TestDNSCacheTTLBounds(t *testing.T) {
+	r := &fakeResolver{addrs: map[string][]string{"a.test": {"192.0.2.1"}}, ttl: time.Hour}
+	c := &DNSCache{Resolver: r, MaxTTL: 50 * time.Millisecond}
+	ctx := context.Background()
+	c.LookupHost(ctx, "a.test")
+	time.Sleep(100 * time.Millisecond)
+	c.LookupHost(ctx, "a.test")
+	if n := r.calls.Load(); n != 2 {
+		t.Errorf("MaxTTL: resolver called %d times; want 2", n)
+	}
+
+	r = &fakeResolver{addrs: map[string][]string{"a.test": {"192.0.2.1"}}, ttl: time.Millisecond}
+	c = &DNSCache{Resolver: r, MinTTL: time.Hour}
+	c.LookupHost(ctx, "a.test")
+	time.Sleep(10 * time.Millisecond)
+	c.LookupHost(ctx, "a.test")
+	if n := r.calls.Load(); n != 1 {
+		t.Errorf("MinTTL: resolver called %d times; want 1", n)
+	}
+}
+
+func TestDNSCacheNegative(t *testing.T) {
+	ctx := context.Background()
+	for _, negTTL := range []time.Duration{0, time.Hour} {
+		r := &fakeResolver{}
+		c := &DNSCache{Resolver: r, NegativeTTL: negTTL}
+		for range 2 {
+			_, err := c.LookupHost(ctx, "missing.test")
+			var dnsErr *net.DNSError
+			if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+				t.Fatalf("LookupHost error = %v; want not found", err)
+			}
+		}
+		want := int32(2)
+		if negTTL > 0 {
+			want = 1
+		}
+		if n := r.calls.Load(); n != want {
+			t.Errorf("NegativeTTL %v: resolver called %d times; want %d", negTTL, n, want)
+		}
+	}
+}
+
+func TestDNSCacheSharedLookup(t *testing.T) {
+	r := &fakeResolver{addrs: map[string][]string{"a.test": {"192.0.2.1"}}, block: make(chan struct{})}
+	c := &DNSCache{Resolver: r}
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.LookupHost(context.Background(), "a.test"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// A canceled caller gives up without failing the others.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.LookupHost(ctx, "a.test"); err != context.Canceled {
+		t.Errorf("canceled LookupHost error = %v; want context.Canceled", err)
+	}
+	close(r.block)
+	wg.Wait()
+	if n := r.calls.Load(); n != 1 {
+		t.Errorf("resolver called %d times; want 1", n)
+	}
+}
+
+func TestDNSCacheEvictsExpired(t *testing.T) {
+	r := &fakeResolver{addrs: map[string][]string{}, ttl: time.Millisecond}
+	for i := range 1000 {
+		r.addrs["h"+strconv.Itoa(i)+".test"] = []string{"192.0.2.1"}
+	}
+	c := &DNSCache{Resolver: r}
+	for i := range 1000 {
+		if _, err := c.LookupHost(context.Background(), "h"+strconv.Itoa(i)+".test"); err != nil {
+			t.Fatal(err)
+		}
+		if i%100 == 99 {
+			time.Sleep(2 * time.Millisecond)
+		}
+	}
+	c.mu.Lock()
+	n := len(c.entries)
+	c.mu.Unlock()
+	if n > 300 {
+		t.Errorf("cache holds %d entries after 1000 expired lookups; want expired entries dropped", n)
+	}
+}
+
+func TestTransportDNSCache(t *testing.T) {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host)
+	}))
+	_, port, _ := net.SplitHostPort(url[len("http://"):])
+	r := &fakeResolver{addrs: map[string][]string{"example.test": {"192.0.2.1", "127.0.0.1"}}}
+	var dialed []string
+	tr := &Transport{
+		DNSCache: &DNSCache{Resolver: r},
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			if addr == net.JoinHostPort("192.0.2.1", port) {
+				return nil, errors.New("unreachable")
+			}
+			return new(net.Dialer).DialContext(ctx, network, addr)
+		},
+	}
+	defer tr.CloseIdleConnections()
+	res, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://example.test:"+port+"/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if want := "example.test:" + port; string(b) != want {
+		t.Errorf("Host = %q; want %q", b, want)
+	}
+	want := []string{net.JoinHostPort("192.0.2.1", port), net.JoinHostPort("127.0.0.1", port)}
+	if len(dialed) != 2 || dialed[0] != want[0] || dialed[1] != want[1] {
+		t.Errorf("dialed %q; want %q", dialed, want)
+	}
+}
@@ -0,0 +1,98 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// defaultMaxFormSize is the body limit net/http applies to forms.
+const defaultMaxFormSize = 10 << 20
+
+// FormTooLargeError is returned by ParseForm when the request body is
+// larger than the allowed size.
+type FormTooLargeError struct {
+	Limit int64
+}
+
+func (e *FormTooLargeError) Error() string {
+	return fmt.Sprintf("http: form body larger than %d bytes", e.Limit)
+}
+
+// ParseForm parses the form values of req with net/http semantics: for
+// POST, PUT and PATCH requests with an application/x-www-form-urlencoded
+// body, the body values come first, followed by the values of the URL
+// query. Other requests only yield the query values. As with
+// http.Request.ParseForm, the result is also stored in req.Form, and
+// the body values in req.PostForm.
+//
+// At most maxBytes of the body are read, or 10 MB, like net/http, if
+// maxBytes is zero or less. A larger body fails with a
+// *FormTooLargeError. Either way, the bytes that were read are put
+// back in front of req.Body, and after a successful parse req.GetBody
+// returns the body again, so it can still be read or resent.
+func ParseForm(req *http.Request, maxBytes int64) (url.Values, error) {
+	post := make(url.Values)
+	if req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH" {
+		var err error
+		if post, err = parseFormBody(req, maxBytes); err != nil {
+			return nil, err
+		}
+	}
+	form := make(url.Values)
+	for k, vs := range post {
+		form[k] = append(form[k], vs...)
+	}
+	if req.URL != nil {
+		query, err := url.ParseQuery(req.URL.RawQuery)
+		if err != nil {
+			return nil, err
+		}
+		for k, vs := range query {
+			form[k] = append(form[k], vs...)
+		}
+	}
+	req.PostForm = post
+	req.Form = form
+	return form, nil
+}
+
+func parseFormBody(req *http.Request, maxBytes int64) (url.Values, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return make(url.Values), nil
+	}
+	ct := req.Header.Get("Content-Type")
+	if ct == "" {
+		// RFC 7231, section 3.1.1.5 - empty type
+		//   MAY be treated as application/octet-stream
+		ct = "application/octet-stream"
+	}
+	ct, _, err := mime.ParseMediaType(ct)
+	if err != nil || ct != "application/x-www-form-urlencoded" {
+		return make(url.Values), nil
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxFormSize
+	}
+	body := req.Body
+	buf, err := io.ReadAll(io.LimitReader(body, maxBytes+1))
+	if err != nil || int64(len(buf)) > maxBytes {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), errReader{err}, body), body}
+		if err == nil {
+			err = &FormTooLargeError{Limit: maxBytes}
+		}
+		return nil, err
+	}
+	body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(buf))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	return url.ParseQuery(string(buf))
+}
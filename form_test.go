@@ -0,0 +1,74 @@
+package http
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func newFormRequest(t *testing.T, method, url, body string) *http.Request {
+	t.Helper()
+	req := mustNewRequest(t, method, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestParseForm(t *testing.T) {
+	req := newFormRequest(t, "POST", "http://example.com/?a=query&q=1", "a=body&b=2")
+	form, err := ParseForm(req, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := form["a"]; len(got) != 2 || got[0] != "body" || got[1] != "query" {
+		t.Errorf("form[a] = %q; want [body query]", got)
+	}
+	if form.Get("b") != "2" || form.Get("q") != "1" {
+		t.Errorf("form = %v; want b=2 and q=1", form)
+	}
+	if req.PostForm.Get("q") != "" || req.PostForm.Get("b") != "2" {
+		t.Errorf("PostForm = %v; want only body values", req.PostForm)
+	}
+	if req.Form.Get("q") != "1" {
+		t.Errorf("Form = %v; want the result", req.Form)
+	}
+	if b, _ := io.ReadAll(req.Body); string(b) != "a=body&b=2" {
+		t.Errorf("Body after ParseForm = %q", b)
+	}
+	rc, err := req.GetBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(rc); string(b) != "a=body&b=2" {
+		t.Errorf("GetBody after ParseForm = %q", b)
+	}
+}
+
+func TestParseFormIgnoresBody(t *testing.T) {
+	req := newFormRequest(t, "GET", "http://example.com/?q=1", "b=2")
+	form, err := ParseForm(req, 0)
+	if err != nil || form.Get("b") != "" || form.Get("q") != "1" {
+		t.Errorf("GET: ParseForm = %v, %v; want only q=1", form, err)
+	}
+
+	req = mustNewRequest(t, "POST", "http://example.com/", strings.NewReader("b=2"))
+	req.Header.Set("Content-Type", "text/plain")
+	form, err = ParseForm(req, 0)
+	if err != nil || len(form) != 0 {
+		t.Errorf("text/plain: ParseForm = %v, %v; want no values", form, err)
+	}
+}
+
+func TestParseFormTooLarge(t *testing.T) {
+	body := "a=" + strings.Repeat("x", 100)
+	req := newFormRequest(t, "POST", "http://example.com/", body)
+	_, err := ParseForm(req, 10)
+	var tooLarge *FormTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Limit != 10 {
+		t.Fatalf("error = %v; want FormTooLargeError with Limit 10", err)
+	}
+	if b, _ := io.ReadAll(req.Body); string(b) != body {
+		t.Errorf("Body after failed ParseForm = %q; want the original body", b)
+	}
+}
@@ -0,0 +1,228 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strings"
+
+	"github.com/puernya/go-http/internal/ascii"
+)
+
+// A FramingWarning describes a property of a request's message framing
+// headers that can cause two HTTP implementations to disagree on where
+// the request body ends, as exploited by request smuggling.
+type FramingWarning int
+
+const (
+	// FramingContentLengthWithTransferEncoding reports that both
+	// Content-Length and Transfer-Encoding are present.
+	FramingContentLengthWithTransferEncoding FramingWarning = iota + 1
+
+	// FramingDuplicateContentLength reports more than one
+	// Content-Length value, whether in separate fields or a list.
+	FramingDuplicateContentLength
+
+	// FramingPaddedHeader reports a Content-Length or Transfer-Encoding
+	// field whose name or value carries leading or trailing whitespace.
+	// For a request read by this package, that is whitespace in the
+	// raw line other than a single space after the colon, or an
+	// obs-fold continuation line.
+	FramingPaddedHeader
+
+	// FramingNonChunkedFinalCoding reports a Transfer-Encoding whose
+	// final coding is not "chunked".
+	FramingNonChunkedFinalCoding
+)
+
+func (w FramingWarning) String() string {
+	switch w {
+	case FramingContentLengthWithTransferEncoding:
+		return "Content-Length with Transfer-Encoding"
+	case FramingDuplicateContentLength:
+		return "duplicate Content-Length"
+	case FramingPaddedHeader:
+		return "whitespace-padded framing header"
+	case FramingNonChunkedFinalCoding:
+		return "non-chunked final transfer coding"
+	}
+	return "unknown framing warning"
+}
+
+// AnalyzeFraming reports the framing discrepancies found in req's
+// Content-Length and Transfer-Encoding headers and its TransferEncoding
+// field, in the order they are declared above. Each warning is reported
+// at most once. A nil result means no discrepancy was found.
+//
+// For a request read by this package, such as by a Server,
+// ReadRequestInto or ReadRequestWithProxyProto, AnalyzeFraming also
+// reports what the raw header showed before reading normalized it:
+// reading drops Content-Length when the request is chunked, merges
+// repeated identical Content-Length values, and strips the whitespace
+// around values. Whitespace padding is only seen in header blocks that
+// fit in the reader's buffer.
+//
+// AnalyzeFraming does not modify req. A proxy may use it to reject
+// requests that other servers could frame differently.
+func AnalyzeFraming(req *http.Request) []FramingWarning {
+	warnings := framingWarnings(req.Header, req.TransferEncoding, false)
+	if recorded, ok := req.Context().Value(framingContextKey).([]FramingWarning); ok {
+		warnings = mergeFramingWarnings(warnings, recorded)
+	}
+	return warnings
+}
+
+var framingContextKey = &contextKey{"framing-warnings"}
+
+// recordFraming attaches the framing warnings of a request being read
+// to its context, for AnalyzeFraming. It is called before readTransfer
+// normalizes the header, with padded telling whether the raw header
+// block had whitespace-padded framing lines.
+func recordFraming(req *http.Request, padded bool) {
+	if warnings := framingWarnings(req.Header, nil, padded); warnings != nil {
+		*req = *req.WithContext(context.WithValue(req.Context(), framingContextKey, warnings))
+	}
+}
+
+// withFraming returns ctx with the framing warnings recorded for req,
+// if any, so that they survive replacing the context of req.
+func withFraming(ctx context.Context, req *http.Request) context.Context {
+	if warnings := req.Context().Value(framingContextKey); warnings != nil {
+		return context.WithValue(ctx, framingContextKey, warnings)
+	}
+	return ctx
+}
+
+// framingWarnings analyzes the framing header fields of h. te is used
+// as the transfer codings if h has no Transfer-Encoding field, and
+// padded adds FramingPaddedHeader.
+func framingWarnings(h http.Header, te []string, padded bool) []FramingWarning {
+	var cl, hte []string
+	for k, vv := range h {
+		name := textproto.TrimString(k)
+		isCL := ascii.EqualFold(name, "Content-Length")
+		isTE := ascii.EqualFold(name, "Transfer-Encoding")
+		if !isCL && !isTE {
+			continue
+		}
+		if name != k {
+			padded = true
+		}
+		for _, v := range vv {
+			if textproto.TrimString(v) != v {
+				padded = true
+			}
+			for f := range strings.SplitSeq(v, ",") {
+				if f = textproto.TrimString(f); f == "" {
+					continue
+				}
+				if isCL {
+					cl = append(cl, f)
+				} else {
+					hte = append(hte, f)
+				}
+			}
+		}
+	}
+	if len(hte) > 0 {
+		te = hte
+	}
+
+	var warnings []FramingWarning
+	if len(cl) > 0 && len(te) > 0 {
+		warnings = append(warnings, FramingContentLengthWithTransferEncoding)
+	}
+	if len(cl) > 1 {
+		warnings = append(warnings, FramingDuplicateContentLength)
+	}
+	if padded {
+		warnings = append(warnings, FramingPaddedHeader)
+	}
+	if n := len(te); n > 0 && !ascii.EqualFold(te[n-1], "chunked") {
+		warnings = append(warnings, FramingNonChunkedFinalCoding)
+	}
+	return warnings
+}
+
+// mergeFramingWarnings returns the warnings in a or b, in the order
+// they are declared.
+func mergeFramingWarnings(a, b []FramingWarning) []FramingWarning {
+	var merged []FramingWarning
+	for w := FramingContentLengthWithTransferEncoding; w <= FramingNonChunkedFinalCoding; w++ {
+		if slices.Contains(a, w) || slices.Contains(b, w) {
+			merged = append(merged, w)
+		}
+	}
+	return merged
+}
+
+// rawFramingPadded reports whether the header block at the start of
+// b has a Content-Length or Transfer-Encoding line with whitespace
+// before its colon, around its value other than the usual single
+// space after the colon, or continued on an obs-fold line. It fills b
+// until the block is buffered, as reading the header would, and
+// reports false if the block does not fit in b's buffer or reading it
+// fails, leaving the error to the header parser.
+func rawFramingPadded(b *bufio.Reader) bool {
+	buf, _ := b.Peek(b.Buffered())
+	n := headerBlockLen(buf)
+	for n < 0 {
+		if len(buf) >= b.Size() {
+			return false
+		}
+		if _, err := b.Peek(len(buf) + 1); err != nil {
+			return false
+		}
+		buf, _ = b.Peek(b.Buffered())
+		n = headerBlockLen(buf)
+	}
+
+	framing := false // the previous line is a framing field
+	for line := range bytes.Lines(buf[:n]) {
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			if framing {
+				return true
+			}
+			continue
+		}
+		name, value, ok := bytes.Cut(line, []byte(":"))
+		trimmed := string(bytes.TrimRight(name, " \t"))
+		framing = ok && (ascii.EqualFold(trimmed, "Content-Length") || ascii.EqualFold(trimmed, "Transfer-Encoding"))
+		if !framing {
+			continue
+		}
+		if len(trimmed) != len(name) {
+			return true
+		}
+		value, _ = bytes.CutPrefix(value, []byte(" "))
+		if len(value) > 0 && (value[0] == ' ' || value[0] == '\t' ||
+			value[len(value)-1] == ' ' || value[len(value)-1] == '\t') {
+			return true
+		}
+	}
+	return false
+}
+
+// headerBlockLen returns the length of the header block at the start
+// of buf, through the empty line ending it, or -1 if buf does not hold
+// the whole block.
+func headerBlockLen(buf []byte) int {
+	for i := 0; i <= len(buf); {
+		switch rest := buf[i:]; {
+		case bytes.HasPrefix(rest, []byte("\r\n")):
+			return i + 2
+		case bytes.HasPrefix(rest, []byte("\n")):
+			return i + 1
+		}
+		j := bytes.IndexByte(buf[i:], '\n')
+		if j < 0 {
+			return -1
+		}
+		i += j + 1
+	}
+	return -1
+}
@@ -0,0 +1,183 @@
+package http
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestAnalyzeFraming(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		te     []string
+		want   []FramingWarning
+	}{
+		{
+			name:   "content-length only",
+			header: http.Header{"Content-Length": {"5"}},
+		},
+		{
+			name:   "chunked only",
+			header: http.Header{"Transfer-Encoding": {"chunked"}},
+		},
+		{
+			name:   "both",
+			header: http.Header{"Content-Length": {"5"}, "Transfer-Encoding": {"chunked"}},
+			want:   []FramingWarning{FramingContentLengthWithTransferEncoding},
+		},
+		{
+			name:   "both, parsed transfer encoding",
+			header: http.Header{"Content-Length": {"5"}},
+			te:     []string{"chunked"},
+			want:   []FramingWarning{FramingContentLengthWithTransferEncoding},
+		},
+		{
+			name:   "duplicate content-length list",
+			header: http.Header{"Content-Length": {"5, 5"}},
+			want:   []FramingWarning{FramingDuplicateContentLength},
+		},
+		{
+			name:   "duplicate content-length fields",
+			header: http.Header{"Content-Length": {"5", "6"}},
+			want:   []FramingWarning{FramingDuplicateContentLength},
+		},
+		{
+			name:   "padded name",
+			header: http.Header{"Transfer-Encoding ": {"chunked"}},
+			want:   []FramingWarning{FramingPaddedHeader},
+		},
+		{
+			name:   "padded value",
+			header: http.Header{"Content-Length": {" 5"}},
+			want:   []FramingWarning{FramingPaddedHeader},
+		},
+		{
+			name:   "non-chunked final coding",
+			header: http.Header{"Transfer-Encoding": {"chunked, gzip"}},
+			want:   []FramingWarning{FramingNonChunkedFinalCoding},
+		},
+		{
+			name:   "everything",
+			header: http.Header{"Content-Length": {"5", "5 "}, "Transfer-Encoding": {"gzip"}},
+			want: []FramingWarning{
+				FramingContentLengthWithTransferEncoding,
+				FramingDuplicateContentLength,
+				FramingPaddedHeader,
+				FramingNonChunkedFinalCoding,
+			},
+		},
+	}
+	for _, tt := range tests {
+		req := &http.Request{Header: tt.header, TransferEncoding: tt.te}
+		if got := AnalyzeFraming(req); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: AnalyzeFraming = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyzeFramingReadRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string // request line and header lines, without the final CRLF
+		want []FramingWarning
+	}{
+		{
+			name: "content-length only",
+			raw:  "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n",
+		},
+		{
+			name: "content-length without space",
+			raw:  "POST / HTTP/1.1\r\nHost: x\r\nContent-Length:5\r\n",
+		},
+		{
+			name: "both",
+			raw:  "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n",
+			want: []FramingWarning{FramingContentLengthWithTransferEncoding},
+		},
+		{
+			name: "duplicate identical content-length",
+			raw:  "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\nContent-Length: 5\r\n",
+			want: []FramingWarning{FramingDuplicateContentLength},
+		},
+		{
+			name: "padded value",
+			raw:  "POST / HTTP/1.1\r\nHost: x\r\nContent-Length:  5\r\n",
+			want: []FramingWarning{FramingPaddedHeader},
+		},
+		{
+			name: "trailing whitespace",
+			raw:  "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\t\r\n",
+			want: []FramingWarning{FramingPaddedHeader},
+		},
+		{
+			name: "obs-fold",
+			raw:  "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding:\r\n chunked\r\n",
+			want: []FramingWarning{FramingPaddedHeader},
+		},
+		{
+			name: "other padded field",
+			raw:  "POST / HTTP/1.1\r\nHost:   x\r\nContent-Length: 5\r\n",
+		},
+		{
+			name: "HTTP/1.0 transfer-encoding",
+			raw:  "POST / HTTP/1.0\r\nTransfer-Encoding: gzip\r\nContent-Length: 5\r\n",
+			want: []FramingWarning{FramingContentLengthWithTransferEncoding, FramingNonChunkedFinalCoding},
+		},
+	}
+	const body = "5\r\nhello\r\n0\r\n\r\n"
+	for _, tt := range tests {
+		for _, oneByte := range []bool{false, true} {
+			var r io.Reader = strings.NewReader(tt.raw + "\r\n" + body)
+			if oneByte {
+				// The header block arrives in pieces.
+				r = iotest.OneByteReader(r)
+			}
+			req, err := readRequest(bufio.NewReader(r), nil)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if got := AnalyzeFraming(req); !slices.Equal(got, tt.want) {
+				t.Errorf("%s (one byte reads %v): AnalyzeFraming = %v; want %v", tt.name, oneByte, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestAnalyzeFramingReadRequestInto(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader(
+		"POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 1\r\nContent-Length: 1\r\n\r\na" +
+			"POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 1\r\n\r\nb"))
+	req := new(http.Request)
+	for i, want := range [][]FramingWarning{{FramingDuplicateContentLength}, nil} {
+		if err := ReadRequestInto(br, req); err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, req.Body)
+		if got := AnalyzeFraming(req); !slices.Equal(got, want) {
+			t.Errorf("request %d: AnalyzeFraming = %v; want %v", i, got, want)
+		}
+	}
+}
+
+func TestAnalyzeFramingServer(t *testing.T) {
+	warnings := make(chan []FramingWarning, 1)
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		warnings <- AnalyzeFraming(r)
+	}))
+	c, err := net.Dial("tcp", url[len("http://"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.WriteString(c, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n")
+	want := []FramingWarning{FramingContentLengthWithTransferEncoding}
+	if got := <-warnings; !slices.Equal(got, want) {
+		t.Errorf("AnalyzeFraming in handler = %v; want %v", got, want)
+	}
+}
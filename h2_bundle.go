@@ -896,7 +896,7 @@ type http2addConnCall struct {
 }
 
 func (c *http2addConnCall) run(t *http2Transport, key string, nc net.Conn) {
-	cc, err := t.NewClientConn(nc)
+	cc, err := t.newClientConn(nc, http2connPoolKeyAddr(key), t.disableKeepAlives(), nil)
 
 	p := c.p
 	p.mu.Lock()
@@ -1060,6 +1060,10 @@ type http2http2Config struct {
 	WriteByteTimeout             time.Duration
 	PermitProhibitedCipherSuites bool
 	CountError                   func(errType string)
+	EnableConnectProtocol        bool
+	Settings                     []http2Setting
+	ConnectionWindowIncrement    uint32
+	SendGREASE                   bool
 }
 
 // configFromServer merges configuration settings from
@@ -1086,7 +1090,7 @@ func http2configFromServer(h1 *Server, h2 *http2Server) http2http2Config {
 
 // configFromTransport merges configuration settings from h2 and h2.t1.HTTP2
 // (the net/http Transport).
-func http2configFromTransport(h2 *http2Transport) http2http2Config {
+func http2configFromTransport(h2 *http2Transport, addr string) http2http2Config {
 	conf := http2http2Config{
 		StrictMaxConcurrentRequests: h2.StrictMaxConcurrentStreams,
 		MaxEncoderHeaderTableSize:   h2.MaxEncoderHeaderTableSize,
@@ -1108,6 +1112,7 @@ func http2configFromTransport(h2 *http2Transport) http2http2Config {
 
 	if h2.t1 != nil {
 		http2fillNetHTTPConfig(&conf, h2.t1.HTTP2)
+		http2fillNetHTTPConfig(&conf, h2.t1.http2ConfigForAddr(addr))
 	}
 	http2setConfigDefaults(&conf, false)
 	return conf
@@ -1193,6 +1198,21 @@ func http2fillNetHTTPConfig(conf *http2http2Config, h2 *HTTP2Config) {
 	if h2.CountError != nil {
 		conf.CountError = h2.CountError
 	}
+	if h2.EnableConnectProtocol {
+		conf.EnableConnectProtocol = true
+	}
+	if h2.Settings != nil {
+		conf.Settings = make([]http2Setting, len(h2.Settings))
+		for i, s := range h2.Settings {
+			conf.Settings[i] = http2Setting{ID: http2SettingID(s.ID), Val: s.Val}
+		}
+	}
+	if h2.ConnectionWindowIncrement != 0 {
+		conf.ConnectionWindowIncrement = h2.ConnectionWindowIncrement
+	}
+	if h2.SendGREASE {
+		conf.SendGREASE = true
+	}
 }
 
 func http2http2ConfigStrictMaxConcurrentRequests(h2 *HTTP2Config) bool {
@@ -1712,7 +1732,9 @@ var http2frameParsers = [...]http2frameParser{
 
 func http2typeFrameParser(t http2FrameType) http2frameParser {
 	if int(t) < len(http2frameParsers) {
-		return http2frameParsers[t]
+		if f := http2frameParsers[t]; f != nil {
+			return f
+		}
 	}
 	return http2parseUnknownFrame
 }
@@ -4553,6 +4575,7 @@ func (s *http2Server) serveConn(c net.Conn, opts *http2ServeConnOpts, newf func(
 		serveG:                      http2newGoroutineLock(),
 		pushEnabled:                 true,
 		sawClientPreface:            opts.SawClientPreface,
+		extendedConnectEnabled:      conf.EnableConnectProtocol || !http2disableExtendedConnectProtocol,
 	}
 	if newf != nil {
 		newf(sc)
@@ -4709,6 +4732,7 @@ type http2serverConn struct {
 	serveG                      http2goroutineLock // used to verify funcs are on serve()
 	pushEnabled                 bool
 	sawClientPreface            bool // preface has already been read, used in h2c upgrade
+	extendedConnectEnabled      bool // SETTINGS_ENABLE_CONNECT_PROTOCOL advertised
 	sawFirstSettings            bool // got the initial SETTINGS frame after the preface
 	needToSendSettingsAck       bool
 	unackedSettings             int    // how many SETTINGS have we sent without ACKs?
@@ -5056,7 +5080,7 @@ func (sc *http2serverConn) serve(conf http2http2Config) {
 		{http2SettingHeaderTableSize, conf.MaxDecoderHeaderTableSize},
 		{http2SettingInitialWindowSize, uint32(sc.initialStreamRecvWindowSize)},
 	}
-	if !http2disableExtendedConnectProtocol {
+	if sc.extendedConnectEnabled {
 		settings = append(settings, http2Setting{http2SettingEnableConnectProtocol, 1})
 	}
 	if sc.writeSchedIgnoresRFC7540() {
@@ -6411,7 +6435,7 @@ func (sc *http2serverConn) newWriterAndRequest(st *http2stream, f *http2MetaHead
 	}
 
 	// extended connect is disabled, so we should not see :protocol
-	if http2disableExtendedConnectProtocol && rp.Protocol != "" {
+	if !sc.extendedConnectEnabled && rp.Protocol != "" {
 		return nil, nil, sc.countError("bad_connect", http2streamError(f.StreamID, http2ErrCodeProtocol))
 	}
 
@@ -7724,6 +7748,13 @@ func (t *http2Transport) maxHeaderListSize() uint32 {
 	return uint32(n)
 }
 
+func (t *http2Transport) maxRequestsPerConn() int64 {
+	if t.t1 != nil {
+		return int64(t.t1.MaxRequestsPerConn)
+	}
+	return 0
+}
+
 func (t *http2Transport) disableCompression() bool {
 	return t.DisableCompression || (t.t1 != nil && t.t1.DisableCompression)
 }
@@ -7748,21 +7779,7 @@ func http2configureTransports(t1 *Transport) (*http2Transport, error) {
 		t1.TLSClientConfig.NextProtos = append(t1.TLSClientConfig.NextProtos, "http/1.1")
 	}
 	upgradeFn := func(scheme, authority string, c net.Conn) http.RoundTripper {
-		addr := http2authorityAddr(scheme, authority)
-		if used, err := connPool.addConnIfNeeded(addr, t2, c); err != nil {
-			go c.Close()
-			return http2erringRoundTripper{err}
-		} else if !used {
-			// Turns out we don't need this c.
-			// For example, two goroutines made requests to the same host
-			// at the same time, both kicking off TCP dials. (since protocol
-			// was unknown)
-			go c.Close()
-		}
-		if scheme == "http" {
-			return (*http2unencryptedTransport)(t2)
-		}
-		return t2
+		return t2.upgradeConn(scheme, authority, "", c)
 	}
 	if t1.TLSNextProto == nil {
 		t1.TLSNextProto = make(map[string]func(string, TLSConn) http.RoundTripper)
@@ -7782,6 +7799,44 @@ func http2configureTransports(t1 *Transport) (*http2Transport, error) {
 	return t2, nil
 }
 
+// upgradeConn adds c, a connection dialed by t.t1 to authority, to
+// the connection pool, keyed by the connection label (see
+// WithConnLabel) as well as the address, and returns the RoundTripper
+// to use with it.
+func (t *http2Transport) upgradeConn(scheme, authority, label string, c net.Conn) http.RoundTripper {
+	connPool := t.ConnPool.(http2noDialClientConnPool)
+	key := http2connPoolKey(http2authorityAddr(scheme, authority), label)
+	if used, err := connPool.addConnIfNeeded(key, t, c); err != nil {
+		go c.Close()
+		return http2erringRoundTripper{err}
+	} else if !used {
+		// Turns out we don't need this c.
+		// For example, two goroutines made requests to the same host
+		// at the same time, both kicking off TCP dials. (since protocol
+		// was unknown)
+		go c.Close()
+	}
+	if scheme == "http" {
+		return (*http2unencryptedTransport)(t)
+	}
+	return t
+}
+
+// connPoolKey returns the connection pool key for connections to
+// addr with the given label.
+func http2connPoolKey(addr, label string) string {
+	if label == "" {
+		return addr
+	}
+	return addr + "|" + label
+}
+
+// connPoolKeyAddr returns the address part of a connection pool key.
+func http2connPoolKeyAddr(key string) string {
+	addr, _, _ := strings.Cut(key, "|")
+	return addr
+}
+
 // unencryptedTransport is a Transport with a RoundTrip method that
 // always permits http:// URLs.
 type http2unencryptedTransport http2Transport
@@ -7808,6 +7863,7 @@ func (t *http2Transport) initConnPool() {
 type http2ClientConn struct {
 	t             *http2Transport
 	tconn         net.Conn             // usually TLSConn, except specialized impls
+	addr          string               // host:port dialed, or empty if unknown
 	tlsState      *tls.ConnectionState // nil only for specialized impls
 	atomicReused  uint32               // whether conn is being reused; atomic
 	singleUse     bool                 // whether being used for a single http.Request
@@ -7841,6 +7897,7 @@ type http2ClientConn struct {
 	br               *bufio.Reader
 	lastActive       time.Time
 	lastIdle         time.Time // time last idle
+	createdAt        time.Time // when the conn was created; see Transport.ConnMaxLifetime
 	// Settings from peer: (also guarded by wmu)
 	maxFrameSize                uint32
 	maxConcurrentStreams        uint32
@@ -8090,13 +8147,18 @@ func (t *http2Transport) RoundTripOpt(req *http.Request, opt http2RoundTripOpt)
 		if !t.AllowHTTP && !opt.allowHTTP {
 			return nil, errors.New("http2: unencrypted HTTP/2 not enabled")
 		}
+		// AllowHTTP alone does not let a Transport configured for
+		// HTTP/1 start speaking h2c; that must be enabled explicitly.
+		if t.t1 != nil && !t.t1.unencryptedHTTP2Allowed(req) {
+			return nil, ErrUnencryptedHTTP2NotAllowed
+		}
 	default:
 		return nil, errors.New("http2: unsupported scheme")
 	}
 
 	addr := http2authorityAddr(req.URL.Scheme, req.URL.Host)
 	for retry := 0; ; retry++ {
-		cc, err := t.connPool().GetClientConn(req, addr)
+		cc, err := t.connPool().GetClientConn(req, http2connPoolKey(addr, connLabel(req.Context())))
 		if err != nil {
 			t.vlogf("http2: Transport failed to get client conn for %s: %v", addr, err)
 			return nil, err
@@ -8166,6 +8228,43 @@ func (t *http2Transport) CloseIdleConnections() {
 	}
 }
 
+// pingHost sends a PING on an existing connection to one of addrs,
+// under any connection label, and returns the round-trip time.
+func (t *http2Transport) pingHost(ctx context.Context, addrs []string) (time.Duration, error) {
+	var p *http2clientConnPool
+	switch cp := t.connPool().(type) {
+	case *http2clientConnPool:
+		p = cp
+	case http2noDialClientConnPool:
+		p = cp.http2clientConnPool
+	default:
+		return 0, ErrNoHTTP2Conn
+	}
+	var cc *http2ClientConn
+	p.mu.Lock()
+search:
+	for key, conns := range p.conns {
+		if !slices.Contains(addrs, http2connPoolKeyAddr(key)) {
+			continue
+		}
+		for _, c := range conns {
+			if c.CanTakeNewRequest() {
+				cc = c
+				break search
+			}
+		}
+	}
+	p.mu.Unlock()
+	if cc == nil {
+		return 0, ErrNoHTTP2Conn
+	}
+	start := time.Now()
+	if err := cc.Ping(ctx); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
 var (
 	http2errClientConnClosed         = errors.New("http2: client conn is closed")
 	http2errClientConnUnusable       = errors.New("http2: client conn not usable")
@@ -8226,7 +8325,7 @@ func http2canRetryError(err error) bool {
 
 func (t *http2Transport) dialClientConn(ctx context.Context, addr string, singleUse bool) (*http2ClientConn, error) {
 	if t.http2transportTestHooks != nil {
-		return t.newClientConn(nil, singleUse, nil)
+		return t.newClientConn(nil, addr, singleUse, nil)
 	}
 	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
@@ -8236,7 +8335,7 @@ func (t *http2Transport) dialClientConn(ctx context.Context, addr string, single
 	if err != nil {
 		return nil, err
 	}
-	return t.newClientConn(tconn, singleUse, nil)
+	return t.newClientConn(tconn, addr, singleUse, nil)
 }
 
 func (t *http2Transport) newTLSConfig(host string) *tls.Config {
@@ -8288,14 +8387,18 @@ func (t *http2Transport) expectContinueTimeout() time.Duration {
 }
 
 func (t *http2Transport) NewClientConn(c net.Conn) (*http2ClientConn, error) {
-	return t.newClientConn(c, t.disableKeepAlives(), nil)
+	return t.newClientConn(c, "", t.disableKeepAlives(), nil)
 }
 
-func (t *http2Transport) newClientConn(c net.Conn, singleUse bool, internalStateHook func()) (*http2ClientConn, error) {
-	conf := http2configFromTransport(t)
+// newClientConn creates a ClientConn for c. addr is the host:port the
+// connection was established to, used to look up per-host configuration;
+// it may be empty if unknown.
+func (t *http2Transport) newClientConn(c net.Conn, addr string, singleUse bool, internalStateHook func()) (*http2ClientConn, error) {
+	conf := http2configFromTransport(t, addr)
 	cc := &http2ClientConn{
 		t:                           t,
 		tconn:                       c,
+		addr:                        addr,
 		readerDone:                  make(chan struct{}),
 		nextStreamID:                1,
 		maxFrameSize:                16 << 10, // spec default
@@ -8313,6 +8416,7 @@ func (t *http2Transport) newClientConn(c net.Conn, singleUse bool, internalState
 		pings:                       make(map[[8]byte]chan struct{}),
 		reqHeaderMu:                 make(chan struct{}, 1),
 		lastActive:                  time.Now(),
+		createdAt:                   time.Now(),
 		internalStateHook:           internalStateHook,
 	}
 	if t.http2transportTestHooks != nil {
@@ -8363,11 +8467,37 @@ func (t *http2Transport) newClientConn(c net.Conn, singleUse bool, internalState
 	if maxHeaderTableSize != http2initialHeaderTableSize {
 		initialSettings = append(initialSettings, http2Setting{ID: http2SettingHeaderTableSize, Val: maxHeaderTableSize})
 	}
+	if conf.EnableConnectProtocol {
+		initialSettings = append(initialSettings, http2Setting{ID: http2SettingEnableConnectProtocol, Val: 1})
+	}
+
+	if conf.Settings != nil {
+		if err := cc.useInitialSettings(conf.Settings); err != nil {
+			cc.Close()
+			return nil, err
+		}
+		initialSettings = conf.Settings
+	}
+	connFlow := conf.MaxUploadBufferPerConnection
+	if inc := conf.ConnectionWindowIncrement; inc != 0 {
+		if inc > math.MaxInt32-http2initialWindowSize {
+			cc.Close()
+			return nil, fmt.Errorf("http2: invalid connection window increment %d", inc)
+		}
+		connFlow = int32(inc)
+	}
+
+	if conf.SendGREASE {
+		initialSettings = append(slices.Clip(initialSettings), http2greaseSetting())
+	}
 
 	cc.bw.Write(http2clientPreface)
 	cc.fr.WriteSettings(initialSettings...)
-	cc.fr.WriteWindowUpdate(0, uint32(conf.MaxUploadBufferPerConnection))
-	cc.inflow.init(conf.MaxUploadBufferPerConnection + http2initialWindowSize)
+	cc.fr.WriteWindowUpdate(0, uint32(connFlow))
+	if conf.SendGREASE {
+		cc.fr.WriteRawFrame(http2greaseFrameType(), 0, 0, http2greasePayload())
+	}
+	cc.inflow.init(connFlow + http2initialWindowSize)
 	cc.bw.Flush()
 	if cc.werr != nil {
 		cc.Close()
@@ -8384,6 +8514,59 @@ func (t *http2Transport) newClientConn(c net.Conn, singleUse bool, internalState
 	return cc, nil
 }
 
+// useInitialSettings validates settings, to be sent as the initial
+// SETTINGS frame of cc in place of the defaults, and adapts the limits
+// cc enforces on the peer to them.
+func (cc *http2ClientConn) useInitialSettings(settings []http2Setting) error {
+	// Settings left out take their protocol defaults, replacing the
+	// limits newClientConn derived from the config.
+	headerTableSize := uint32(http2initialHeaderTableSize)
+	cc.initialStreamRecvWindowSize = http2initialWindowSize
+	cc.fr.SetMaxReadFrameSize(http2minMaxFrameSize)
+	cc.fr.MaxHeaderListSize = 0 // the Framer's default
+	for _, s := range settings {
+		if err := s.Valid(); err != nil {
+			return fmt.Errorf("http2: invalid setting %v", s)
+		}
+		switch s.ID {
+		case http2SettingEnablePush:
+			if s.Val != 0 {
+				return fmt.Errorf("http2: invalid setting %v: push is not supported", s)
+			}
+		case http2SettingHeaderTableSize:
+			headerTableSize = s.Val
+		case http2SettingInitialWindowSize:
+			cc.initialStreamRecvWindowSize = int32(s.Val)
+		case http2SettingMaxFrameSize:
+			cc.fr.SetMaxReadFrameSize(s.Val)
+		case http2SettingMaxHeaderListSize:
+			cc.fr.MaxHeaderListSize = s.Val
+		}
+	}
+	cc.fr.ReadMetaHeaders = hpack.NewDecoder(headerTableSize, nil)
+	return nil
+}
+
+// greaseSetting returns a setting with a random identifier reserved
+// for greasing, of the form 0x?a?a, and a random value.
+func http2greaseSetting() http2Setting {
+	id := 0x0a0a + 0x1010*mathrand.Intn(16)
+	return http2Setting{ID: http2SettingID(id), Val: mathrand.Uint32()}
+}
+
+// greaseFrameType returns a random frame type reserved for greasing,
+// of the form 0x0b + 0x1f*N.
+func http2greaseFrameType() http2FrameType {
+	return http2FrameType(0x0b + 0x1f*mathrand.Intn(8))
+}
+
+// greasePayload returns a short random payload for a GREASE frame.
+func http2greasePayload() []byte {
+	p := make([]byte, mathrand.Intn(8))
+	rand.Read(p)
+	return p
+}
+
 func (cc *http2ClientConn) healthCheck() {
 	pingTimeout := cc.pingTimeout
 	// We don't need to periodically ping in the health check, because the readLoop of ClientConn will
@@ -8570,7 +8753,8 @@ func (cc *http2ClientConn) isUsableLocked() bool {
 		!cc.closing &&
 		!cc.doNotReuse &&
 		int64(cc.nextStreamID)+2*int64(cc.pendingRequests) < math.MaxInt32 &&
-		!cc.tooIdleLocked()
+		!cc.tooIdleLocked() &&
+		!cc.pastLifetimeLocked()
 }
 
 // canReserveLocked reports whether a net/http.ClientConn can reserve a slot on this conn.
@@ -8618,6 +8802,15 @@ func (cc *http2ClientConn) tooIdleLocked() bool {
 	return cc.idleTimeout != 0 && !cc.lastIdle.IsZero() && time.Since(cc.lastIdle.Round(0)) > cc.idleTimeout
 }
 
+// pastLifetimeLocked reports whether this connection is older than the
+// ConnMaxLifetime of the HTTP/1 Transport, again in wall time.
+func (cc *http2ClientConn) pastLifetimeLocked() bool {
+	if cc.t.t1 == nil || cc.t.t1.ConnMaxLifetime <= 0 {
+		return false
+	}
+	return time.Since(cc.createdAt.Round(0)) > cc.t.t1.ConnMaxLifetime
+}
+
 // onIdleTimeout is called from a time.AfterFunc goroutine. It will
 // only be called when we're idle, but because we're coming from a new
 // goroutine, there could be a new request coming in at the same time,
@@ -8763,15 +8956,15 @@ func (cc *http2ClientConn) closeForLostPing() {
 // exported. At least they'll be DeepEqual for h1-vs-h2 comparisons tests.
 var http2errRequestCanceled = errors.New("http: request canceled")
 
-func (cc *http2ClientConn) responseHeaderTimeout() time.Duration {
+func (cc *http2ClientConn) responseHeaderTimeout() (time.Duration, error) {
 	if cc.t.t1 != nil {
-		return cc.t.t1.ResponseHeaderTimeout
+		return cc.t.t1.responseHeaderTimeout()
 	}
 	// No way to do this (yet?) with just an http2.Transport. Probably
 	// no need. Request.Cancel this is the new way. We only need to support
 	// this for compatibility with the old http.Transport fields when
 	// we're doing transparent http2.
-	return 0
+	return 0, http2errTimeout
 }
 
 // actualContentLength returns a sanitized version of
@@ -8979,6 +9172,9 @@ func (cs *http2clientStream) writeRequest(req *http.Request, streamf func(*http2
 	if http2isConnectionCloseRequest(req) {
 		cc.doNotReuse = true
 	}
+	if max := cc.t.maxRequestsPerConn(); max > 0 && int64(cc.nextStreamID)/2 >= max {
+		cc.doNotReuse = true
+	}
 	cc.mu.Unlock()
 
 	if streamf != nil {
@@ -9044,7 +9240,8 @@ func (cs *http2clientStream) writeRequest(req *http.Request, streamf func(*http2
 
 	var respHeaderTimer <-chan time.Time
 	var respHeaderRecv chan struct{}
-	if d := cc.responseHeaderTimeout(); d != 0 {
+	d, respHeaderTimeoutErr := cc.responseHeaderTimeout()
+	if d != 0 {
 		timer := time.NewTimer(d)
 		defer timer.Stop()
 		respHeaderTimer = timer.C
@@ -9058,7 +9255,7 @@ func (cs *http2clientStream) writeRequest(req *http.Request, streamf func(*http2
 		case <-cs.peerClosed:
 			return nil
 		case <-respHeaderTimer:
-			return http2errTimeout
+			return respHeaderTimeoutErr
 		case <-respHeaderRecv:
 			respHeaderRecv = nil
 			respHeaderTimer = nil // keep waiting for END_STREAM
@@ -9096,7 +9293,7 @@ func (cs *http2clientStream) encodeAndWriteHeaders(req *http.Request) error {
 	// sent by writeRequestBody below, along with any Trailers,
 	// again in form HEADERS{1}, CONTINUATION{0,})
 	cc.hbuf.Reset()
-	res, err := http2encodeRequestHeaders(req, cs.requestedGzip, cc.peerMaxHeaderListSize, func(name, value string) {
+	res, err := http2encodeRequestHeaders(req, cs.requestedGzip, cc.peerMaxHeaderListSize, cc.t.t1.headerOrder(req.Context()), func(name, value string) {
 		cc.writeHeader(name, value)
 	})
 	if err != nil {
@@ -9107,12 +9304,13 @@ func (cs *http2clientStream) encodeAndWriteHeaders(req *http.Request) error {
 	// Write the request.
 	endStream := !res.HasBody && !res.HasTrailers
 	cs.sentHeaders = true
-	err = cc.writeHeaders(cs.ID, endStream, int(cc.maxFrameSize), hdrs)
+	prio, _ := contextStreamPriority(ctx)
+	err = cc.writeHeaders(cs.ID, endStream, int(cc.maxFrameSize), hdrs, prio)
 	http2traceWroteHeaders(cs.trace)
 	return err
 }
 
-func http2encodeRequestHeaders(req *http.Request, addGzipHeader bool, peerMaxHeaderListSize uint64, headerf func(name, value string)) (httpcommon.EncodeHeadersResult, error) {
+func http2encodeRequestHeaders(req *http.Request, addGzipHeader bool, peerMaxHeaderListSize uint64, headerOrder []string, headerf func(name, value string)) (httpcommon.EncodeHeadersResult, error) {
 	return httpcommon.EncodeHeaders(req.Context(), httpcommon.EncodeHeadersParam{
 		Request: httpcommon.Request{
 			Header:              req.Header,
@@ -9125,6 +9323,7 @@ func http2encodeRequestHeaders(req *http.Request, addGzipHeader bool, peerMaxHea
 		AddGzipHeader:         addGzipHeader,
 		PeerMaxHeaderListSize: peerMaxHeaderListSize,
 		DefaultUserAgent:      http2defaultUserAgent,
+		HeaderOrder:           headerOrder,
 	}, headerf)
 }
 
@@ -9211,7 +9410,11 @@ func (cs *http2clientStream) cleanupWriteRequest(err error) {
 					}
 					cc.mu.Unlock()
 				}
-				cc.writeStreamReset(cs.ID, http2ErrCodeCancel, ping, err)
+				code := http2ErrCodeCancel
+				if c, ok := contextCancelResetCode(cs.ctx); ok {
+					code = http2ErrCode(c)
+				}
+				cc.writeStreamReset(cs.ID, code, ping, err)
 			}
 		}
 		cs.bufPipe.CloseWithError(err) // no-op if already closed
@@ -9265,7 +9468,7 @@ func (cc *http2ClientConn) awaitOpenSlotForStreamLocked(cs *http2clientStream) e
 }
 
 // requires cc.wmu be held
-func (cc *http2ClientConn) writeHeaders(streamID uint32, endStream bool, maxFrameSize int, hdrs []byte) error {
+func (cc *http2ClientConn) writeHeaders(streamID uint32, endStream bool, maxFrameSize int, hdrs []byte, prio http2PriorityParam) error {
 	first := true // first frame written (HEADERS is first, then CONTINUATION)
 	for len(hdrs) > 0 && cc.werr == nil {
 		chunk := hdrs
@@ -9280,6 +9483,7 @@ func (cc *http2ClientConn) writeHeaders(streamID uint32, endStream bool, maxFram
 				BlockFragment: chunk,
 				EndStream:     endStream,
 				EndHeaders:    endHeaders,
+				Priority:      prio,
 			})
 			first = false
 		} else {
@@ -9470,7 +9674,7 @@ func (cs *http2clientStream) writeRequestBody(req *http.Request) (err error) {
 	// Two ways to send END_STREAM: either with trailers, or
 	// with an empty DATA frame.
 	if len(trls) > 0 {
-		err = cc.writeHeaders(cs.ID, true, maxFrameSize, trls)
+		err = cc.writeHeaders(cs.ID, true, maxFrameSize, trls, http2PriorityParam{})
 	} else {
 		err = cc.fr.WriteData(cs.ID, true, nil)
 	}
@@ -9593,7 +9797,7 @@ func (cc *http2ClientConn) forgetStreamID(id uint32) {
 	// wake up RoundTrip if there is a pending request.
 	cc.cond.Broadcast()
 
-	closeOnIdle := cc.singleUse || cc.doNotReuse || cc.t.disableKeepAlives() || cc.goAway != nil
+	closeOnIdle := cc.singleUse || cc.doNotReuse || cc.t.disableKeepAlives() || cc.goAway != nil || cc.pastLifetimeLocked()
 	if closeOnIdle && cc.streamsReserved == 0 && len(cc.streams) == 0 {
 		if http2VerboseLogs {
 			cc.vlogf("http2: Transport closing idle conn %p (forSingleUse=%v, maxStream=%v)", cc, cc.singleUse, cc.nextStreamID-2)
@@ -9924,6 +10128,9 @@ func (rl *http2clientConnReadLoop) handleResponse(cs *http2clientStream, f *http
 		if f.StreamEnded() {
 			return nil, errors.New("1xx informational response with END_STREAM flag")
 		}
+		if t1 := cs.cc.t.t1; t1 != nil && t1.On1xxResponse != nil {
+			t1.On1xxResponse(statusCode, header)
+		}
 		if fn := cs.get1xxTraceFunc(); fn != nil {
 			// If the 1xx response is being delivered to the user,
 			// then they're responsible for limiting the number
@@ -10306,6 +10513,13 @@ func (cs *http2clientStream) copyTrailers() {
 
 func (rl *http2clientConnReadLoop) processGoAway(f *http2GoAwayFrame) error {
 	cc := rl.cc
+	if t1 := cc.t.t1; t1 != nil && t1.OnGoAway != nil {
+		addr := cc.addr
+		if addr == "" && cc.tconn != nil {
+			addr = cc.tconn.RemoteAddr().String()
+		}
+		t1.OnGoAway(addr, f.LastStreamID, uint32(f.ErrCode), f.DebugData())
+	}
 	cc.t.connPool().MarkDead(cc)
 	if f.ErrCode != 0 {
 		// TODO: deal with GOAWAY more. particularly the error code
@@ -10767,7 +10981,7 @@ func (rt http2noDialH2RoundTripper) RoundTrip(req *http.Request) (*http.Response
 
 func (rt http2noDialH2RoundTripper) NewClientConn(conn net.Conn, internalStateHook func()) (http.RoundTripper, error) {
 	tr := rt.http2Transport
-	cc, err := tr.newClientConn(conn, tr.disableKeepAlives(), internalStateHook)
+	cc, err := tr.newClientConn(conn, "", tr.disableKeepAlives(), internalStateHook)
 	if err != nil {
 		return nil, err
 	}
@@ -10832,6 +11046,12 @@ func (cc http2netHTTPClientConn) Available() int {
 	return cc.cc.availableLocked()
 }
 
+func (cc http2netHTTPClientConn) ExtendedConnectEnabled() bool {
+	cc.cc.mu.Lock()
+	defer cc.cc.mu.Unlock()
+	return cc.cc.seenSettings && cc.cc.extendedConnectAllowed
+}
+
 func (cc http2netHTTPClientConn) InFlight() int {
 	cc.cc.mu.Lock()
 	defer cc.cc.mu.Unlock()
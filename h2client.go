@@ -0,0 +1,157 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+var streamPriorityContextKey = &contextKey{"stream-priority"}
+
+// WithStreamPriority returns a copy of ctx that makes Transport send
+// the given RFC 7540 priority in the HEADERS frame of an HTTP/2
+// request made with it: a weight from 1 to 256, clamped to that
+// range, the ID of the stream it depends on, or 0 for none, and
+// whether the dependency is exclusive. A weight of 1 without a
+// dependency is the same as no priority on the wire, so the server's
+// default weight of 16 applies.
+//
+// The priority is ignored for HTTP/1 requests, and by servers that do
+// not implement RFC 7540 prioritization, which RFC 9113 deprecates.
+func WithStreamPriority(ctx context.Context, weight int, dependsOn uint32, exclusive bool) context.Context {
+	weight = min(max(weight, 1), 256)
+	return context.WithValue(ctx, streamPriorityContextKey, http2PriorityParam{
+		StreamDep: dependsOn & (1<<31 - 1),
+		Exclusive: exclusive,
+		Weight:    uint8(weight - 1),
+	})
+}
+
+func contextStreamPriority(ctx context.Context) (http2PriorityParam, bool) {
+	p, ok := ctx.Value(streamPriorityContextKey).(http2PriorityParam)
+	return p, ok
+}
+
+// HTTP/2 error codes for WithCancelResetCode (RFC 9113, Section 7).
+const (
+	HTTP2ErrCodeNoError uint32 = 0x0
+	HTTP2ErrCodeCancel  uint32 = 0x8
+)
+
+var cancelResetCodeContextKey = &contextKey{"cancel-reset-code"}
+
+// WithCancelResetCode returns a copy of ctx that makes Transport reset
+// the stream of an HTTP/2 request made with it using the error code
+// code, rather than HTTP2ErrCodeCancel, when the request is canceled
+// while in flight, such as by canceling its context or closing the
+// response body before its end. code is sent as is; HTTP2ErrCodeNoError
+// suits servers that treat CANCEL as a failure when the client simply
+// stops reading.
+//
+// The code is ignored for HTTP/1 requests, and for streams the client
+// resets because of a protocol error.
+func WithCancelResetCode(ctx context.Context, code uint32) context.Context {
+	return context.WithValue(ctx, cancelResetCodeContextKey, code)
+}
+
+func contextCancelResetCode(ctx context.Context) (uint32, bool) {
+	c, ok := ctx.Value(cancelResetCodeContextKey).(uint32)
+	return c, ok
+}
+
+var headerOrderContextKey = &contextKey{"header-order"}
+
+// WithHeaderOrder returns a copy of ctx that makes Transport encode
+// the header fields of an HTTP/2 request made with it in the given
+// order, overriding Transport.HeaderOrder, whose doc describes order.
+func WithHeaderOrder(ctx context.Context, order []string) context.Context {
+	return context.WithValue(ctx, headerOrderContextKey, order)
+}
+
+// headerOrder returns the header field order for an HTTP/2 request
+// made with ctx.
+func (t *Transport) headerOrder(ctx context.Context) []string {
+	if order, ok := ctx.Value(headerOrderContextKey).([]string); ok {
+		return order
+	}
+	if t == nil {
+		return nil
+	}
+	return t.HeaderOrder
+}
+
+// ErrExtendedConnectNotSupported is returned by Transport for an
+// Extended CONNECT request when the server did not advertise
+// SETTINGS_ENABLE_CONNECT_PROTOCOL, or when the connection to it is
+// not HTTP/2.
+var ErrExtendedConnectNotSupported = http2errExtendedConnectNotSupported
+
+// isExtendedConnect reports whether req is an Extended CONNECT request
+// (RFC 8441): a CONNECT with a :protocol pseudo-header field.
+func isExtendedConnect(req *http.Request) bool {
+	return req.Method == "CONNECT" && req.Header.Get(":protocol") != ""
+}
+
+// ExtendedConnect sends an HTTP/2 Extended CONNECT request (RFC 8441)
+// for the URL rawURL, asking the server to run protocol, such as
+// "websocket", over the request's stream, and returns a Stream over
+// that tunnel along with the server's response. header holds
+// additional request header fields and may be nil.
+//
+// The connection must be HTTP/2, which for an http URL requires
+// UnencryptedHTTP2 in Protocols, and the server must advertise
+// SETTINGS_ENABLE_CONNECT_PROTOCOL; otherwise ExtendedConnect fails
+// with ErrExtendedConnectNotSupported. If the server answers with a
+// status other than 2xx, ExtendedConnect returns the response, whose
+// Body the caller must close, and an error.
+//
+// Writes to the Stream become DATA frames, and reads return the DATA
+// the server sends. The Stream also has a CloseWrite method that ends
+// the request stream, and a Close method that tears the tunnel down.
+// Canceling ctx also tears it down.
+func (t *Transport) ExtendedConnect(ctx context.Context, rawURL, protocol string, header http.Header) (Stream, *http.Response, error) {
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, "CONNECT", rawURL, pr)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, vv := range header {
+		req.Header[k] = vv
+	}
+	req.Header.Set(":protocol", protocol)
+	req.ContentLength = -1
+
+	res, err := t.RoundTrip(req)
+	if err != nil {
+		pw.Close()
+		return nil, nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		pw.Close()
+		return nil, res, errors.New("http: Extended CONNECT failed: " + res.Status)
+	}
+	return &h2Tunnel{body: res.Body, pw: pw}, res, nil
+}
+
+// h2Tunnel is the Stream of an Extended CONNECT request.
+type h2Tunnel struct {
+	body      io.ReadCloser
+	pw        *io.PipeWriter
+	closeOnce sync.Once
+}
+
+func (s *h2Tunnel) Read(p []byte) (int, error)  { return s.body.Read(p) }
+func (s *h2Tunnel) Write(p []byte) (int, error) { return s.pw.Write(p) }
+
+func (s *h2Tunnel) CloseWrite() error { return s.pw.Close() }
+
+func (s *h2Tunnel) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.pw.Close()
+		err = s.body.Close()
+	})
+	return err
+}
@@ -0,0 +1,260 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"slices"
+	"testing"
+)
+
+func TestWithStreamPriority(t *testing.T) {
+	prios := make(chan http2PriorityParam, 1)
+	addr := newH2CaptureServer(t, func(fr *http2Framer, f http2Frame) bool {
+		if hf, ok := f.(*http2HeadersFrame); ok {
+			prios <- hf.Priority
+			return false
+		}
+		return true
+	})
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want http2PriorityParam
+	}{
+		{"none", context.Background(), http2PriorityParam{}},
+		{"weight", WithStreamPriority(context.Background(), 32, 0, false), http2PriorityParam{Weight: 31}},
+		{"dependency", WithStreamPriority(context.Background(), 256, 3, true), http2PriorityParam{StreamDep: 3, Exclusive: true, Weight: 255}},
+		{"clamped", WithStreamPriority(context.Background(), 1000, 1<<31|5, false), http2PriorityParam{StreamDep: 5, Weight: 255}},
+		{"clamped low", WithStreamPriority(context.Background(), -1, 0, false), http2PriorityParam{}},
+	}
+	for _, tt := range tests {
+		// The capture server drops the connection after the HEADERS
+		// frame, so each request gets a Transport of its own.
+		tr := &Transport{Protocols: h2cProtocols()}
+		req := mustNewRequest(t, "GET", "http://"+addr+"/", nil).WithContext(tt.ctx)
+		go func() {
+			if res, err := tr.RoundTrip(req); err == nil {
+				res.Body.Close()
+			}
+		}()
+		if got := <-prios; got != tt.want {
+			t.Errorf("%s: HEADERS priority = %+v; want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWithStreamPriorityHTTP1(t *testing.T) {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := mustNewRequest(t, "GET", url, nil)
+	req = req.WithContext(WithStreamPriority(req.Context(), 64, 0, false))
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+}
+
+func TestWithCancelResetCode(t *testing.T) {
+	codes := make(chan http2ErrCode, 1)
+	addr := newH2CaptureServer(t, func(fr *http2Framer, f http2Frame) bool {
+		switch f := f.(type) {
+		case *http2HeadersFrame:
+			// 0x88 is the HPACK static table entry for ":status: 200".
+			fr.WriteHeaders(http2HeadersFrameParam{StreamID: f.StreamID, BlockFragment: []byte{0x88}, EndHeaders: true})
+			fr.WriteData(f.StreamID, false, []byte("partial"))
+		case *http2RSTStreamFrame:
+			codes <- f.ErrCode
+			return false
+		}
+		return true
+	})
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want http2ErrCode
+	}{
+		{"default", context.Background(), http2ErrCodeCancel},
+		{"no error", WithCancelResetCode(context.Background(), HTTP2ErrCodeNoError), http2ErrCodeNo},
+		{"custom", WithCancelResetCode(context.Background(), 0xd), http2ErrCodeHTTP11Required},
+	}
+	for _, tt := range tests {
+		tr := &Transport{Protocols: h2cProtocols()}
+		req := mustNewRequest(t, "GET", "http://"+addr+"/", nil).WithContext(tt.ctx)
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		res.Body.Read(make([]byte, 1))
+		res.Body.Close()
+		if got := <-codes; got != tt.want {
+			t.Errorf("%s: RST_STREAM code = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// newExtendedConnectServer returns the URL of an h2c server that
+// accepts Extended CONNECT requests for protocol "echo", answering
+// other protocols with 400, and echoes the request body back.
+func newExtendedConnectServer(t *testing.T, enable bool) string {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "CONNECT" || r.Header.Get(":protocol") != "echo" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		buf := make([]byte, 64)
+		for {
+			n, err := r.Body.Read(buf)
+			if n > 0 {
+				w.Write(buf[:n])
+				w.(http.Flusher).Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}), func(s *Server) {
+		s.Protocols = h2cProtocols()
+		s.HTTP2 = &HTTP2Config{EnableConnectProtocol: enable}
+	})
+	return url
+}
+
+func TestTransportExtendedConnect(t *testing.T) {
+	url := newExtendedConnectServer(t, true)
+	tr := &Transport{Protocols: h2cProtocols()}
+	defer tr.CloseIdleConnections()
+	s, res, err := tr.ExtendedConnect(context.Background(), url+"/chat", "echo", http.Header{"X-Test": {"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("status = %d; want 200", res.StatusCode)
+	}
+	testEcho(t, s)
+	if err := s.(interface{ CloseWrite() error }).CloseWrite(); err != nil {
+		t.Errorf("CloseWrite = %v", err)
+	}
+	if n, err := s.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Read after CloseWrite = %d, %v; want 0, EOF", n, err)
+	}
+	if err := s.(io.Closer).Close(); err != nil {
+		t.Errorf("Close = %v", err)
+	}
+
+	s, res, err = tr.ExtendedConnect(context.Background(), url, "other", nil)
+	if err == nil || s != nil || res == nil || res.StatusCode != http.StatusBadRequest {
+		t.Errorf("rejected ExtendedConnect = %v, %v; want a 400 response and an error", res, err)
+	}
+	if res != nil {
+		res.Body.Close()
+	}
+}
+
+func TestTransportExtendedConnectNotSupported(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		tr   *Transport
+	}{
+		{"setting not advertised", newExtendedConnectServer(t, false), &Transport{Protocols: h2cProtocols()}},
+		{"HTTP/1", newExtendedConnectServer(t, true), &Transport{}},
+	}
+	for _, tt := range tests {
+		_, _, err := tt.tr.ExtendedConnect(context.Background(), tt.url, "echo", nil)
+		if !errors.Is(err, ErrExtendedConnectNotSupported) {
+			t.Errorf("%s: ExtendedConnect error = %v; want ErrExtendedConnectNotSupported", tt.name, err)
+		}
+		tt.tr.CloseIdleConnections()
+	}
+}
+
+func TestHTTP2ConfigEnableConnectProtocol(t *testing.T) {
+	for _, enable := range []bool{false, true} {
+		url := newExtendedConnectServer(t, enable)
+		tr := &Transport{Protocols: h2cProtocols()}
+		cc, err := tr.NewClientConn(context.Background(), "http", url[len("http://"):])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A round trip makes sure the server's SETTINGS have arrived.
+		res, err := cc.RoundTrip(mustNewRequest(t, "GET", url, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if got := cc.ExtendedConnectEnabled(); got != enable {
+			t.Errorf("EnableConnectProtocol %v: ExtendedConnectEnabled = %v", enable, got)
+		}
+		cc.Close()
+	}
+}
+
+func TestTransportEnableConnectProtocol(t *testing.T) {
+	settings := make(chan bool, 1)
+	addr := newH2CaptureServer(t, func(fr *http2Framer, f http2Frame) bool {
+		if sf, ok := f.(*http2SettingsFrame); ok && !sf.IsAck() {
+			v, ok := sf.Value(http2SettingEnableConnectProtocol)
+			settings <- ok && v == 1
+			return false
+		}
+		return true
+	})
+	for _, enable := range []bool{false, true} {
+		tr := &Transport{Protocols: h2cProtocols(), HTTP2: &HTTP2Config{EnableConnectProtocol: enable}}
+		go func() {
+			if res, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://"+addr+"/", nil)); err == nil {
+				res.Body.Close()
+			}
+		}()
+		if got := <-settings; got != enable {
+			t.Errorf("EnableConnectProtocol %v: SETTINGS_ENABLE_CONNECT_PROTOCOL sent = %v", enable, got)
+		}
+	}
+}
+
+func TestTransportHeaderOrder(t *testing.T) {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), func(s *Server) {
+		s.Protocols = h2cProtocols()
+	})
+	tr := &Transport{
+		Protocols:   h2cProtocols(),
+		HeaderOrder: []string{"X-B", ":path", "x-a", ":method"},
+	}
+	defer tr.CloseIdleConnections()
+	fieldNames := func(ctx context.Context) []string {
+		var names []string
+		ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			WroteHeaderField: func(name string, _ []string) { names = append(names, name) },
+		})
+		req := mustNewRequest(t, "GET", url, nil).WithContext(ctx)
+		req.Header.Set("X-A", "1")
+		req.Header.Set("X-B", "2")
+		req.Header.Set("User-Agent", "test")
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return names
+	}
+
+	// Fields not listed keep the encoder's order: :authority before
+	// :scheme, and regular fields after the listed ones.
+	got := fieldNames(context.Background())
+	want := []string{":path", ":method", ":authority", ":scheme", "x-b", "x-a"}
+	if len(got) < len(want) || !slices.Equal(got[:len(want)], want) {
+		t.Errorf("Transport.HeaderOrder: fields = %q; want prefix %q", got, want)
+	}
+
+	got = fieldNames(WithHeaderOrder(context.Background(), []string{":scheme", "user-agent"}))
+	if len(got) < 5 || got[0] != ":scheme" || got[4] != "user-agent" {
+		t.Errorf("WithHeaderOrder: fields = %q; want :scheme first and user-agent first after the pseudo-headers", got)
+	}
+}
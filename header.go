@@ -5,9 +5,14 @@
 package http
 
 import (
+	"bufio"
+	"bytes"
 	"net/http"
+	"net/textproto"
 
 	"github.com/puernya/go-http/internal/ascii"
+
+	"golang.org/x/net/http/httpguts"
 )
 
 func getFromHeader(h http.Header, key string) string {
@@ -22,6 +27,22 @@ func headerHas(h http.Header, key string) bool {
 	return ok
 }
 
+// HeaderContainsToken reports whether any value of the header key in h
+// contains token as one of its comma-separated elements, compared
+// ASCII case-insensitively, as httpguts.HeaderValuesContainsToken
+// does. key is canonicalized as by http.Header.Get, which allocates
+// only if key is not already canonical; the values are scanned where
+// they are, without being copied or joined.
+func HeaderContainsToken(h http.Header, key, token string) bool {
+	return headerContainsToken(h, textproto.CanonicalMIMEHeaderKey(key), token)
+}
+
+// headerContainsToken is HeaderContainsToken for a key already in
+// canonical form, which is looked up in h as is.
+func headerContainsToken(h http.Header, key, token string) bool {
+	return httpguts.HeaderValuesContainsToken(h[key], token)
+}
+
 // hasToken reports whether token appears with v, ASCII
 // case-insensitive, with space or comma boundaries.
 // token must be all lowercase.
@@ -61,3 +82,159 @@ func hasToken(v, token string) bool {
 func isTokenBoundary(b byte) bool {
 	return b == ' ' || b == ',' || b == '\t'
 }
+
+// commonHeaderKeys interns the canonical form of frequently used
+// header keys so that readBufferedHeader does not allocate them.
+var commonHeaderKeys = func() map[string]string {
+	m := make(map[string]string)
+	for _, k := range []string{
+		"Accept", "Accept-Charset", "Accept-Encoding", "Accept-Language",
+		"Authorization", "Cache-Control", "Connection", "Content-Encoding",
+		"Content-Length", "Content-Type", "Cookie", "Date", "Dnt", "Expect",
+		"Forwarded", "Host", "If-Match", "If-Modified-Since", "If-None-Match",
+		"If-Range", "If-Unmodified-Since", "Keep-Alive", "Origin", "Pragma",
+		"Priority", "Proxy-Authorization", "Proxy-Connection", "Range",
+		"Referer", "Sec-Ch-Ua", "Sec-Ch-Ua-Mobile", "Sec-Ch-Ua-Platform",
+		"Sec-Fetch-Dest", "Sec-Fetch-Mode", "Sec-Fetch-Site", "Sec-Fetch-User",
+		"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+		"Upgrade-Insecure-Requests", "User-Agent", "Via", "X-Forwarded-For",
+		"X-Forwarded-Host", "X-Forwarded-Proto", "X-Real-Ip", "X-Requested-With",
+	} {
+		m[k] = k
+	}
+	return m
+}()
+
+// maxFastHeaderKey is the longest header key readBufferedHeader
+// canonicalizes on the stack.
+const maxFastHeaderKey = 64
+
+// readBufferedHeader parses the header block at the start of b's
+// buffer into h, reusing h's value slices and any value string that
+// is unchanged from the previous contents of h.
+//
+// It only handles the common case: the whole block is already
+// buffered and every field is a plain "Name: value" line ending in
+// CRLF. Otherwise it reports false without consuming anything from b
+// or modifying h, and the caller falls back to textproto, which also
+// produces the right error for malformed input.
+func readBufferedHeader(b *bufio.Reader, h http.Header) bool {
+	buf, _ := b.Peek(b.Buffered())
+	var n int
+	if bytes.HasPrefix(buf, []byte("\r\n")) {
+		n = 2
+	} else if i := bytes.Index(buf, []byte("\r\n\r\n")); i >= 0 {
+		n = i + 4
+	} else {
+		return false
+	}
+	block := buf[:n-2]
+
+	// First pass: check that every line is one we can handle, so
+	// that h is only modified once we know we will succeed.
+	lines := 0
+	for line := range bytes.Lines(block) {
+		key, value, ok := splitHeaderLine(line)
+		if !ok || len(key) > maxFastHeaderKey || !validHeaderKeyBytes(key) || !validHeaderValueBytes(value) {
+			return false
+		}
+		lines++
+	}
+
+	for k, vv := range h {
+		h[k] = vv[:0]
+	}
+	var kbuf [maxFastHeaderKey]byte
+	var strs []string // backing for the value slices of new keys, as in textproto
+	for line := range bytes.Lines(block) {
+		lines--
+		key, value, _ := splitHeaderLine(line)
+		ck := canonicalHeaderKey(kbuf[:0], key)
+		vv, ok := h[string(ck)]
+		var v string
+		if ok && len(vv) < cap(vv) && vv[:cap(vv)][len(vv)] == string(value) {
+			v = vv[:cap(vv)][len(vv)]
+		} else {
+			v = string(value)
+		}
+		k, common := commonHeaderKeys[string(ck)]
+		if !common {
+			k = string(ck)
+		}
+		if ok {
+			h[k] = append(vv, v)
+		} else {
+			if strs == nil {
+				strs = make([]string, lines+1)
+			}
+			strs[0] = v
+			h[k] = strs[:1:1]
+			strs = strs[1:]
+		}
+	}
+	for k, vv := range h {
+		if len(vv) == 0 {
+			delete(h, k)
+		}
+	}
+	b.Discard(n)
+	return true
+}
+
+// splitHeaderLine splits a CRLF-terminated header line into its key
+// and its value with surrounding whitespace removed. It reports false
+// for continuation lines, bare LF line endings and lines without a
+// colon.
+func splitHeaderLine(line []byte) (key, value []byte, ok bool) {
+	line, ok = bytes.CutSuffix(line, []byte("\r\n"))
+	if !ok || len(line) == 0 || line[0] == ' ' || line[0] == '\t' {
+		return nil, nil, false
+	}
+	if bytes.IndexByte(line, '\r') >= 0 {
+		return nil, nil, false
+	}
+	key, value, ok = bytes.Cut(line, []byte(":"))
+	if !ok {
+		return nil, nil, false
+	}
+	return key, bytes.Trim(value, " \t"), true
+}
+
+// canonicalHeaderKey appends the canonical form of the token key to
+// dst, as textproto.CanonicalMIMEHeaderKey would return it.
+func canonicalHeaderKey(dst, key []byte) []byte {
+	upper := true
+	for _, c := range key {
+		if upper && 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		} else if !upper && 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst = append(dst, c)
+		upper = c == '-'
+	}
+	return dst
+}
+
+func validHeaderKeyBytes(key []byte) bool {
+	if len(key) == 0 {
+		return false
+	}
+	for _, c := range key {
+		if !httpguts.IsTokenRune(rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// validHeaderValueBytes is httpguts.ValidHeaderFieldValue for a byte
+// slice: it rejects control characters other than horizontal tab.
+func validHeaderValueBytes(value []byte) bool {
+	for _, c := range value {
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
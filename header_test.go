@@ -0,0 +1,88 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHeaderContainsToken(t *testing.T) {
+	tests := []struct {
+		vv    []string
+		token string
+		want  bool
+	}{
+		{[]string{"close"}, "close", true},
+		{[]string{"Keep-Alive, Upgrade"}, "upgrade", true},
+		{[]string{"keep-alive", "Upgrade"}, "upgrade", true},
+		{[]string{" foo ,\tbar "}, "bar", true},
+		{[]string{"upgrades"}, "upgrade", false},
+		{[]string{"foo bar"}, "bar", false},
+		{nil, "close", false},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.vv != nil {
+			h["Connection"] = tt.vv
+		}
+		if got := HeaderContainsToken(h, "connection", tt.token); got != tt.want {
+			t.Errorf("HeaderContainsToken(Connection: %q, %q) = %v; want %v", tt.vv, tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestRequestHeaderTokens(t *testing.T) {
+	req := &http.Request{ProtoMajor: 1, ProtoMinor: 0, Header: http.Header{
+		"Expect":     {"foo", "100-Continue"},
+		"Connection": {"upgrade", "Keep-Alive"},
+	}}
+	if !requestExpectsContinue(req) {
+		t.Error("requestExpectsContinue = false for a second Expect value")
+	}
+	if !requestWantsHttp10KeepAlive(req) {
+		t.Error("requestWantsHttp10KeepAlive = false for a second Connection value")
+	}
+	req.ProtoMinor = 1
+	if requestWantsHttp10KeepAlive(req) {
+		t.Error("requestWantsHttp10KeepAlive = true for HTTP/1.1")
+	}
+	if requestWantsClose(req) {
+		t.Error("requestWantsClose = true without a close token")
+	}
+	req.Header["Connection"] = []string{"keep-alive", "Close"}
+	if !requestWantsClose(req) {
+		t.Error("requestWantsClose = false for a second Connection value")
+	}
+	req.Header["Connection"] = []string{"foo close"}
+	if requestWantsClose(req) {
+		t.Error("requestWantsClose = true for a space-separated close")
+	}
+}
+
+func BenchmarkHeaderContainsToken(b *testing.B) {
+	h := http.Header{"Connection": {"upgrade", "keep-alive", "close"}}
+	b.Run("HeaderContainsToken", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if !HeaderContainsToken(h, "connection", "close") {
+				b.Fatal("token not found")
+			}
+		}
+	})
+	b.Run("headerContainsToken", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if !headerContainsToken(h, "Connection", "close") {
+				b.Fatal("token not found")
+			}
+		}
+	})
+	b.Run("Join", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if !hasToken(strings.Join(h["Connection"], ", "), "close") {
+				b.Fatal("token not found")
+			}
+		}
+	})
+}
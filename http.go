@@ -184,4 +184,53 @@ type HTTP2Config struct {
 	// The errType contains only lowercase letters, digits, and underscores
 	// (a-z, 0-9, _).
 	CountError func(errType string)
+
+	// EnableConnectProtocol, if true, makes the endpoint send
+	// SETTINGS_ENABLE_CONNECT_PROTOCOL (RFC 8441). A Server then
+	// accepts Extended CONNECT requests, which carry a :protocol
+	// pseudo-header and reach the Handler as CONNECT requests with
+	// a ":protocol" entry in their Header. Whether a server
+	// advertised the setting can be checked with
+	// ClientConn.ExtendedConnectEnabled.
+	//
+	// It is off by default, since clients such as browsers then
+	// attempt WebSockets over HTTP/2, which handlers may not expect.
+	EnableConnectProtocol bool
+
+	// Settings, if non-nil, lists the exact parameters, in order,
+	// that a Transport sends in the initial SETTINGS frame of a
+	// connection, in place of those derived from the fields above.
+	// The Transport adapts its receive limits to the settings sent:
+	// a missing SETTINGS_INITIAL_WINDOW_SIZE,
+	// SETTINGS_HEADER_TABLE_SIZE or SETTINGS_MAX_FRAME_SIZE means the
+	// protocol default, and a missing SETTINGS_MAX_HEADER_LIST_SIZE
+	// means no advertised limit, with the Transport still refusing
+	// header lists over 16 MB.
+	// SETTINGS_ENABLE_PUSH, if present, must be 0, and every value
+	// must be valid for its identifier, or the connection fails.
+	// Servers ignore this field.
+	Settings []HTTP2Setting
+
+	// ConnectionWindowIncrement, if non-zero, is the increment of
+	// the WINDOW_UPDATE frame a Transport sends for the connection
+	// after its initial SETTINGS frame, in place of one derived from
+	// MaxReceiveBufferPerConnection. It may be at most 2^31-1 less
+	// the initial window of 65535 bytes. Servers ignore this field.
+	ConnectionWindowIncrement uint32
+
+	// SendGREASE, if true, makes a Transport exercise the extension
+	// points of HTTP/2 the way some browsers do: it adds a setting
+	// with a reserved identifier of the form 0x?a?a and a random value
+	// to its initial SETTINGS frame, and follows the frame with one of
+	// a reserved type (0x0b + 0x1f*N) and random payload. Conforming
+	// peers ignore both. Servers ignore this field.
+	SendGREASE bool
+}
+
+// An HTTP2Setting is a parameter of an HTTP/2 SETTINGS frame, with
+// an identifier from RFC 9113, Section 6.5.2, or a registered
+// extension.
+type HTTP2Setting struct {
+	ID  uint16
+	Val uint32
 }
@@ -20,6 +20,11 @@ const maxLineLength = 4096 // assumed <= bufio.defaultBufSize
 
 var ErrLineTooLong = errors.New("header line too long")
 
+var (
+	ErrChunkTooLarge = errors.New("chunked encoding chunk size exceeds limit")
+	ErrTooManyChunks = errors.New("chunked encoding chunk count exceeds limit")
+)
+
 // NewChunkedReader returns a new chunkedReader that translates the data read from r
 // out of HTTP "chunked" format before returning it.
 // The chunkedReader returns [io.EOF] when the final 0-length chunk is read.
@@ -34,6 +39,17 @@ func NewChunkedReader(r io.Reader) io.Reader {
 	return &chunkedReader{r: br}
 }
 
+// NewChunkedReaderLimit is like [NewChunkedReader], but the returned reader
+// fails with [ErrChunkTooLarge] if a chunk declares a size larger than
+// maxChunkSize, and with [ErrTooManyChunks] if the body consists of more
+// than maxChunkCount chunks. A limit of zero or less means no limit.
+func NewChunkedReaderLimit(r io.Reader, maxChunkSize int64, maxChunkCount int) io.Reader {
+	cr := NewChunkedReader(r).(*chunkedReader)
+	cr.maxSize = maxChunkSize
+	cr.maxCount = maxChunkCount
+	return cr
+}
+
 type chunkedReader struct {
 	r        *bufio.Reader
 	n        uint64 // unread bytes in chunk
@@ -41,6 +57,9 @@ type chunkedReader struct {
 	buf      [2]byte
 	checkEnd bool  // whether need to check for \r\n chunk footer
 	excess   int64 // "excessive" chunk overhead, for malicious sender detection
+	count    int   // number of chunks read so far
+	maxSize  int64 // if > 0, largest chunk size allowed
+	maxCount int   // if > 0, most chunks allowed
 }
 
 func (cr *chunkedReader) beginChunk() {
@@ -60,6 +79,17 @@ func (cr *chunkedReader) beginChunk() {
 	if cr.err != nil {
 		return
 	}
+	if cr.maxSize > 0 && cr.n > uint64(cr.maxSize) {
+		cr.err = ErrChunkTooLarge
+		return
+	}
+	if cr.n > 0 {
+		cr.count++
+		if cr.maxCount > 0 && cr.count > cr.maxCount {
+			cr.err = ErrTooManyChunks
+			return
+		}
+	}
 	// A sender who sends one byte per chunk will send 5 bytes of overhead
 	// for every byte of data. ("1\r\nX\r\n" to send "X".)
 	// We want to allow this, since streaming a byte at a time can be legitimate.
@@ -0,0 +1,33 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestChunkedReaderLimit(t *testing.T) {
+	const body = "3\r\nabc\r\n4\r\ndefg\r\n0\r\n\r\n"
+	tests := []struct {
+		maxSize  int64
+		maxCount int
+		wantErr  error
+	}{
+		{0, 0, nil},
+		{4, 2, nil},
+		{3, 0, ErrChunkTooLarge},
+		{0, 1, ErrTooManyChunks},
+	}
+	for _, tt := range tests {
+		r := NewChunkedReaderLimit(strings.NewReader(body), tt.maxSize, tt.maxCount)
+		got, err := io.ReadAll(r)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("limits (%d, %d): err = %v; want %v", tt.maxSize, tt.maxCount, err, tt.wantErr)
+			continue
+		}
+		if err == nil && string(got) != "abcdefg" {
+			t.Errorf("limits (%d, %d): body = %q; want %q", tt.maxSize, tt.maxCount, got, "abcdefg")
+		}
+	}
+}
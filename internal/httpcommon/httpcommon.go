@@ -198,6 +198,12 @@ type EncodeHeadersParam struct {
 	// DefaultUserAgent is the User-Agent header to send when the request
 	// neither contains a User-Agent nor disables it.
 	DefaultUserAgent string
+
+	// HeaderOrder, when non-empty, lists lowercase field names, including
+	// pseudo-header names such as ":method", in the order they are written.
+	// Fields not listed follow the listed ones. Pseudo-headers always
+	// precede regular fields.
+	HeaderOrder []string
 }
 
 // EncodeHeadersResult is the result of EncodeHeaders.
@@ -317,6 +323,14 @@ func EncodeHeaders(ctx context.Context, param EncodeHeadersParam, headerf func(n
 				// fields. We have already checked if any
 				// are error-worthy so just ignore the rest.
 				continue
+			} else if asciiEqualFold(k, "te") {
+				// Per RFC 9113, section 8.2.2, TE may only
+				// carry "trailers"; keep that and drop the
+				// HTTP/1 transfer codings.
+				if teHasTrailers(vv) {
+					f("te", "trailers")
+				}
+				continue
 			} else if asciiEqualFold(k, "user-agent") {
 				// Match Go's http1 behavior: at most one
 				// User-Agent. If set to nil or empty string,
@@ -391,7 +405,16 @@ func EncodeHeaders(ctx context.Context, param EncodeHeadersParam, headerf func(n
 
 	trace := httptrace.ContextClientTrace(ctx)
 
+	writeHeader := func(name, value string) {
+		headerf(name, value)
+
+		if trace != nil && trace.WroteHeaderField != nil {
+			trace.WroteHeaderField(name, []string{value})
+		}
+	}
+
 	// Header list size is ok. Write the headers.
+	var ordered []hpack.HeaderField
 	enumerateHeaders(func(name, value string) {
 		name, ascii := LowerHeader(name)
 		if !ascii {
@@ -400,18 +423,53 @@ func EncodeHeaders(ctx context.Context, param EncodeHeadersParam, headerf func(n
 			return
 		}
 
-		headerf(name, value)
-
-		if trace != nil && trace.WroteHeaderField != nil {
-			trace.WroteHeaderField(name, []string{value})
+		if len(param.HeaderOrder) > 0 {
+			ordered = append(ordered, hpack.HeaderField{Name: name, Value: value})
+			return
 		}
+		writeHeader(name, value)
 	})
+	if len(param.HeaderOrder) > 0 {
+		sortHeaderFields(ordered, param.HeaderOrder)
+		for _, hf := range ordered {
+			writeHeader(hf.Name, hf.Value)
+		}
+	}
 
 	res.HasBody = req.ActualContentLength != 0
 	res.HasTrailers = trailers != ""
 	return res, nil
 }
 
+// sortHeaderFields sorts fields by their position in order, keeping
+// pseudo-header fields ahead of regular fields as RFC 9113, Section
+// 8.3 requires. Fields not in order keep their relative order after
+// the listed fields of their kind.
+func sortHeaderFields(fields []hpack.HeaderField, order []string) {
+	rank := make(map[string]int, len(order))
+	for i, name := range order {
+		name = strings.ToLower(name)
+		if _, ok := rank[name]; !ok {
+			rank[name] = i
+		}
+	}
+	key := func(f hpack.HeaderField) (bool, int) {
+		r, ok := rank[f.Name]
+		if !ok {
+			r = len(order)
+		}
+		return !f.IsPseudo(), r
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		ri, oi := key(fields[i])
+		rj, oj := key(fields[j])
+		if ri != rj {
+			return !ri
+		}
+		return oi < oj
+	})
+}
+
 // IsRequestGzip reports whether we should add an Accept-Encoding: gzip header
 // for a request.
 func IsRequestGzip(method string, header map[string][]string, disableCompression bool) bool {
@@ -419,7 +477,7 @@ func IsRequestGzip(method string, header map[string][]string, disableCompression
 	if !disableCompression &&
 		len(header["Accept-Encoding"]) == 0 &&
 		len(header["Range"]) == 0 &&
-		method != "HEAD" {
+		method != "HEAD" && method != "CONNECT" {
 		// Request gzip only, not deflate. Deflate is ambiguous and
 		// not as universally supported anyway.
 		// See: https://zlib.net/zlib_faq.html#faq39
@@ -432,6 +490,8 @@ func IsRequestGzip(method string, header map[string][]string, disableCompression
 		// We don't request gzip if the request is for a range, since
 		// auto-decoding a portion of a gzipped document will just fail
 		// anyway. See https://golang.org/issue/8923
+		//
+		// Nor for CONNECT, whose response body is a tunnel.
 		return true
 	}
 	return false
@@ -457,6 +517,20 @@ func checkConnHeaders(h map[string][]string) error {
 	return nil
 }
 
+// teHasTrailers reports whether the TE header values vv include the
+// "trailers" token.
+func teHasTrailers(vv []string) bool {
+	for _, v := range vv {
+		for tok := range strings.SplitSeq(v, ",") {
+			tok, _, _ = strings.Cut(tok, ";")
+			if asciiEqualFold(strings.TrimSpace(tok), "trailers") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func commaSeparatedTrailers(trailer map[string][]string) (string, error) {
 	keys := make([]string, 0, len(trailer))
 	for k := range trailer {
@@ -0,0 +1,37 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+)
+
+// A Middleware inspects or rewrites a request before it is dispatched.
+// It returns the request to pass on, or an error to reject the request.
+//
+// A Middleware that changes the request should change a copy made with
+// Request.Clone or Request.WithContext, and return that, rather than
+// modify req, which earlier stages or the caller may still refer to.
+type Middleware func(req *http.Request) (*http.Request, error)
+
+// errNilMiddlewareRequest is returned by a Chain when a Middleware
+// returns neither a request nor an error.
+var errNilMiddlewareRequest = errors.New("http: middleware returned a nil request")
+
+// Chain returns a Middleware that applies ms in order, passing the
+// request returned by each one to the next. It stops at, and returns,
+// the first error.
+func Chain(ms ...Middleware) Middleware {
+	ms = append([]Middleware(nil), ms...)
+	return func(req *http.Request) (*http.Request, error) {
+		for _, m := range ms {
+			var err error
+			if req, err = m(req); err != nil {
+				return nil, err
+			}
+			if req == nil {
+				return nil, errNilMiddlewareRequest
+			}
+		}
+		return req, nil
+	}
+}
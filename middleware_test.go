@@ -0,0 +1,61 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	tag := func(name string) Middleware {
+		return func(req *http.Request) (*http.Request, error) {
+			order = append(order, name)
+			r := req.Clone(req.Context())
+			r.Header.Add("X-Stage", name)
+			return r, nil
+		}
+	}
+	req := mustNewRequest(t, "GET", "http://example.com/", nil)
+	ms := []Middleware{tag("a"), tag("b")}
+	chain := Chain(ms...)
+	ms[0] = tag("replaced")
+
+	got, err := chain(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b"}; !slices.Equal(order, want) || !slices.Equal(got.Header["X-Stage"], want) {
+		t.Errorf("stages ran %v, header %v; want %v", order, got.Header["X-Stage"], want)
+	}
+	if len(req.Header["X-Stage"]) != 0 {
+		t.Error("original request modified")
+	}
+
+	if got, err := Chain()(req); got != req || err != nil {
+		t.Errorf("empty Chain = %p, %v; want the request unchanged", got, err)
+	}
+}
+
+func TestChainStops(t *testing.T) {
+	errReject := errors.New("rejected")
+	var ran bool
+	after := func(req *http.Request) (*http.Request, error) {
+		ran = true
+		return req, nil
+	}
+	req := mustNewRequest(t, "GET", "http://example.com/", nil)
+
+	reject := func(*http.Request) (*http.Request, error) { return nil, errReject }
+	if got, err := Chain(reject, after)(req); got != nil || err != errReject {
+		t.Errorf("Chain = %v, %v; want nil, %v", got, err, errReject)
+	}
+	drop := func(*http.Request) (*http.Request, error) { return nil, nil }
+	if got, err := Chain(drop, after)(req); got != nil || err != errNilMiddlewareRequest {
+		t.Errorf("Chain = %v, %v; want nil, %v", got, err, errNilMiddlewareRequest)
+	}
+	if ran {
+		t.Error("middleware after a failed stage ran")
+	}
+}
@@ -0,0 +1,114 @@
+package http
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// ErrMultipartTooLarge is returned when a multipart body read through a
+// MultipartStream is larger than the limit passed to ReadMultipart.
+var ErrMultipartTooLarge = errors.New("http: multipart body too large")
+
+// ErrMultipartPartTooLarge is returned when reading a part of a
+// MultipartStream whose content is larger than MaxPartSize.
+var ErrMultipartPartTooLarge = errors.New("http: multipart part too large")
+
+// MultipartStream reads the parts of a multipart request body one at a
+// time, without buffering them in memory or on disk. It is created by
+// ReadMultipart.
+type MultipartStream struct {
+	// MaxPartSize, if positive, limits the number of content bytes
+	// that can be read from each part. Reading past it fails with
+	// ErrMultipartPartTooLarge.
+	MaxPartSize int64
+
+	mr *multipart.Reader
+}
+
+// MultipartPart is a single part of a MultipartStream. Its Read
+// method enforces the stream's MaxPartSize.
+type MultipartPart struct {
+	*multipart.Part
+
+	r io.Reader
+}
+
+// Read reads the content of the part.
+func (p *MultipartPart) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
+
+// ReadMultipart returns a MultipartStream reading the parts of req's
+// multipart/form-data or multipart/mixed body, using the boundary from
+// its Content-Type header.
+//
+// At most maxMemory bytes of the raw body, including boundaries and
+// part headers, are read; reading beyond that fails with
+// ErrMultipartTooLarge. A maxMemory of zero or less means no limit.
+// Nothing is retained after a part has been read, so the memory used
+// does not grow with the size of the body.
+func ReadMultipart(req *http.Request, maxMemory int64) (*MultipartStream, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, errors.New("http: missing multipart body")
+	}
+	v := req.Header.Get("Content-Type")
+	if v == "" {
+		return nil, http.ErrNotMultipart
+	}
+	d, params, err := mime.ParseMediaType(v)
+	if err != nil || (d != "multipart/form-data" && d != "multipart/mixed") {
+		return nil, http.ErrNotMultipart
+	}
+	boundary, ok := params["boundary"]
+	if !ok || strings.TrimSpace(boundary) == "" {
+		return nil, http.ErrMissingBoundary
+	}
+	var body io.Reader = req.Body
+	if maxMemory > 0 {
+		body = &sizeLimitReader{r: body, n: maxMemory, err: ErrMultipartTooLarge}
+	}
+	return &MultipartStream{mr: multipart.NewReader(body, boundary)}, nil
+}
+
+// NextPart returns the next part of the body, or io.EOF after the last
+// one. The previous part is discarded; it must not be read anymore.
+func (s *MultipartStream) NextPart() (*MultipartPart, error) {
+	p, err := s.mr.NextPart()
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = p
+	if s.MaxPartSize > 0 {
+		r = &sizeLimitReader{r: p, n: s.MaxPartSize, err: ErrMultipartPartTooLarge}
+	}
+	return &MultipartPart{Part: p, r: r}, nil
+}
+
+// sizeLimitReader reads at most n bytes from r and returns err, instead
+// of io.EOF, if r has more data than that.
+type sizeLimitReader struct {
+	r   io.Reader
+	n   int64 // bytes left; negative once the limit was crossed
+	err error
+}
+
+func (l *sizeLimitReader) Read(p []byte) (n int, err error) {
+	if l.n < 0 {
+		return 0, l.err
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err = l.r.Read(p)
+	if int64(n) > l.n {
+		n = int(l.n)
+		l.n = -1
+		return n, l.err
+	}
+	l.n -= int64(n)
+	return n, err
+}
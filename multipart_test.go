@@ -0,0 +1,93 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// newMultipartRequest returns a POST request with a multipart body
+// holding one form field per entry of parts, in order.
+func newMultipartRequest(t *testing.T, parts ...[2]string) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		if err := mw.WriteField(p[0], p[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mw.Close()
+	req := mustNewRequest(t, "POST", "http://example.com/", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestReadMultipart(t *testing.T) {
+	req := newMultipartRequest(t, [2]string{"a", "hello"}, [2]string{"b", strings.Repeat("x", 100)})
+	s, err := ReadMultipart(req, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.MaxPartSize = 10
+	p, err := s.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(p); p.FormName() != "a" || string(b) != "hello" || err != nil {
+		t.Errorf("part 1 = %q: %q, %v; want \"a\": \"hello\"", p.FormName(), b, err)
+	}
+	p, err = s.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(p); len(b) != 10 || err != ErrMultipartPartTooLarge {
+		t.Errorf("part 2: read %d bytes, %v; want 10, ErrMultipartPartTooLarge", len(b), err)
+	}
+	if _, err := s.NextPart(); err != io.EOF {
+		t.Errorf("NextPart after last part: %v; want io.EOF", err)
+	}
+}
+
+func TestReadMultipartTooLarge(t *testing.T) {
+	req := newMultipartRequest(t, [2]string{"a", strings.Repeat("x", 1000)})
+	s, err := ReadMultipart(req, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := s.NextPart()
+	if err == nil {
+		_, err = io.ReadAll(p)
+	}
+	if !errors.Is(err, ErrMultipartTooLarge) {
+		t.Errorf("error = %v; want ErrMultipartTooLarge", err)
+	}
+}
+
+func TestReadMultipartBadRequest(t *testing.T) {
+	tests := []struct {
+		ct      string
+		wantErr error
+	}{
+		{"", http.ErrNotMultipart},
+		{"text/plain", http.ErrNotMultipart},
+		{"multipart/form-data", http.ErrMissingBoundary},
+		{"multipart/mixed; boundary=\" \"", http.ErrMissingBoundary},
+	}
+	for _, tt := range tests {
+		req := mustNewRequest(t, "POST", "http://example.com/", strings.NewReader("x"))
+		req.Header.Set("Content-Type", tt.ct)
+		if _, err := ReadMultipart(req, 0); err != tt.wantErr {
+			t.Errorf("Content-Type %q: error = %v; want %v", tt.ct, err, tt.wantErr)
+		}
+	}
+	req := mustNewRequest(t, "POST", "http://example.com/", nil)
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	if _, err := ReadMultipart(req, 0); err == nil {
+		t.Error("ReadMultipart without a body succeeded")
+	}
+}
@@ -0,0 +1,168 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/puernya/go-http/internal/ascii"
+)
+
+// NegotiateContentType returns the offer that best matches the Accept
+// header of req, following the rules of RFC 9110 (formerly RFC 7231),
+// Section 12.5.1: each offer, a media type such as "text/html", gets
+// the q-value of the most specific media range matching it, where
+// "type/subtype" beats "type/*", which beats "*/*", and the offer with
+// the highest non-zero q-value wins, the earliest in offers on a tie.
+// An explicit q=0 excludes an offer even if a wildcard accepts it.
+// Media type parameters are not compared.
+//
+// Without an Accept header, the first offer is returned. It returns ""
+// if no offer is acceptable.
+func NegotiateContentType(req *http.Request, offers []string) string {
+	return negotiate(req.Header["Accept"], offers, "", mediaRangeSpecificity)
+}
+
+// NegotiateEncoding returns the content coding among offers, such as
+// "gzip" or "identity", that best matches the Accept-Encoding header of
+// req, as NegotiateContentType does for Accept (RFC 9110, Section
+// 12.5.3). "*" matches any coding, and "identity" is acceptable unless
+// excluded with q=0, explicitly or through "*".
+//
+// Without an Accept-Encoding header, the first offer is returned. It
+// returns "" if no offer is acceptable.
+func NegotiateEncoding(req *http.Request, offers []string) string {
+	return negotiate(req.Header["Accept-Encoding"], offers, "identity", codingSpecificity)
+}
+
+// NegotiateLanguage returns the language tag among offers, such as
+// "en-US", that best matches the Accept-Language header of req, as
+// NegotiateContentType does for Accept (RFC 9110, Section 12.5.4). A
+// language range matches a tag equal to it or starting with it
+// followed by "-", so "en" matches "en-US", and longer ranges are more
+// specific; "*" matches any tag.
+//
+// Without an Accept-Language header, the first offer is returned. It
+// returns "" if no offer is acceptable.
+func NegotiateLanguage(req *http.Request, offers []string) string {
+	return negotiate(req.Header["Accept-Language"], offers, "", languageRangeSpecificity)
+}
+
+// An acceptSpec is one element of an Accept-style header.
+type acceptSpec struct {
+	value string
+	q     float64
+}
+
+// parseAccept parses the elements of the Accept-style field values vv,
+// dropping those with an invalid q-value.
+func parseAccept(vv []string) []acceptSpec {
+	var specs []acceptSpec
+	for _, v := range vv {
+		for elem := range strings.SplitSeq(v, ",") {
+			value, params, _ := strings.Cut(elem, ";")
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			spec := acceptSpec{value: value, q: 1}
+			valid := true
+			for p := range strings.SplitSeq(params, ";") {
+				name, qv, _ := strings.Cut(strings.TrimSpace(p), "=")
+				if !ascii.EqualFold(name, "q") {
+					continue
+				}
+				q, err := strconv.ParseFloat(strings.TrimSpace(qv), 64)
+				if err != nil || q < 0 || q > 1 {
+					valid = false
+				}
+				spec.q = q
+				break
+			}
+			if valid {
+				specs = append(specs, spec)
+			}
+		}
+	}
+	return specs
+}
+
+// negotiate returns the offer with the highest q-value under the
+// header field values vv, where match reports how specifically a spec
+// value matches an offer, 0 meaning not at all. implicit names an
+// offer that is acceptable unless excluded, if not "".
+func negotiate(vv []string, offers []string, implicit string, match func(spec, offer string) int) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if vv == nil {
+		return offers[0]
+	}
+	specs := parseAccept(vv)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, 0
+		if implicit != "" && ascii.EqualFold(offer, implicit) {
+			q = 0.001 // below any explicit non-zero weight
+		}
+		for _, spec := range specs {
+			if s := match(spec.value, offer); s > specificity {
+				q, specificity = spec.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// mediaRangeSpecificity reports how specifically the media range r
+// matches the media type offer.
+func mediaRangeSpecificity(r, offer string) int {
+	offer, _, _ = strings.Cut(offer, ";")
+	offer = strings.TrimSpace(offer)
+	if r == "*/*" || r == "*" {
+		return 1
+	}
+	rtype, rsub, ok := strings.Cut(r, "/")
+	otype, osub, _ := strings.Cut(offer, "/")
+	if !ok || !ascii.EqualFold(rtype, otype) {
+		return 0
+	}
+	if rsub == "*" {
+		return 2
+	}
+	if ascii.EqualFold(rsub, osub) {
+		return 3
+	}
+	return 0
+}
+
+// codingSpecificity reports how specifically the coding c matches the
+// coding offer.
+func codingSpecificity(c, offer string) int {
+	if c == "*" {
+		return 1
+	}
+	if ascii.EqualFold(c, offer) {
+		return 2
+	}
+	return 0
+}
+
+// languageRangeSpecificity reports how specifically the language range
+// r matches the language tag offer, by the basic filtering of RFC
+// 4647, Section 3.3.1.
+func languageRangeSpecificity(r, offer string) int {
+	if r == "*" {
+		return 1
+	}
+	if len(offer) < len(r) || !ascii.EqualFold(offer[:len(r)], r) {
+		return 0
+	}
+	if len(offer) > len(r) && offer[len(r)] != '-' {
+		return 0
+	}
+	return 1 + len(r)
+}
@@ -0,0 +1,50 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	html, json, text := "text/html", "application/json", "text/plain"
+	tests := []struct {
+		name   string
+		fn     func(*http.Request, []string) string
+		header string
+		value  []string // nil for no header field
+		offers []string
+		want   string
+	}{
+		{"type no header", NegotiateContentType, "Accept", nil, []string{json, html}, json},
+		{"type exact", NegotiateContentType, "Accept", []string{"text/html"}, []string{json, html}, html},
+		{"type q", NegotiateContentType, "Accept", []string{"text/html;q=0.5, application/json"}, []string{html, json}, json},
+		{"type specificity", NegotiateContentType, "Accept", []string{"text/*;q=0.9, text/plain;q=0.1, */*;q=0.5"}, []string{text, html, json}, html},
+		{"type tie", NegotiateContentType, "Accept", []string{"*/*"}, []string{text, html}, text},
+		{"type excluded", NegotiateContentType, "Accept", []string{"*/*, text/html;q=0"}, []string{html, json}, json},
+		{"type none", NegotiateContentType, "Accept", []string{"image/png"}, []string{html}, ""},
+		{"type invalid q", NegotiateContentType, "Accept", []string{"text/html;q=2, application/json;q=0.1"}, []string{html, json}, json},
+		{"type multiple fields", NegotiateContentType, "Accept", []string{"image/png", "TEXT/HTML"}, []string{json, html}, html},
+		{"type no offers", NegotiateContentType, "Accept", []string{"*/*"}, nil, ""},
+
+		{"encoding no header", NegotiateEncoding, "Accept-Encoding", nil, []string{"br", "gzip"}, "br"},
+		{"encoding q", NegotiateEncoding, "Accept-Encoding", []string{"gzip;q=0.8, br"}, []string{"gzip", "br"}, "br"},
+		{"encoding identity implicit", NegotiateEncoding, "Accept-Encoding", []string{"br"}, []string{"gzip", "identity"}, "identity"},
+		{"encoding identity excluded", NegotiateEncoding, "Accept-Encoding", []string{"br, identity;q=0"}, []string{"gzip", "identity"}, ""},
+		{"encoding identity wildcard excluded", NegotiateEncoding, "Accept-Encoding", []string{"*;q=0"}, []string{"identity"}, ""},
+		{"encoding wildcard", NegotiateEncoding, "Accept-Encoding", []string{"*"}, []string{"zstd"}, "zstd"},
+
+		{"language prefix", NegotiateLanguage, "Accept-Language", []string{"en"}, []string{"fr", "en-US"}, "en-US"},
+		{"language not prefix", NegotiateLanguage, "Accept-Language", []string{"en"}, []string{"eng"}, ""},
+		{"language longer range", NegotiateLanguage, "Accept-Language", []string{"en;q=0.5, en-GB"}, []string{"en-US", "en-GB"}, "en-GB"},
+		{"language wildcard", NegotiateLanguage, "Accept-Language", []string{"de, *;q=0.1"}, []string{"fr", "de-AT"}, "de-AT"},
+	}
+	for _, tt := range tests {
+		req := &http.Request{Header: http.Header{}}
+		if tt.value != nil {
+			req.Header[tt.header] = tt.value
+		}
+		if got := tt.fn(req, tt.offers); got != tt.want {
+			t.Errorf("%s: %s %q, offers %q = %q; want %q", tt.name, tt.header, tt.value, tt.offers, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidProxyHeader is returned when a connection starts with a
+// malformed PROXY protocol header.
+var ErrInvalidProxyHeader = errors.New("http: invalid PROXY protocol header")
+
+// ProxyInfo holds the connection addresses carried by a PROXY protocol
+// header, as sent by load balancers in front of a server.
+type ProxyInfo struct {
+	// Version is the PROXY protocol version, 1 or 2.
+	Version int
+
+	// Local reports a version 2 LOCAL command, which the proxy sends
+	// for its own connections such as health checks. Source and
+	// Destination are nil for it.
+	Local bool
+
+	// Source and Destination are the addresses of the original
+	// connection, of type *net.TCPAddr, *net.UDPAddr or *net.UnixAddr.
+	// They are nil if the proxy did not know them.
+	Source      net.Addr
+	Destination net.Addr
+}
+
+const (
+	proxyV1Prefix = "PROXY "
+	proxyV1MaxLen = 107 // including CRLF
+	proxyV2Sig    = "\r\n\r\n\x00\r\nQUIT\n"
+)
+
+// ReadRequestWithProxyProto reads a PROXY protocol version 1 or 2
+// header from b, if one is present, followed by an HTTP/1 request.
+// The returned ProxyInfo is nil if b did not start with a PROXY header.
+//
+// A malformed header results in an error wrapping
+// ErrInvalidProxyHeader, and no request is read.
+func ReadRequestWithProxyProto(b *bufio.Reader) (*http.Request, *ProxyInfo, error) {
+	info, err := readProxyHeader(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := readRequest(b, nil)
+	if err != nil {
+		return nil, info, err
+	}
+	return req, info, nil
+}
+
+// readProxyHeader consumes a PROXY protocol header from b. It returns
+// nil, nil and consumes nothing if there is none.
+func readProxyHeader(b *bufio.Reader) (*ProxyInfo, error) {
+	// Peek one byte first so that short non-PROXY input isn't
+	// blocked on waiting for a full signature.
+	p, err := b.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch p[0] {
+	case proxyV1Prefix[0]:
+		if p, err := b.Peek(len(proxyV1Prefix)); err != nil || string(p) != proxyV1Prefix {
+			return nil, nil
+		}
+		return readProxyV1(b)
+	case proxyV2Sig[0]:
+		if p, err := b.Peek(len(proxyV2Sig)); err != nil || string(p) != proxyV2Sig {
+			return nil, nil
+		}
+		return readProxyV2(b)
+	}
+	return nil, nil
+}
+
+func proxyHeaderError(msg string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidProxyHeader, msg)
+}
+
+func readProxyV1(b *bufio.Reader) (*ProxyInfo, error) {
+	line, err := b.ReadSlice('\n')
+	if len(line) > proxyV1MaxLen || err == bufio.ErrBufferFull {
+		return nil, proxyHeaderError("v1 line too long")
+	}
+	if err != nil {
+		return nil, err
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, proxyHeaderError("v1 line not terminated by CRLF")
+	}
+	f := strings.Split(s, " ")
+	info := &ProxyInfo{Version: 1}
+	if len(f) >= 2 && f[1] == "UNKNOWN" {
+		return info, nil
+	}
+	if len(f) != 6 {
+		return nil, proxyHeaderError("malformed v1 line")
+	}
+	src, err1 := parseProxyV1Addr(f[1], f[2], f[4])
+	dst, err2 := parseProxyV1Addr(f[1], f[3], f[5])
+	if err := errors.Join(err1, err2); err != nil {
+		return nil, err
+	}
+	info.Source, info.Destination = src, dst
+	return info, nil
+}
+
+func parseProxyV1Addr(proto, ip, port string) (net.Addr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Zone() != "" {
+		return nil, proxyHeaderError("bad v1 address " + strconv.Quote(ip))
+	}
+	switch {
+	case proto == "TCP4" && addr.Is4(), proto == "TCP6" && addr.Is6():
+	default:
+		return nil, proxyHeaderError("bad v1 protocol " + strconv.Quote(proto))
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, proxyHeaderError("bad v1 port " + strconv.Quote(port))
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(n))), nil
+}
+
+func readProxyV2(b *bufio.Reader) (*ProxyInfo, error) {
+	hdr, err := b.Peek(len(proxyV2Sig) + 4)
+	if err != nil {
+		return nil, err
+	}
+	verCmd, famProto := hdr[12], hdr[13]
+	n := int(binary.BigEndian.Uint16(hdr[14:]))
+	if verCmd>>4 != 2 {
+		return nil, proxyHeaderError("unsupported v2 version")
+	}
+	b.Discard(len(hdr))
+	body := make([]byte, n)
+	if _, err := io.ReadFull(b, body); err != nil {
+		return nil, err
+	}
+
+	info := &ProxyInfo{Version: 2}
+	switch verCmd & 0xf {
+	case 0x0: // LOCAL
+		info.Local = true
+		return info, nil
+	case 0x1: // PROXY
+	default:
+		return nil, proxyHeaderError("unknown v2 command")
+	}
+
+	fam, proto := famProto>>4, famProto&0xf
+	if proto != 1 && proto != 2 {
+		// UNSPEC or unknown transport: addresses are not usable.
+		return info, nil
+	}
+	var ipLen int
+	switch fam {
+	case 0x1:
+		ipLen = 4
+	case 0x2:
+		ipLen = 16
+	case 0x3:
+		const unixLen = 108
+		if len(body) < 2*unixLen {
+			return nil, proxyHeaderError("short v2 address block")
+		}
+		network := "unix"
+		if proto == 2 {
+			network = "unixgram"
+		}
+		unixPath := func(p []byte) string {
+			if i := bytes.IndexByte(p, 0); i >= 0 {
+				p = p[:i]
+			}
+			return string(p)
+		}
+		info.Source = &net.UnixAddr{Name: unixPath(body[:unixLen]), Net: network}
+		info.Destination = &net.UnixAddr{Name: unixPath(body[unixLen : 2*unixLen]), Net: network}
+		return info, nil
+	default:
+		return info, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, proxyHeaderError("short v2 address block")
+	}
+	srcIP, _ := netip.AddrFromSlice(body[:ipLen])
+	dstIP, _ := netip.AddrFromSlice(body[ipLen : 2*ipLen])
+	src := netip.AddrPortFrom(srcIP, binary.BigEndian.Uint16(body[2*ipLen:]))
+	dst := netip.AddrPortFrom(dstIP, binary.BigEndian.Uint16(body[2*ipLen+2:]))
+	if proto == 1 {
+		info.Source, info.Destination = net.TCPAddrFromAddrPort(src), net.TCPAddrFromAddrPort(dst)
+	} else {
+		info.Source, info.Destination = net.UDPAddrFromAddrPort(src), net.UDPAddrFromAddrPort(dst)
+	}
+	return info, nil
+}
+
+// writeProxyHeader writes the PROXY protocol header described by p to
+// conn, defaulting its addresses to those of conn.
+func writeProxyHeader(conn net.Conn, p *ProxyInfo) error {
+	info := *p
+	if !info.Local {
+		if info.Source == nil {
+			info.Source = conn.LocalAddr()
+		}
+		if info.Destination == nil {
+			info.Destination = conn.RemoteAddr()
+		}
+	}
+	var (
+		b   []byte
+		err error
+	)
+	switch info.Version {
+	case 1:
+		b, err = appendProxyV1(nil, &info)
+	case 2:
+		b, err = appendProxyV2(nil, &info)
+	default:
+		err = fmt.Errorf("http: unsupported PROXY protocol version %d", info.Version)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(b)
+	return err
+}
+
+// proxyAddrPort returns the IP address and port of a TCP or UDP address.
+func proxyAddrPort(a net.Addr) (netip.AddrPort, bool) {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		return a.AddrPort(), true
+	case *net.UDPAddr:
+		return a.AddrPort(), true
+	}
+	return netip.AddrPort{}, false
+}
+
+func appendProxyV1(b []byte, info *ProxyInfo) ([]byte, error) {
+	src, ok1 := proxyAddrPort(info.Source)
+	dst, ok2 := proxyAddrPort(info.Destination)
+	_, isTCP := info.Source.(*net.TCPAddr)
+	srcIP, dstIP := src.Addr().Unmap(), dst.Addr().Unmap()
+	if info.Local || !ok1 || !ok2 || !isTCP || srcIP.Is4() != dstIP.Is4() {
+		return append(b, "PROXY UNKNOWN\r\n"...), nil
+	}
+	proto := "TCP6"
+	if srcIP.Is4() {
+		proto = "TCP4"
+	}
+	b = fmt.Appendf(b, "PROXY %s %s %s %d %d\r\n", proto, srcIP.WithZone(""), dstIP.WithZone(""), src.Port(), dst.Port())
+	return b, nil
+}
+
+func appendProxyV2(b []byte, info *ProxyInfo) ([]byte, error) {
+	b = append(b, proxyV2Sig...)
+	if info.Local {
+		return append(b, 0x20, 0x00, 0, 0), nil
+	}
+	if su, ok := info.Source.(*net.UnixAddr); ok {
+		du, ok := info.Destination.(*net.UnixAddr)
+		if !ok {
+			return nil, errors.New("http: PROXY header addresses of different families")
+		}
+		const unixLen = 108
+		if len(su.Name) >= unixLen || len(du.Name) >= unixLen {
+			return nil, errors.New("http: PROXY header unix address too long")
+		}
+		proto := byte(0x1)
+		if su.Net == "unixgram" {
+			proto = 0x2
+		}
+		b = append(b, 0x21, 0x30|proto)
+		b = binary.BigEndian.AppendUint16(b, 2*unixLen)
+		b = append(b, su.Name...)
+		b = append(b, make([]byte, unixLen-len(su.Name))...)
+		b = append(b, du.Name...)
+		return append(b, make([]byte, unixLen-len(du.Name))...), nil
+	}
+	src, ok1 := proxyAddrPort(info.Source)
+	dst, ok2 := proxyAddrPort(info.Destination)
+	if !ok1 || !ok2 {
+		// Unknown address types: send a PROXY command without addresses.
+		return append(b, 0x21, 0x00, 0, 0), nil
+	}
+	proto := byte(0x1)
+	if _, ok := info.Source.(*net.UDPAddr); ok {
+		proto = 0x2
+	}
+	srcIP, dstIP := src.Addr().Unmap().WithZone(""), dst.Addr().Unmap().WithZone("")
+	if srcIP.Is4() != dstIP.Is4() {
+		srcIP, dstIP = netip.AddrFrom16(srcIP.As16()), netip.AddrFrom16(dstIP.As16())
+	}
+	fam, n := byte(0x10), 12
+	if !srcIP.Is4() {
+		fam, n = 0x20, 36
+	}
+	b = append(b, 0x21, fam|proto)
+	b = binary.BigEndian.AppendUint16(b, uint16(n))
+	b = append(b, srcIP.AsSlice()...)
+	b = append(b, dstIP.AsSlice()...)
+	b = binary.BigEndian.AppendUint16(b, src.Port())
+	return binary.BigEndian.AppendUint16(b, dst.Port()), nil
+}
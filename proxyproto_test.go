@@ -0,0 +1,120 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadRequestWithProxyProto(t *testing.T) {
+	const req = "GET /path HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	v2 := func(verCmd, famProto byte, body string) string {
+		return proxyV2Sig + string([]byte{verCmd, famProto, 0, byte(len(body))}) + body
+	}
+	tests := []struct {
+		name     string
+		in       string
+		src, dst string // "" for a nil address
+		version  int
+		local    bool
+		noHeader bool
+	}{
+		{
+			name:    "v1 tcp4",
+			in:      "PROXY TCP4 1.2.3.4 5.6.7.8 1111 2222\r\n" + req,
+			src:     "1.2.3.4:1111",
+			dst:     "5.6.7.8:2222",
+			version: 1,
+		},
+		{
+			name:    "v1 tcp6",
+			in:      "PROXY TCP6 2001:db8::1 2001:db8::2 1111 2222\r\n" + req,
+			src:     "[2001:db8::1]:1111",
+			dst:     "[2001:db8::2]:2222",
+			version: 1,
+		},
+		{
+			name:    "v1 unknown",
+			in:      "PROXY UNKNOWN\r\n" + req,
+			version: 1,
+		},
+		{
+			name:    "v2 tcp4",
+			in:      v2(0x21, 0x11, "\x01\x02\x03\x04\x05\x06\x07\x08\x04\x57\x08\xae") + req,
+			src:     "1.2.3.4:1111",
+			dst:     "5.6.7.8:2222",
+			version: 2,
+		},
+		{
+			name:    "v2 local",
+			in:      v2(0x20, 0x00, "") + req,
+			version: 2,
+			local:   true,
+		},
+		{
+			name:     "no header",
+			in:       req,
+			noHeader: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, info, err := ReadRequestWithProxyProto(bufio.NewReader(strings.NewReader(tt.in)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Method != "GET" || r.URL.Path != "/path" || r.Host != "example.com" {
+				t.Errorf("request = %s %s (Host %q); want GET /path (Host %q)", r.Method, r.URL.Path, r.Host, "example.com")
+			}
+			if tt.noHeader {
+				if info != nil {
+					t.Errorf("ProxyInfo = %+v; want nil", info)
+				}
+				return
+			}
+			if info == nil {
+				t.Fatal("ProxyInfo = nil")
+			}
+			if info.Version != tt.version || info.Local != tt.local {
+				t.Errorf("Version, Local = %d, %v; want %d, %v", info.Version, info.Local, tt.version, tt.local)
+			}
+			if got := addrString(info.Source); got != tt.src {
+				t.Errorf("Source = %q; want %q", got, tt.src)
+			}
+			if got := addrString(info.Destination); got != tt.dst {
+				t.Errorf("Destination = %q; want %q", got, tt.dst)
+			}
+		})
+	}
+}
+
+func TestReadRequestWithProxyProtoInvalid(t *testing.T) {
+	const req = "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	tests := []string{
+		"PROXY TCP4 1.2.3.4 5.6.7.8 1111\r\n",
+		"PROXY TCP4 2001:db8::1 2001:db8::2 1111 2222\r\n",
+		"PROXY TCP4 1.2.3.4 5.6.7.8 1111 99999\r\n",
+		"PROXY TCP4 1.2.3.4 5.6.7.8 1111 2222\n",
+		"PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n",
+		proxyV2Sig + "\x11\x11\x00\x00",
+		proxyV2Sig + "\x21\x11\x00\x04\x01\x02\x03\x04",
+	}
+	for _, in := range tests {
+		r, _, err := ReadRequestWithProxyProto(bufio.NewReader(strings.NewReader(in + req)))
+		if !errors.Is(err, ErrInvalidProxyHeader) {
+			t.Errorf("ReadRequestWithProxyProto(%q) error = %v; want ErrInvalidProxyHeader", in, err)
+		}
+		if r != nil {
+			t.Errorf("ReadRequestWithProxyProto(%q) returned a request", in)
+		}
+	}
+}
+
+func addrString(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+	return a.String()
+}
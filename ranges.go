@@ -0,0 +1,160 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidContentRange is returned by ParseContentRange for a
+// missing or malformed Content-Range header.
+var ErrInvalidContentRange = errors.New("http: invalid Content-Range")
+
+// SetRangeHeader sets the Range header of req to ask for the bytes from
+// start to end, inclusive. A negative end asks for everything from
+// start on ("bytes=500-").
+func SetRangeHeader(req *http.Request, start, end int64) {
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	v := "bytes=" + strconv.FormatInt(start, 10) + "-"
+	if end >= 0 {
+		v += strconv.FormatInt(end, 10)
+	}
+	req.Header.Set("Range", v)
+}
+
+// ParseContentRange parses the Content-Range header of res, such as
+// that of a 206 Partial Content response, and returns the first and
+// last byte positions of the range it holds, inclusive, and the
+// complete length of the representation, or -1 if the server does not
+// know it ("bytes 0-499/*"). For an unsatisfied range ("bytes */1234"),
+// as sent with 416 Range Not Satisfiable, start and end are -1.
+func ParseContentRange(res *http.Response) (start, end, total int64, err error) {
+	return parseContentRange(res.Header.Get("Content-Range"))
+}
+
+func parseContentRange(v string) (start, end, total int64, err error) {
+	rng, ok := strings.CutPrefix(v, "bytes ")
+	if !ok {
+		return 0, 0, 0, ErrInvalidContentRange
+	}
+	rng, size, ok := strings.Cut(rng, "/")
+	if !ok {
+		return 0, 0, 0, ErrInvalidContentRange
+	}
+	total = -1
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil || total < 0 {
+			return 0, 0, 0, ErrInvalidContentRange
+		}
+	}
+	if rng == "*" {
+		if total < 0 {
+			return 0, 0, 0, ErrInvalidContentRange
+		}
+		return -1, -1, total, nil
+	}
+	first, last, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, 0, ErrInvalidContentRange
+	}
+	start, err1 := strconv.ParseInt(first, 10, 64)
+	end, err2 := strconv.ParseInt(last, 10, 64)
+	if err1 != nil || err2 != nil || start < 0 || end < start || (total >= 0 && end >= total) {
+		return 0, 0, 0, ErrInvalidContentRange
+	}
+	return start, end, total, nil
+}
+
+// maxByteRangesSize limits the total size of the parts ReadByteRanges
+// holds in memory.
+const maxByteRangesSize = 32 << 20
+
+// A ByteRangePart is one range of a 206 Partial Content response, as
+// returned by ReadByteRanges.
+type ByteRangePart struct {
+	// Start and End are the first and last byte positions of the
+	// range, inclusive, and Total is the complete length of the
+	// representation, or -1 if unknown.
+	Start, End, Total int64
+
+	// Header holds the part's header fields, including its
+	// Content-Type, if any, and Content-Range. For a single-range
+	// response, it is the response header.
+	Header http.Header
+
+	// Body reads the End-Start+1 bytes of the range.
+	Body io.Reader
+}
+
+// ReadByteRanges returns the ranges in the 206 Partial Content
+// response res.
+//
+// For a multipart/byteranges response, the parts are read into memory,
+// and res.Body is read to the end but not closed. Each part must hold
+// exactly the bytes its Content-Range announces, and all parts
+// together at most 32 MB. A response with a single range is returned
+// as one part whose Body is res.Body.
+func ReadByteRanges(res *http.Response) ([]ByteRangePart, error) {
+	if res.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("http: response status %d is not 206 Partial Content", res.StatusCode)
+	}
+	d, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil || d != "multipart/byteranges" {
+		start, end, total, err := ParseContentRange(res)
+		if err != nil || start < 0 {
+			return nil, ErrInvalidContentRange
+		}
+		return []ByteRangePart{{Start: start, End: end, Total: total, Header: res.Header, Body: res.Body}}, nil
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, http.ErrMissingBoundary
+	}
+
+	var parts []ByteRangePart
+	var size int64
+	mr := multipart.NewReader(res.Body, boundary)
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, end, total, err := parseContentRange(p.Header.Get("Content-Range"))
+		if err != nil || start < 0 {
+			return nil, ErrInvalidContentRange
+		}
+		n := end - start + 1
+		if size += n; size > maxByteRangesSize {
+			return nil, errors.New("http: multipart/byteranges response too large")
+		}
+		data, err := io.ReadAll(io.LimitReader(p, n+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) != n {
+			return nil, fmt.Errorf("http: byte range %d-%d holds %d bytes", start, end, len(data))
+		}
+		parts = append(parts, ByteRangePart{
+			Start:  start,
+			End:    end,
+			Total:  total,
+			Header: http.Header(p.Header),
+			Body:   bytes.NewReader(data),
+		})
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("http: multipart/byteranges response has no parts")
+	}
+	return parts, nil
+}
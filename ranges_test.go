@@ -0,0 +1,134 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSetRangeHeader(t *testing.T) {
+	for _, tt := range []struct {
+		start, end int64
+		want       string
+	}{
+		{0, 499, "bytes=0-499"},
+		{500, -1, "bytes=500-"},
+	} {
+		req := &http.Request{}
+		SetRangeHeader(req, tt.start, tt.end)
+		if got := req.Header.Get("Range"); got != tt.want {
+			t.Errorf("SetRangeHeader(%d, %d): Range = %q; want %q", tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		v                 string
+		start, end, total int64
+		ok                bool
+	}{
+		{"bytes 0-499/1234", 0, 499, 1234, true},
+		{"bytes 500-1233/1234", 500, 1233, 1234, true},
+		{"bytes 0-499/*", 0, 499, -1, true},
+		{"bytes */1234", -1, -1, 1234, true},
+		{"", 0, 0, 0, false},
+		{"bytes */*", 0, 0, 0, false},
+		{"items 0-1/2", 0, 0, 0, false},
+		{"bytes 0-499", 0, 0, 0, false},
+		{"bytes 500-499/1234", 0, 0, 0, false},
+		{"bytes 0-1234/1234", 0, 0, 0, false},
+		{"bytes -1-5/10", 0, 0, 0, false},
+		{"bytes 0-x/10", 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		res := &http.Response{Header: http.Header{"Content-Range": {tt.v}}}
+		start, end, total, err := ParseContentRange(res)
+		if !tt.ok {
+			if err != ErrInvalidContentRange {
+				t.Errorf("ParseContentRange(%q) error = %v; want ErrInvalidContentRange", tt.v, err)
+			}
+			continue
+		}
+		if err != nil || start != tt.start || end != tt.end || total != tt.total {
+			t.Errorf("ParseContentRange(%q) = %d, %d, %d, %v; want %d, %d, %d", tt.v, start, end, total, err, tt.start, tt.end, tt.total)
+		}
+	}
+}
+
+func TestReadByteRanges(t *testing.T) {
+	const body = "--B\r\n" +
+		"Content-Type: text/plain\r\nContent-Range: bytes 0-4/20\r\n\r\n" +
+		"hello\r\n" +
+		"--B\r\n" +
+		"Content-Type: text/plain\r\nContent-Range: bytes 10-14/20\r\n\r\n" +
+		"world\r\n" +
+		"--B--\r\n"
+	res := &http.Response{
+		StatusCode: http.StatusPartialContent,
+		Header:     http.Header{"Content-Type": {"multipart/byteranges; boundary=B"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	parts, err := ReadByteRanges(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		start, end int64
+		data       string
+	}{{0, 4, "hello"}, {10, 14, "world"}}
+	if len(parts) != len(want) {
+		t.Fatalf("got %d parts; want %d", len(parts), len(want))
+	}
+	for i, p := range parts {
+		data, _ := io.ReadAll(p.Body)
+		if p.Start != want[i].start || p.End != want[i].end || p.Total != 20 || string(data) != want[i].data ||
+			p.Header.Get("Content-Type") != "text/plain" {
+			t.Errorf("part %d = %d-%d/%d %q; want %d-%d/20 %q", i, p.Start, p.End, p.Total, data, want[i].start, want[i].end, want[i].data)
+		}
+	}
+
+	res = &http.Response{
+		StatusCode: http.StatusPartialContent,
+		Header:     http.Header{"Content-Range": {"bytes 5-9/20"}},
+		Body:       io.NopCloser(strings.NewReader("abcde")),
+	}
+	parts, err = ReadByteRanges(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 1 || parts[0].Start != 5 || parts[0].End != 9 || parts[0].Body != res.Body {
+		t.Errorf("single range parts = %+v; want one part reading res.Body", parts)
+	}
+}
+
+func TestReadByteRangesErrors(t *testing.T) {
+	part := func(rng, data string) string {
+		return "--B\r\nContent-Range: " + rng + "\r\n\r\n" + data + "\r\n"
+	}
+	tests := []struct {
+		name   string
+		status int
+		ctype  string
+		body   string
+	}{
+		{"not 206", 200, "text/plain", ""},
+		{"no boundary", 206, "multipart/byteranges", ""},
+		{"no parts", 206, "multipart/byteranges; boundary=B", "--B--\r\n"},
+		{"short part", 206, "multipart/byteranges; boundary=B", part("bytes 0-9/20", "abc") + "--B--\r\n"},
+		{"long part", 206, "multipart/byteranges; boundary=B", part("bytes 0-1/20", "abc") + "--B--\r\n"},
+		{"bad range", 206, "multipart/byteranges; boundary=B", part("bytes */20", "") + "--B--\r\n"},
+		{"single without range", 206, "text/plain", "abc"},
+	}
+	for _, tt := range tests {
+		res := &http.Response{
+			StatusCode: tt.status,
+			Header:     http.Header{"Content-Type": {tt.ctype}},
+			Body:       io.NopCloser(strings.NewReader(tt.body)),
+		}
+		if parts, err := ReadByteRanges(res); err == nil {
+			t.Errorf("%s: ReadByteRanges = %+v; want an error", tt.name, parts)
+		}
+	}
+}
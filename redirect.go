@@ -0,0 +1,163 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RedirectTarget returns the URL that the redirect response res points
+// to, for a 301, 302, 303, 307 or 308 response with a Location header.
+// A relative Location is resolved against base, or against the URL of
+// res.Request if base is nil. The fragment is removed from the result,
+// since it is never sent in a request. ok is false if res is not a
+// redirect, or its Location is missing or not a valid URL reference.
+func RedirectTarget(res *http.Response, base *url.URL) (target *url.URL, ok bool) {
+	switch res.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, false
+	}
+	loc := res.Header.Get("Location")
+	if loc == "" {
+		return nil, false
+	}
+	u, err := url.Parse(loc)
+	if err != nil {
+		return nil, false
+	}
+	if base == nil && res.Request != nil {
+		base = res.Request.URL
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if !u.IsAbs() {
+		return nil, false
+	}
+	u.Fragment, u.RawFragment = "", ""
+	return u, true
+}
+
+// A RedirectPolicy controls how credentials are carried across
+// redirects. The zero value matches net/http's Client, and is the policy
+// RewriteForRedirect and StripSensitiveOnCrossOrigin apply.
+type RedirectPolicy struct {
+	// ExactHost, if true, keeps credentials only for a redirect to
+	// the same host name, treating subdomains of the original host
+	// as other hosts.
+	ExactHost bool
+}
+
+// RewriteForRedirect returns the request to send to target, as found
+// by RedirectTarget, to follow the redirect res received for req, with
+// the method and body semantics net/http's Client uses:
+//
+//   - 301, 302 and 303 turn any method but GET and HEAD into GET, and
+//     drop the body along with its Content-Type and Content-Length.
+//   - 307 and 308 keep the method and resend the body, which requires
+//     req.GetBody for a request with a body.
+//
+// The header fields of req are copied, except for the credentials
+// StripSensitiveOnCrossOrigin removes when target is on another host.
+// req itself is not modified.
+func RewriteForRedirect(req *http.Request, res *http.Response, target *url.URL) (*http.Request, error) {
+	return RedirectPolicy{}.RewriteForRedirect(req, res, target)
+}
+
+// RewriteForRedirect is like the package-level RewriteForRedirect, but
+// strips credentials according to p.
+func (p RedirectPolicy) RewriteForRedirect(req *http.Request, res *http.Response, target *url.URL) (*http.Request, error) {
+	method := valueOrDefault(req.Method, "GET")
+	includeBody := false
+	switch res.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther:
+		if method != "GET" && method != "HEAD" {
+			method = "GET"
+		}
+	case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		includeBody = true
+	default:
+		return nil, errors.New("http: response is not a redirect")
+	}
+
+	r := req.Clone(req.Context())
+	u := *target
+	r.URL = &u
+	r.Host = ""
+	r.Method = method
+	r.RequestURI = ""
+	r.Response = res
+	if includeBody && req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, errors.New("http: 307 or 308 redirect cannot be followed without Request.GetBody")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	} else if !includeBody {
+		r.Body, r.GetBody, r.ContentLength = nil, nil, 0
+		r.Header.Del("Content-Type")
+		r.Header.Del("Content-Length")
+		r.TransferEncoding = nil
+	}
+	p.StripSensitiveOnCrossOrigin(r, req.URL, target)
+	return r, nil
+}
+
+// StripSensitiveOnCrossOrigin removes the credentials from req's header
+// when a redirect leads from the URL from to the URL to on another
+// host, as net/http's Client does: Authorization, Www-Authenticate,
+// Cookie, Cookie2, Proxy-Authorization and Proxy-Authenticate. Host
+// names are compared without ports and case-insensitively, and a
+// subdomain of from's host counts as the same host, so "sub.foo.com"
+// keeps the credentials sent to "foo.com", while "bar.com" and,
+// conversely, "foo.com" after "sub.foo.com" do not.
+func StripSensitiveOnCrossOrigin(req *http.Request, from, to *url.URL) {
+	RedirectPolicy{}.StripSensitiveOnCrossOrigin(req, from, to)
+}
+
+// StripSensitiveOnCrossOrigin is like the package-level
+// StripSensitiveOnCrossOrigin, but counts subdomains of from's host as
+// other hosts if p.ExactHost is set.
+func (p RedirectPolicy) StripSensitiveOnCrossOrigin(req *http.Request, from, to *url.URL) {
+	if !sameOrSubdomainHost(from, to, !p.ExactHost) {
+		stripSensitiveHeaders(req.Header)
+	}
+}
+
+// sensitiveRedirectHeaders are the header fields that are not sent to
+// another host when following a redirect.
+var sensitiveRedirectHeaders = []string{
+	"Authorization",
+	"Www-Authenticate",
+	"Cookie",
+	"Cookie2",
+	"Proxy-Authorization",
+	"Proxy-Authenticate",
+}
+
+func stripSensitiveHeaders(h http.Header) {
+	for _, k := range sensitiveRedirectHeaders {
+		delete(h, k)
+	}
+}
+
+// sameOrSubdomainHost reports whether to has the same host name as
+// from, ignoring ports and case, or, if allowSubdomains is set, a
+// subdomain of it.
+func sameOrSubdomainHost(from, to *url.URL, allowSubdomains bool) bool {
+	if from == nil || to == nil {
+		return false
+	}
+	fromHost := strings.ToLower(idnaASCIIFromURL(from))
+	toHost := strings.ToLower(idnaASCIIFromURL(to))
+	if fromHost == toHost {
+		return true
+	}
+	return allowSubdomains && strings.HasSuffix(toHost, "."+fromHost)
+}
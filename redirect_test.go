@@ -0,0 +1,177 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestRedirectTarget(t *testing.T) {
+	reqURL := "http://example.com/a/b?q=1"
+	tests := []struct {
+		status int
+		loc    string
+		base   string
+		want   string // "" if not ok
+	}{
+		{301, "http://other.example/x", "", "http://other.example/x"},
+		{302, "/c", "", "http://example.com/c"},
+		{303, "c", "", "http://example.com/a/c"},
+		{307, "?r=2", "", "http://example.com/a/b?r=2"},
+		{308, "//cdn.example/y#frag", "", "http://cdn.example/y"},
+		{302, "/c", "https://base.example/d/", "https://base.example/c"},
+		{200, "/c", "", ""},
+		{304, "/c", "", ""},
+		{302, "", "", ""},
+		{302, "http://[::1", "", ""},
+	}
+	for _, tt := range tests {
+		res := &http.Response{
+			StatusCode: tt.status,
+			Header:     http.Header{},
+			Request:    &http.Request{URL: mustParseURL(t, reqURL)},
+		}
+		if tt.loc != "" {
+			res.Header.Set("Location", tt.loc)
+		}
+		var base *url.URL
+		if tt.base != "" {
+			base = mustParseURL(t, tt.base)
+		}
+		var got string
+		if u, ok := RedirectTarget(res, base); ok {
+			got = u.String()
+		}
+		if got != tt.want {
+			t.Errorf("RedirectTarget(%d %q) = %q; want %q", tt.status, tt.loc, got, tt.want)
+		}
+	}
+
+	// A relative Location without any base is not usable.
+	res := &http.Response{StatusCode: 302, Header: http.Header{"Location": {"/c"}}}
+	if u, ok := RedirectTarget(res, nil); ok {
+		t.Errorf("RedirectTarget without base = %v; want not ok", u)
+	}
+}
+
+func TestRewriteForRedirect(t *testing.T) {
+	newReq := func() *http.Request {
+		req := mustNewRequest(t, "POST", "http://example.com/form", strings.NewReader("data"))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Authorization", "Bearer t")
+		req.Header.Set("X-Keep", "1")
+		return req
+	}
+	tests := []struct {
+		status     int
+		target     string
+		wantMethod string
+		wantBody   string
+		wantAuth   bool
+	}{
+		{303, "http://example.com/done", "GET", "", true},
+		{301, "http://example.com/moved", "GET", "", true},
+		{307, "http://example.com/again", "POST", "data", true},
+		{308, "http://sub.example.com/again", "POST", "data", true},
+		{302, "http://other.example/x", "GET", "", false},
+	}
+	for _, tt := range tests {
+		req := newReq()
+		res := &http.Response{StatusCode: tt.status, Request: req}
+		r, err := RewriteForRedirect(req, res, mustParseURL(t, tt.target))
+		if err != nil {
+			t.Fatalf("%d: %v", tt.status, err)
+		}
+		var body string
+		if r.Body != nil {
+			b, _ := io.ReadAll(r.Body)
+			body = string(b)
+		}
+		if r.Method != tt.wantMethod || body != tt.wantBody || r.URL.String() != tt.target || r.Response != res {
+			t.Errorf("%d: request = %s %s body %q; want %s %s body %q", tt.status, r.Method, r.URL, body, tt.wantMethod, tt.target, tt.wantBody)
+		}
+		if got := r.Header.Get("Content-Type") != ""; got != (tt.wantBody != "") {
+			t.Errorf("%d: Content-Type kept = %v", tt.status, got)
+		}
+		if got := r.Header.Get("Authorization") != ""; got != tt.wantAuth {
+			t.Errorf("%d: Authorization kept = %v; want %v", tt.status, got, tt.wantAuth)
+		}
+		if r.Header.Get("X-Keep") != "1" {
+			t.Errorf("%d: other header fields not copied", tt.status)
+		}
+		if req.Method != "POST" || req.Header.Get("Authorization") == "" {
+			t.Errorf("%d: original request modified", tt.status)
+		}
+	}
+
+	req := newReq()
+	req.GetBody = nil
+	if _, err := RewriteForRedirect(req, &http.Response{StatusCode: 307}, mustParseURL(t, "http://example.com/")); err == nil {
+		t.Error("307 with a body and no GetBody: no error")
+	}
+	if _, err := RewriteForRedirect(newReq(), &http.Response{StatusCode: 200}, mustParseURL(t, "http://example.com/")); err == nil {
+		t.Error("200 response: no error")
+	}
+}
+
+func TestStripSensitiveOnCrossOrigin(t *testing.T) {
+	tests := []struct {
+		from, to  string
+		exactHost bool
+		keep      bool
+	}{
+		{"http://foo.com/a", "https://FOO.com:8443/b", false, true},
+		{"http://foo.com/", "http://sub.foo.com/", false, true},
+		{"http://foo.com/", "http://sub.foo.com/", true, false},
+		{"http://foo.com/", "http://foo.com/x", true, true},
+		{"http://sub.foo.com/", "http://foo.com/", false, false},
+		{"http://foo.com/", "http://bar.com/", false, false},
+		{"http://foo.com/", "http://evilfoo.com/", false, false},
+	}
+	for _, tt := range tests {
+		req := &http.Request{Header: http.Header{
+			"Authorization":       {"Basic x"},
+			"Cookie":              {"a=b"},
+			"Proxy-Authorization": {"Basic y"},
+			"Accept":              {"*/*"},
+		}}
+		from, to := mustParseURL(t, tt.from), mustParseURL(t, tt.to)
+		if tt.exactHost {
+			RedirectPolicy{ExactHost: true}.StripSensitiveOnCrossOrigin(req, from, to)
+		} else {
+			StripSensitiveOnCrossOrigin(req, from, to)
+		}
+		for _, k := range []string{"Authorization", "Cookie", "Proxy-Authorization"} {
+			if _, kept := req.Header[k]; kept != tt.keep {
+				t.Errorf("%s -> %s (ExactHost %v): %s kept = %v; want %v", tt.from, tt.to, tt.exactHost, k, kept, tt.keep)
+			}
+		}
+		if req.Header.Get("Accept") == "" {
+			t.Errorf("%s -> %s: Accept removed", tt.from, tt.to)
+		}
+	}
+}
+
+func TestRedirectPolicyRewrite(t *testing.T) {
+	req := mustNewRequest(t, "GET", "http://foo.com/", nil)
+	req.Header.Set("Authorization", "Bearer t")
+	res := &http.Response{StatusCode: 302, Request: req}
+	r, err := RedirectPolicy{ExactHost: true}.RewriteForRedirect(req, res, mustParseURL(t, "http://sub.foo.com/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Header.Get("Authorization") != "" {
+		t.Error("Authorization sent to a subdomain with ExactHost")
+	}
+}
@@ -0,0 +1,92 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// A ReportToGroup is an endpoint group from a Report-To response
+// header, as defined by the Reporting API.
+type ReportToGroup struct {
+	Group             string             `json:"group"` // "default" if not set
+	MaxAge            int64              `json:"max_age"`
+	Endpoints         []ReportToEndpoint `json:"endpoints"`
+	IncludeSubdomains bool               `json:"include_subdomains"`
+}
+
+// A ReportToEndpoint is an endpoint of a ReportToGroup.
+type ReportToEndpoint struct {
+	URL      string `json:"url"`
+	Priority int    `json:"priority"`
+	Weight   int    `json:"weight"`
+}
+
+// A NELPolicy is the Network Error Logging policy from a NEL response
+// header.
+type NELPolicy struct {
+	ReportTo          string   `json:"report_to"`
+	MaxAge            int64    `json:"max_age"`
+	IncludeSubdomains bool     `json:"include_subdomains"`
+	SuccessFraction   *float64 `json:"success_fraction"` // nil if not set
+	FailureFraction   *float64 `json:"failure_fraction"` // nil if not set
+}
+
+// A HeaderJSONError is returned when a JSON-valued header field is
+// malformed.
+type HeaderJSONError struct {
+	Header string // canonical header field name
+	Err    error  // the JSON decoding error
+}
+
+func (e *HeaderJSONError) Error() string {
+	return fmt.Sprintf("http: malformed %s header: %v", e.Header, e.Err)
+}
+
+func (e *HeaderJSONError) Unwrap() error { return e.Err }
+
+// ParseReportTo returns the endpoint groups in the Report-To fields of
+// h. The field holds a comma-separated list of JSON objects, which may
+// be split across several field lines. It returns nil and no error if
+// h has no Report-To field.
+func ParseReportTo(h http.Header) ([]ReportToGroup, error) {
+	var groups []ReportToGroup
+	if err := parseJSONHeaderList(h, "Report-To", &groups); err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		if groups[i].Group == "" {
+			groups[i].Group = "default"
+		}
+	}
+	return groups, nil
+}
+
+// ParseNEL returns the policy in the NEL field of h, or nil and no
+// error if h has none. If the field has several policies, the first
+// one applies, as the specification requires.
+func ParseNEL(h http.Header) (*NELPolicy, error) {
+	var policies []NELPolicy
+	if err := parseJSONHeaderList(h, "Nel", &policies); err != nil {
+		return nil, err
+	}
+	if len(policies) == 0 {
+		return nil, nil
+	}
+	return &policies[0], nil
+}
+
+// parseJSONHeaderList decodes the comma-separated JSON objects of the
+// field key in h into the slice pointed to by v. The field lines are
+// joined and decoded as a single JSON array.
+func parseJSONHeaderList(h http.Header, key string, v any) error {
+	vv := h[key]
+	if len(vv) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal([]byte("["+strings.Join(vv, ",")+"]"), v); err != nil {
+		return &HeaderJSONError{Header: key, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseReportTo(t *testing.T) {
+	h := http.Header{"Report-To": {
+		`{"group":"csp","max_age":10886400,"endpoints":[{"url":"https://a.example/r","priority":1}]}`,
+		`{"max_age":60,"include_subdomains":true,"endpoints":[{"url":"https://b.example/r","weight":2}]}`,
+	}}
+	got, err := ParseReportTo(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ReportToGroup{
+		{Group: "csp", MaxAge: 10886400, Endpoints: []ReportToEndpoint{{URL: "https://a.example/r", Priority: 1}}},
+		{Group: "default", MaxAge: 60, IncludeSubdomains: true, Endpoints: []ReportToEndpoint{{URL: "https://b.example/r", Weight: 2}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseReportTo = %+v; want %+v", got, want)
+	}
+
+	if got, err := ParseReportTo(http.Header{}); got != nil || err != nil {
+		t.Errorf("ParseReportTo of no field = %v, %v; want nil, nil", got, err)
+	}
+	_, err = ParseReportTo(http.Header{"Report-To": {`{"group":`}})
+	var jerr *HeaderJSONError
+	if !errors.As(err, &jerr) || jerr.Header != "Report-To" || jerr.Err == nil {
+		t.Errorf("ParseReportTo of malformed field: error = %v; want a HeaderJSONError", err)
+	}
+}
+
+func TestParseNEL(t *testing.T) {
+	h := http.Header{"Nel": {`{"report_to":"nel","max_age":86400,"failure_fraction":0.5}, {"report_to":"other"}`}}
+	got, err := ParseNEL(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ReportTo != "nel" || got.MaxAge != 86400 || got.SuccessFraction != nil ||
+		got.FailureFraction == nil || *got.FailureFraction != 0.5 {
+		t.Errorf("ParseNEL = %+v; want the first policy", got)
+	}
+
+	if got, err := ParseNEL(http.Header{}); got != nil || err != nil {
+		t.Errorf("ParseNEL of no field = %v, %v; want nil, nil", got, err)
+	}
+	if _, err := ParseNEL(http.Header{"Nel": {"nope"}}); err == nil {
+		t.Error("ParseNEL of malformed field: no error")
+	}
+}
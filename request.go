@@ -8,16 +8,26 @@ package http
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
+	"net/netip"
+	"net/textproto"
 	"net/url"
 	"reflect"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/puernya/go-http/internal/ascii"
 
+	"golang.org/x/net/http/httpguts"
 	"golang.org/x/net/idna"
 )
 
@@ -68,16 +78,130 @@ func validMethod(method string) bool {
 	return isToken(method)
 }
 
-func readRequest(b *bufio.Reader) (req *http.Request, err error) {
+// ErrRequestURITooLong is returned, wrapped, when reading a request
+// whose target is longer than Server.MaxRequestURIBytes.
+var ErrRequestURITooLong = errors.New("http: request URI too long")
+
+// ErrUnsupportedVersion is returned, wrapped, when reading a request
+// whose request line has a malformed or unsupported HTTP version.
+var ErrUnsupportedVersion = errors.New("http: unsupported HTTP version")
+
+// ErrInvalidHost is returned, wrapped, when reading a request whose
+// host is not a valid RFC 3986 host[:port], if Server.StrictHost is
+// set.
+var ErrInvalidHost = errors.New("http: invalid host")
+
+// A VersionPolicy selects the HTTP versions accepted in request lines.
+// Requests with any other version are rejected with an error wrapping
+// ErrUnsupportedVersion.
+//
+// HTTP/0.9 simple requests, which have no version at all ("GET /"),
+// are not supported under any policy; they fail as malformed.
+type VersionPolicy int
+
+const (
+	// VersionHTTP1Only accepts HTTP/1.x requests only. It is the
+	// default.
+	VersionHTTP1Only VersionPolicy = iota
+
+	// VersionHTTP1AndPreface also accepts the "PRI * HTTP/2.0"
+	// request that starts the HTTP/2 connection preface, so that
+	// handlers can take over the connection and speak HTTP/2
+	// themselves.
+	VersionHTTP1AndPreface
+)
+
+// accepts reports whether the policy allows the version of req, whose
+// Method, RequestURI and ProtoMajor/ProtoMinor are set.
+func (p VersionPolicy) accepts(req *http.Request) bool {
+	if req.ProtoMajor == 1 {
+		return true
+	}
+	return p == VersionHTTP1AndPreface && http1ServerSupportsRequest(req)
+}
+
+// A DisallowedSchemeError is returned when reading a request whose
+// absolute-form target uses a scheme not listed in
+// Server.AbsoluteFormSchemes.
+type DisallowedSchemeError struct {
+	Scheme string
+}
+
+func (e *DisallowedSchemeError) Error() string {
+	return fmt.Sprintf("http: scheme %q not allowed in request target", e.Scheme)
+}
+
+func readRequest(b *bufio.Reader, srv *Server) (*http.Request, error) {
+	req := AcquireRequest()
+	if err := readRequestInto(b, srv, req, req.Header != nil); err != nil {
+		ReleaseRequest(req)
+		return nil, err
+	}
+	return req, nil
+}
+
+var requestPool sync.Pool // of *http.Request
+
+// AcquireRequest returns an empty Request, reusing one previously
+// passed to ReleaseRequest when possible. All fields are zero, except
+// that a reused Request keeps its emptied Header map.
+//
+// The Server draws the requests it reads from the same pool, but
+// never releases them: a handler may retain its Request, or its
+// context, after returning.
+func AcquireRequest() *http.Request {
+	req, ok := requestPool.Get().(*http.Request)
+	if !ok {
+		return new(http.Request)
+	}
+	return req
+}
+
+// ReleaseRequest resets every field of req and returns it to the pool
+// used by AcquireRequest. Nothing req refers to, such as its Body,
+// context, TLS state or MultipartForm, is kept alive by the pool; in
+// particular, the Body is not closed.
+//
+// req and its Header must not be used after the call, by the caller
+// or by anything req was handed to. In particular, a Server handler
+// must not release the Request it was called with: the Server keeps
+// using it after the handler returns. Releasing is always optional;
+// a Request that is not released is simply garbage collected.
+func ReleaseRequest(req *http.Request) {
+	if req == nil {
+		return
+	}
+	h := req.Header
+	clear(h)
+	*req = http.Request{Header: h}
+	requestPool.Put(req)
+}
+
+// ReadRequestInto reads an HTTP/1 request from b into req, like the
+// server does, but reuses the existing req.Header map and its value
+// slices, and the value strings that did not change, instead of
+// allocating new ones. All other fields of req are reset. The result
+// is the same as reading a new request; a header that is completely
+// buffered in b and uses only plain "Name: value" lines is parsed
+// directly from the buffer.
+//
+// ReadRequestInto is meant for request loops that finish with each
+// request before reading the next one: the caller must not retain
+// the header, its value slices, or req across calls.
+func ReadRequestInto(b *bufio.Reader, req *http.Request) error {
+	h := req.Header
+	*req = http.Request{Header: h}
+	return readRequestInto(b, nil, req, h != nil)
+}
+
+func readRequestInto(b *bufio.Reader, srv *Server, req *http.Request, reuseHeader bool) (err error) {
 	tp := newTextprotoReader(b)
 	defer putTextprotoReader(tp)
 
-	req = new(http.Request)
-
 	// First line: GET /index.html HTTP/1.0
 	var s string
 	if s, err = tp.ReadLine(); err != nil {
-		return nil, err
+		return err
 	}
 	defer func() {
 		if err == io.EOF {
@@ -88,14 +212,20 @@ func readRequest(b *bufio.Reader) (req *http.Request, err error) {
 	var ok bool
 	req.Method, req.RequestURI, req.Proto, ok = parseRequestLine(s)
 	if !ok {
-		return nil, badStringError("malformed HTTP request", s)
+		return badStringError("malformed HTTP request", s)
 	}
 	if !validMethod(req.Method) {
-		return nil, badStringError("invalid method", req.Method)
+		return badStringError("invalid method", req.Method)
+	}
+	if n := srv.maxRequestURIBytes(); n > 0 && len(req.RequestURI) > n {
+		return fmt.Errorf("%w: %d bytes", ErrRequestURITooLong, len(req.RequestURI))
 	}
 	rawurl := req.RequestURI
 	if req.ProtoMajor, req.ProtoMinor, ok = http.ParseHTTPVersion(req.Proto); !ok {
-		return nil, badStringError("malformed HTTP version", req.Proto)
+		return fmt.Errorf("%w: malformed HTTP version %q", ErrUnsupportedVersion, req.Proto)
+	}
+	if !srv.versionPolicy().accepts(req) {
+		return fmt.Errorf("%w: %q", ErrUnsupportedVersion, req.Proto)
 	}
 
 	// CONNECT requests are used two different ways, and neither uses a full URL:
@@ -113,22 +243,32 @@ func readRequest(b *bufio.Reader) (req *http.Request, err error) {
 	}
 
 	if req.URL, err = url.ParseRequestURI(rawurl); err != nil {
-		return nil, err
+		return err
 	}
 
 	if justAuthority {
 		// Strip the bogus "http://" back off.
 		req.URL.Scheme = ""
+	} else if req.URL.Scheme != "" && !srv.allowsScheme(req.URL.Scheme) {
+		return &DisallowedSchemeError{Scheme: req.URL.Scheme}
 	}
 
 	// Subsequent lines: Key: value.
-	mimeHeader, err := tp.ReadMIMEHeader()
-	if err != nil {
-		return nil, err
+	padded := rawFramingPadded(b)
+	if !reuseHeader || !readBufferedHeader(b, req.Header) {
+		mimeHeader, err := tp.ReadMIMEHeader()
+		if err != nil {
+			return err
+		}
+		if reuseHeader {
+			clear(req.Header)
+			maps.Copy(req.Header, http.Header(mimeHeader))
+		} else {
+			req.Header = http.Header(mimeHeader)
+		}
 	}
-	req.Header = http.Header(mimeHeader)
 	if len(req.Header["Host"]) > 1 {
-		return nil, fmt.Errorf("too many Host headers")
+		return fmt.Errorf("too many Host headers")
 	}
 
 	// RFC 7230, section 5.3: Must treat
@@ -143,13 +283,18 @@ func readRequest(b *bufio.Reader) (req *http.Request, err error) {
 		req.Host = getFromHeader(req.Header, "Host")
 	}
 
+	if srv.strictHost() && req.Host != "" && !validHostPort(req.Host) {
+		return fmt.Errorf("%w %q", ErrInvalidHost, req.Host)
+	}
+
 	fixPragmaCacheControl(req.Header)
 
 	req.Close = shouldClose(req.ProtoMajor, req.ProtoMinor, req.Header, false)
 
-	err = readTransfer(req, b)
+	recordFraming(req, padded)
+	err = readTransfer(req, b, srv.transferOptions())
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if isH2UpgradeRequest(req) {
@@ -162,9 +307,70 @@ func readRequest(b *bufio.Reader) (req *http.Request, err error) {
 		// hijacked. Set Close to ensure that:
 		req.Close = true
 	}
-	return req, nil
+	return nil
 }
 
+// validHostPort reports whether v is a valid RFC 3986 host with an
+// optional port: a registered name or IPv4 address, or an IP literal
+// in brackets, followed by an optional colon and digits.
+func validHostPort(v string) bool {
+	host, port := v, ""
+	if strings.HasPrefix(v, "[") {
+		i := strings.IndexByte(v, ']')
+		if i < 0 {
+			return false
+		}
+		host, port = v[:i+1], v[i+1:]
+		if port != "" && port[0] != ':' {
+			return false
+		}
+		if _, err := netip.ParseAddr(host[1 : len(host)-1]); err != nil {
+			return false
+		}
+	} else {
+		if i := strings.LastIndexByte(v, ':'); i >= 0 {
+			host, port = v[:i], v[i:]
+		}
+		if host == "" || !validRegName(host) {
+			return false
+		}
+	}
+	for _, c := range strings.TrimPrefix(port, ":") {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validRegName reports whether v is an RFC 3986 reg-name, which also
+// covers IPv4 addresses:
+//
+//	reg-name = *( unreserved / pct-encoded / sub-delims )
+func validRegName(v string) bool {
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("-._~!$&'()*+,;=", c) >= 0:
+		case c == '%':
+			if i+2 >= len(v) || !ishex(v[i+1]) || !ishex(v[i+2]) {
+				return false
+			}
+			i += 2
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func ishex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// parseRequestLine parses "GET /foo HTTP/1.1" into its three parts.
+// Everything after the second space, including further spaces, is the
+// protocol.
 func parseRequestLine(line string) (method, requestURI, proto string, ok bool) {
 	method, rest, ok1 := strings.Cut(line, " ")
 	requestURI, proto, ok2 := strings.Cut(rest, " ")
@@ -175,21 +381,21 @@ func parseRequestLine(line string) (method, requestURI, proto string, ok bool) {
 }
 
 func requestExpectsContinue(req *http.Request) bool {
-	return hasToken(getFromHeader(req.Header, "Expect"), "100-continue")
+	return headerContainsToken(req.Header, "Expect", "100-continue")
 }
 
 func requestWantsHttp10KeepAlive(r *http.Request) bool {
 	if r.ProtoMajor != 1 || r.ProtoMinor != 0 {
 		return false
 	}
-	return hasToken(getFromHeader(r.Header, "Connection"), "keep-alive")
+	return headerContainsToken(r.Header, "Connection", "keep-alive")
 }
 
 func requestWantsClose(req *http.Request) bool {
 	if req.Close {
 		return true
 	}
-	return hasToken(getFromHeader(req.Header, "Connection"), "close")
+	return headerContainsToken(req.Header, "Connection", "close")
 }
 
 func closeRequestBody(req *http.Request) error {
@@ -203,6 +409,27 @@ func isH2UpgradeRequest(req *http.Request) bool {
 	return req.Method == "PRI" && len(req.Header) == 0 && req.URL.Path == "*" && req.Proto == "HTTP/2.0"
 }
 
+// PeekHTTP2Preface reports whether b starts with the HTTP/2 client
+// connection preface, as sent by clients using HTTP/2 with prior
+// knowledge. It only peeks at b, so no bytes are consumed either way.
+//
+// PeekHTTP2Preface reads only as far as the input matches the
+// preface, so an HTTP/1 request shorter than the preface does not
+// block it. The error is non-nil if b fails, or ends, while the input
+// still matches.
+func PeekHTTP2Preface(b *bufio.Reader) (bool, error) {
+	for n := 1; n <= len(http2ClientPreface); n++ {
+		p, err := b.Peek(n)
+		if err != nil {
+			return false, err
+		}
+		if p[n-1] != http2ClientPreface[n-1] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 func isReplayableRequest(req *http.Request) bool {
 	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
 		switch valueOrDefault(req.Method, "GET") {
@@ -223,6 +450,324 @@ func isReplayableRequest(req *http.Request) bool {
 	return false
 }
 
+// SetSeekableBody sets req's Body to rs and installs a GetBody that
+// seeks rs back to its current offset, so that Transport can resend
+// the body on a new connection. Closing the Body does not close rs.
+//
+// If the size of the remainder of rs can be determined by seeking,
+// ContentLength is set to it. Otherwise, ContentLength is set to -1
+// and the error is returned by GetBody.
+//
+// As for any request with GetBody, a request whose method is not
+// idempotent is only retried if it has an Idempotency-Key or
+// X-Idempotency-Key header.
+func SetSeekableBody(req *http.Request, rs io.ReadSeeker) {
+	size := int64(-1)
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err == nil {
+		var end int64
+		if end, err = rs.Seek(0, io.SeekEnd); err == nil {
+			if _, err = rs.Seek(start, io.SeekStart); err == nil {
+				size = end - start
+			}
+		}
+	}
+	req.ContentLength = size
+	req.Body = io.NopCloser(rs)
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		if err != nil {
+			return nil, err
+		}
+		if _, err := rs.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return http.NoBody, nil
+		}
+		return io.NopCloser(rs), nil
+	}
+}
+
+// ErrBodyNotBuffered is returned by EnsureGetBodyBuffered when a
+// request body is larger than the buffering limit.
+var ErrBodyNotBuffered = errors.New("http: request body too large to buffer for GetBody")
+
+// EnsureGetBody installs a GetBody on req if it has none and its Body
+// wraps a *bytes.Buffer, *bytes.Reader or *strings.Reader, as set by
+// http.NewRequest or io.NopCloser, so that Transport can resend the
+// body on a new connection. The new GetBody returns the body contents
+// that are unread at the time of the call. It is a no-op if req
+// already has a GetBody or no body.
+//
+// An error is returned if the body is of another type; see
+// EnsureGetBodyBuffered.
+func EnsureGetBody(req *http.Request) error {
+	return EnsureGetBodyBuffered(req, 0)
+}
+
+// EnsureGetBodyBuffered is like EnsureGetBody, but bodies of other
+// types are read into memory, up to maxBytes, and replaced by an
+// in-memory copy. If the body is larger than maxBytes, req is left
+// with an equivalent Body and no GetBody, and ErrBodyNotBuffered is
+// returned. An error reading the body is returned as is, and leaves
+// the Body failing with it after the bytes already read.
+func EnsureGetBodyBuffered(req *http.Request, maxBytes int64) error {
+	if req.GetBody != nil || req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	var buf []byte
+	r, ok := unwrapNopCloser(req.Body)
+	switch v := r.(type) {
+	case *bytes.Buffer:
+		buf = v.Bytes()
+	case *bytes.Reader:
+		buf = make([]byte, v.Len())
+		v.ReadAt(buf, v.Size()-int64(v.Len()))
+	case *strings.Reader:
+		buf = make([]byte, v.Len())
+		v.ReadAt(buf, v.Size()-int64(v.Len()))
+	default:
+		ok = false
+	}
+	if !ok {
+		if maxBytes <= 0 {
+			return ErrBodyNotBuffered
+		}
+		body := req.Body
+		var err error
+		buf, err = io.ReadAll(io.LimitReader(body, maxBytes+1))
+		if err != nil || int64(len(buf)) > maxBytes {
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(buf), errReader{err}, body), body}
+			if err == nil {
+				err = ErrBodyNotBuffered
+			}
+			return err
+		}
+		body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(buf))
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	return nil
+}
+
+// errReader returns err from Read, or EOF if err is nil.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) {
+	if r.err == nil {
+		return 0, io.EOF
+	}
+	return 0, r.err
+}
+
+var (
+	nopCloserType         = reflect.TypeOf(io.NopCloser(nil))
+	nopCloserWriterToType = reflect.TypeOf(io.NopCloser(struct {
+		io.Reader
+		io.WriterTo
+	}{}))
+)
+
+// unwrapNopCloser returns the underlying reader and true if r is a
+// NopCloser else it returns false.
+func unwrapNopCloser(r io.Reader) (underlyingReader io.Reader, isNopCloser bool) {
+	switch reflect.TypeOf(r) {
+	case nopCloserType, nopCloserWriterToType:
+		return reflect.ValueOf(r).Field(0).Interface().(io.Reader), true
+	default:
+		return nil, false
+	}
+}
+
+// RequestToBytes returns req as it would be written on the wire by
+// Transport, with the request target in absolute form if usingProxy
+// is set. The body is written from req.GetBody, which EnsureGetBody
+// sets up when needed, so req.Body is left unread and req remains
+// usable. A body that cannot be replayed without buffering it fails
+// with ErrBodyNotBuffered.
+func RequestToBytes(req *http.Request, usingProxy bool) ([]byte, error) {
+	if err := EnsureGetBody(req); err != nil {
+		return nil, err
+	}
+	r := *req
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	var buf bytes.Buffer
+	if err := requestWrite(&r, &buf, usingProxy, nil, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RequestFingerprint returns a stable hash, in hex, of req's method,
+// scheme, host, request target and the headers named in
+// includeHeaders. The host is lowercased, converted to its IDNA ASCII
+// form and stripped of the scheme's default port. Header names are
+// matched case-insensitively and their order in includeHeaders does
+// not matter, but the order of a header's values does. The body is
+// not read.
+func RequestFingerprint(req *http.Request, includeHeaders []string) string {
+	scheme, host, target := normalizedRequestTarget(req)
+
+	keys := make([]string, 0, len(includeHeaders))
+	for _, k := range includeHeaders {
+		keys = append(keys, textproto.CanonicalMIMEHeaderKey(k))
+	}
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+
+	// Each component is length-prefixed so that no two different
+	// requests serialize to the same bytes.
+	h := sha256.New()
+	var n [binary.MaxVarintLen64]byte
+	write := func(s string) {
+		h.Write(binary.AppendUvarint(n[:0], uint64(len(s))))
+		io.WriteString(h, s)
+	}
+	write(valueOrDefault(req.Method, "GET"))
+	write(scheme)
+	write(host)
+	write(target)
+	for _, k := range keys {
+		vv := req.Header[k]
+		write(k)
+		h.Write(binary.AppendUvarint(n[:0], uint64(len(vv))))
+		for _, v := range vv {
+			write(v)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizedRequestTarget returns req's scheme and host, lowercased,
+// with the host in IDNA ASCII form and without the scheme's default
+// port, and its request target.
+func normalizedRequestTarget(req *http.Request) (scheme, host, target string) {
+	host, target = req.Host, req.RequestURI
+	if u := req.URL; u != nil {
+		scheme = strings.ToLower(u.Scheme)
+		if host == "" {
+			host = u.Host
+		}
+		if target == "" {
+			target = u.RequestURI()
+		}
+	}
+	if h, err := idnaASCII(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if port := schemePort(scheme); port != "" {
+		host = strings.TrimSuffix(host, ":"+port)
+	}
+	return scheme, host, target
+}
+
+// RequestsEqual reports whether a and b are semantically the same
+// request: the same method, the same scheme, host and request target,
+// normalized as by RequestFingerprint, and the same header fields,
+// with the values of each field in the same order. Connection-specific
+// and server-set fields, such as RemoteAddr, TLS and the context, are
+// ignored.
+//
+// If both requests have a GetBody, the bodies it returns are compared
+// too; their Body fields are not read. Otherwise bodies are ignored.
+func RequestsEqual(a, b *http.Request) bool {
+	if valueOrDefault(a.Method, "GET") != valueOrDefault(b.Method, "GET") {
+		return false
+	}
+	as, ah, at := normalizedRequestTarget(a)
+	bs, bh, bt := normalizedRequestTarget(b)
+	if as != bs || ah != bh || at != bt {
+		return false
+	}
+	if !maps.EqualFunc(a.Header, b.Header, slices.Equal) {
+		return false
+	}
+	if a.GetBody == nil || b.GetBody == nil {
+		return true
+	}
+	return replayedBodiesEqual(a, b)
+}
+
+func replayedBodiesEqual(a, b *http.Request) bool {
+	ab, err := a.GetBody()
+	if err != nil {
+		return false
+	}
+	defer ab.Close()
+	bb, err := b.GetBody()
+	if err != nil {
+		return false
+	}
+	defer bb.Close()
+	var abuf, bbuf [4 << 10]byte
+	for {
+		an, aerr := io.ReadFull(ab, abuf[:])
+		bn, berr := io.ReadFull(bb, bbuf[:])
+		if !bytes.Equal(abuf[:an], bbuf[:bn]) {
+			return false
+		}
+		aeof := aerr == io.EOF || aerr == io.ErrUnexpectedEOF
+		beof := berr == io.EOF || berr == io.ErrUnexpectedEOF
+		switch {
+		case aeof && beof:
+			return true
+		case aerr != nil && !aeof, berr != nil && !beof, aeof != beof:
+			return false
+		}
+	}
+}
+
+// redactedValue replaces the values hidden by RedactRequest.
+const redactedValue = "[REDACTED]"
+
+// defaultSensitiveHeaders are the header fields RedactRequest hides by
+// default.
+var defaultSensitiveHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// RedactRequest returns a clone of req that is safe to log: the values
+// of the header fields named in sensitive are replaced by a
+// placeholder, cookies are removed and a password in the URL is
+// hidden. If sensitive is nil, the Authorization, Cookie and
+// Proxy-Authorization fields are redacted. req itself is not modified;
+// the clone shares its Body, which should not be read.
+func RedactRequest(req *http.Request, sensitive []string) *http.Request {
+	if sensitive == nil {
+		sensitive = defaultSensitiveHeaders
+	}
+	r := req.Clone(req.Context())
+	for _, k := range sensitive {
+		k = textproto.CanonicalMIMEHeaderKey(k)
+		for i := range r.Header[k] {
+			r.Header[k][i] = redactedValue
+		}
+		for i := range r.Trailer[k] {
+			r.Trailer[k][i] = redactedValue
+		}
+	}
+	r.Header.Del("Cookie")
+	if u := r.URL; u != nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redactedValue)
+		}
+	}
+	return r
+}
+
 func requestOutgoingLength(req *http.Request) int64 {
 	if req.Body == nil || req.Body == http.NoBody {
 		return 0
@@ -234,8 +779,34 @@ func requestOutgoingLength(req *http.Request) int64 {
 }
 
 func requestRequiresHTTP1(req *http.Request) bool {
-	return hasToken(req.Header.Get("Connection"), "upgrade") &&
-		ascii.EqualFold(req.Header.Get("Upgrade"), "websocket")
+	proto, ok := RequestWantsUpgrade(req)
+	return ok && ascii.EqualFold(proto, "websocket")
+}
+
+// RequestWantsUpgrade reports whether req asks to switch protocols,
+// that is, whether its Connection header contains the "upgrade" token
+// and its Upgrade header is non-empty. If so, proto is the first
+// protocol listed in the Upgrade header, such as "websocket" or "h2c".
+func RequestWantsUpgrade(req *http.Request) (proto string, ok bool) {
+	if !httpguts.HeaderValuesContainsToken(req.Header["Connection"], "upgrade") {
+		return "", false
+	}
+	proto, _, _ = strings.Cut(req.Header.Get("Upgrade"), ",")
+	proto = textproto.TrimString(proto)
+	if proto == "" {
+		return "", false
+	}
+	return proto, true
+}
+
+// RequestAcceptsTrailers reports whether the client of req declared,
+// with a "TE: trailers" header, that it accepts trailer fields in
+// the response, as gRPC clients do. The TE header is passed through
+// unchanged when a request is written with HTTP/1, and reduced to
+// "trailers" (or removed) with HTTP/2, where no other value is
+// allowed.
+func RequestAcceptsTrailers(req *http.Request) bool {
+	return httpguts.HeaderValuesContainsToken(req.Header["Te"], "trailers")
 }
 
 func checkRequestBodyError(err error) (error, bool) {
@@ -7,12 +7,227 @@
 package http
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"golang.org/x/net/http/httpguts"
 )
 
+// ReadResponse reads and returns an HTTP response from r.
+// The req parameter optionally specifies the Request that corresponds
+// to this Response. If nil, a GET request is assumed. For a HEAD
+// request, resp.Body is http.NoBody whatever the header says, and
+// resp.ContentLength holds the announced Content-Length, or -1.
+// Responses with status 1xx, 204 or 304 have no body either.
+// Clients must call resp.Body.Close when finished reading resp.Body.
+// After that call, clients can inspect resp.Trailer to find key/value
+// pairs included in the response trailer.
+//
+// ReadResponse returns as soon as the header has been parsed; it does
+// not read any of the body. resp.Body reads from r on demand, so the
+// only body bytes held in memory are those r happened to buffer while
+// the header was read. A fixed-length body is read through a reader
+// limited to Content-Length. A chunked body is decoded incrementally,
+// holding at most one chunk-size line at a time, and the trailer is
+// only read once the body reaches EOF. Without either, the body is
+// read until r returns EOF.
+func ReadResponse(r *bufio.Reader, req *http.Request) (*http.Response, error) {
+	return readResponse(r, req, responseOptions{})
+}
+
+// A ResponseReader reads the responses to requests that were
+// pipelined on a single HTTP/1.1 connection, in order.
+//
+// A response body reads from the same connection as the responses
+// after it, so ResponseReader drains and closes the body of each
+// response before reading the next one. Callers that need a body must
+// therefore read it before asking for the next response.
+type ResponseReader struct {
+	r    *bufio.Reader
+	prev *http.Response
+}
+
+// NewResponseReader returns a ResponseReader reading responses from r.
+func NewResponseReader(r *bufio.Reader) *ResponseReader {
+	return &ResponseReader{r: r}
+}
+
+// ReadResponse reads the response to req, which must be the next
+// request, in sending order, that has not been answered yet. As with
+// ReadResponse, req may be nil for a GET request. Interim 1xx
+// responses are returned like any other response.
+//
+// After a response that closes the connection or switches protocols,
+// ReadResponse returns io.EOF.
+func (rr *ResponseReader) ReadResponse(req *http.Request) (*http.Response, error) {
+	if prev := rr.prev; prev != nil {
+		if prev.Close || isProtocolSwitchResp(prev) {
+			return nil, io.EOF
+		}
+		_, err := io.Copy(io.Discard, prev.Body)
+		prev.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		rr.prev = nil
+	}
+	res, err := ReadResponse(rr.r, req)
+	if err != nil {
+		return nil, err
+	}
+	rr.prev = res
+	return res, nil
+}
+
+// ErrStatusLineTooLong is returned when a response status line is
+// longer than Transport.MaxStatusLineBytes.
+var ErrStatusLineTooLong = errors.New("http: response status line too long")
+
+// An InvalidStatusCodeError is returned by Transport, when its
+// StrictStatusCodes is set, for a response whose status code is not a
+// three-digit number from 100 to 599.
+type InvalidStatusCodeError struct {
+	Code string
+}
+
+func (e *InvalidStatusCodeError) Error() string {
+	return fmt.Sprintf("http: invalid response status code %q", e.Code)
+}
+
+// ErrNoContentBody is returned by Transport, when its
+// StrictBodylessResponses is set, for a 204 No Content response whose
+// header announces a body.
+var ErrNoContentBody = errors.New("http: 204 No Content response announces a body")
+
+// responseOptions holds the Transport settings for reading a response.
+type responseOptions struct {
+	maxStatusLine  int  // if positive, limit on the status line length
+	strictStatus   bool // reject status codes outside 100-599
+	strictBodyless bool // reject 204 responses announcing a body
+}
+
+func readResponse(r *bufio.Reader, req *http.Request, opts responseOptions) (*http.Response, error) {
+	tp := newTextprotoReader(r)
+	defer putTextprotoReader(tp)
+	resp := &http.Response{
+		Request: req,
+	}
+
+	// Parse the first line of the response.
+	var line string
+	var err error
+	if opts.maxStatusLine > 0 {
+		line, err = readLimitedLine(r, opts.maxStatusLine)
+	} else {
+		line, err = tp.ReadLine()
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	proto, status, ok := strings.Cut(line, " ")
+	if !ok {
+		return nil, badStringError("malformed HTTP response", line)
+	}
+	resp.Proto = proto
+	resp.Status = strings.TrimLeft(status, " ")
+
+	statusCode, _, _ := strings.Cut(resp.Status, " ")
+	if opts.strictStatus {
+		code, err := strconv.Atoi(statusCode)
+		if len(statusCode) != 3 || err != nil || code < 100 || code > 599 {
+			return nil, &InvalidStatusCodeError{Code: statusCode}
+		}
+	}
+	if len(statusCode) != 3 {
+		return nil, badStringError("malformed HTTP status code", statusCode)
+	}
+	resp.StatusCode, err = strconv.Atoi(statusCode)
+	if err != nil || resp.StatusCode < 0 {
+		return nil, badStringError("malformed HTTP status code", statusCode)
+	}
+	if resp.ProtoMajor, resp.ProtoMinor, ok = http.ParseHTTPVersion(resp.Proto); !ok {
+		return nil, badStringError("malformed HTTP version", resp.Proto)
+	}
+
+	// Parse the response headers.
+	mimeHeader, err := tp.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	resp.Header = http.Header(mimeHeader)
+
+	fixPragmaCacheControl(resp.Header)
+
+	if opts.strictBodyless && resp.StatusCode == http.StatusNoContent && announcesBody(resp.Header) {
+		return nil, ErrNoContentBody
+	}
+
+	err = readTransfer(resp, r, transferOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// announcesBody reports whether h has a Transfer-Encoding or a
+// Content-Length other than 0.
+func announcesBody(h http.Header) bool {
+	if _, ok := h["Transfer-Encoding"]; ok {
+		return true
+	}
+	for _, v := range h["Content-Length"] {
+		if strings.TrimSpace(v) != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// readLimitedLine reads a line from r like textproto.Reader.ReadLine,
+// but returns ErrStatusLineTooLong once the line is longer than max
+// bytes, not counting its line ending, whatever the size of the
+// buffer of r.
+func readLimitedLine(r *bufio.Reader, max int) (string, error) {
+	var long []byte // the line so far, when it exceeds the buffer
+	for {
+		frag, err := r.ReadSlice('\n')
+		if len(long)+len(frag) > max+2 {
+			return "", ErrStatusLineTooLong
+		}
+		if err == bufio.ErrBufferFull {
+			long = append(long, frag...)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		line := frag
+		if long != nil {
+			line = append(long, frag...)
+		}
+		line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+		if len(line) > max {
+			return "", ErrStatusLineTooLong
+		}
+		return string(line), nil
+	}
+}
+
 func fixPragmaCacheControl(header http.Header) {
 	if hp, ok := header["Pragma"]; ok && len(hp) > 0 && hp[0] == "no-cache" {
 		if _, presentcc := header["Cache-Control"]; !presentcc {
@@ -21,6 +236,97 @@ func fixPragmaCacheControl(header http.Header) {
 	}
 }
 
+// ResponseTLSState returns a copy of the TLS connection state of the
+// connection res was received on, for both HTTP/1 and HTTP/2 responses
+// returned by [Transport]. The state is recorded on every response,
+// including those on reused connections. ok is false if res was not
+// received over TLS.
+func ResponseTLSState(res *http.Response) (state tls.ConnectionState, ok bool) {
+	if res == nil || res.TLS == nil {
+		return tls.ConnectionState{}, false
+	}
+	return *res.TLS, true
+}
+
+// ParseRetryAfter returns how long to wait before retrying, according
+// to the Retry-After header of res: either a number of seconds or an
+// HTTP-date, which is taken relative to now. A date in the past yields
+// zero. ok is false if the header is absent or malformed.
+func ParseRetryAfter(res *http.Response, now time.Time) (d time.Duration, ok bool) {
+	v := strings.TrimSpace(res.Header.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseUint(v, 10, 63); err == nil {
+		if secs > uint64(maxInt64/int64(time.Second)) {
+			return time.Duration(maxInt64), true
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(now), 0), true
+}
+
+// WriteResponse writes res to w in HTTP/1.x wire format, like
+// http.Response.Write: status line, header, then body, framed with a
+// Content-Length or chunked, with any Trailer after a chunked body. res
+// is not modified, and its Body, if any, is closed.
+//
+// Unlike http.Response.Write, WriteResponse sends a zero protocol
+// version as HTTP/1.1, and on HTTP/1.1 chunks a body of unknown length
+// instead of delimiting it by closing the connection, unless res.Close
+// is set. It fails without writing anything if res declares an invalid
+// Trailer.
+func WriteResponse(w io.Writer, res *http.Response) error {
+	r1 := *res
+	if r1.ProtoMajor == 0 && r1.ProtoMinor == 0 {
+		r1.Proto, r1.ProtoMajor, r1.ProtoMinor = "HTTP/1.1", 1, 1
+	}
+	hasBody := r1.Body != nil && r1.Body != http.NoBody && bodyAllowedForStatus(r1.StatusCode) &&
+		(r1.Request == nil || !noResponseBodyExpected(r1.Request.Method))
+	if hasBody && r1.ContentLength < 0 && len(r1.TransferEncoding) == 0 && !r1.Close && r1.ProtoAtLeast(1, 1) {
+		r1.TransferEncoding = []string{"chunked"}
+	}
+	chunked := len(r1.TransferEncoding) > 0 && r1.TransferEncoding[0] == "chunked"
+	if _, err := fixTrailer(r1.Header, chunked); err != nil {
+		if r1.Body != nil {
+			r1.Body.Close()
+		}
+		return err
+	}
+	return r1.Write(w)
+}
+
+// ResponseKeepAlive reports whether the connection res was received on
+// can be reused for another request once the body of res is read to
+// the end. It cannot if res.Close is set or res has "Connection:
+// close", if res is HTTP/1.0 without "Connection: keep-alive", if res
+// switches protocols, or if the end of its body is only marked by the
+// server closing the connection: a body that has neither a known
+// Content-Length nor chunked framing. HTTP/2 and later responses never
+// prevent reuse this way.
+func ResponseKeepAlive(res *http.Response) bool {
+	if res.ProtoMajor >= 2 {
+		return true
+	}
+	if res.Close || httpguts.HeaderValuesContainsToken(res.Header["Connection"], "close") {
+		return false
+	}
+	if !res.ProtoAtLeast(1, 1) && !httpguts.HeaderValuesContainsToken(res.Header["Connection"], "keep-alive") {
+		return false
+	}
+	if isProtocolSwitchResp(res) {
+		return false
+	}
+	noBody := !bodyAllowedForStatus(res.StatusCode) ||
+		res.Request != nil && noResponseBodyExpected(res.Request.Method)
+	chunked := IsChunked(http.Header{"Transfer-Encoding": res.TransferEncoding})
+	return noBody || chunked || res.ContentLength >= 0
+}
+
 func isResponseBodyWritable(res *http.Response) bool {
 	_, ok := res.Body.(io.Writer)
 	return ok
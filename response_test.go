@@ -0,0 +1,468 @@
+package http
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseTLSState(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	cert, pool := newTestCert(t)
+	_, tlsURL := newTLSTestServer(t, h, cert)
+	_, plainURL := newTestServer(t, h)
+	tr := &Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	defer tr.CloseIdleConnections()
+
+	for i := range 2 { // the second request reuses the connection
+		res, err := tr.RoundTrip(mustNewRequest(t, "GET", tlsURL, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		state, ok := ResponseTLSState(res)
+		if !ok || !state.HandshakeComplete || len(state.PeerCertificates) == 0 {
+			t.Errorf("request %d: ResponseTLSState = %v, %v; want a completed handshake", i, state.HandshakeComplete, ok)
+		}
+	}
+
+	res, err := tr.RoundTrip(mustNewRequest(t, "GET", plainURL, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if _, ok := ResponseTLSState(res); ok {
+		t.Error("ResponseTLSState reported TLS for a plain HTTP response")
+	}
+}
+
+func TestReadResponseDoesNotReadBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		body   []string
+		want   string
+	}{
+		{
+			name:   "content-length",
+			header: "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n",
+			body:   []string{"hello", "world"},
+			want:   "helloworld",
+		},
+		{
+			name:   "chunked",
+			header: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n",
+			body:   []string{"5\r\nhello\r\n", "5\r\nworld\r\n0\r\nX-T: 1\r\n\r\n"},
+			want:   "helloworld",
+		},
+		{
+			name:   "until eof",
+			header: "HTTP/1.0 200 OK\r\n\r\n",
+			body:   []string{"hello", "world"},
+			want:   "helloworld",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The body is only written once ReadResponse returns, so
+			// ReadResponse must not wait for any of it.
+			pr, pw := io.Pipe()
+			headerRead := make(chan struct{})
+			go func() {
+				io.WriteString(pw, tt.header)
+				<-headerRead
+				for _, b := range tt.body {
+					io.WriteString(pw, b)
+				}
+				pw.Close()
+			}()
+			res, err := ReadResponse(bufio.NewReaderSize(pr, 16), nil)
+			close(headerRead)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.want {
+				t.Errorf("body = %q; want %q", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadResponseMaxStatusLine(t *testing.T) {
+	tests := []struct {
+		line string
+		max  int
+		ok   bool
+	}{
+		{"HTTP/1.1 200 OK", 15, true},
+		{"HTTP/1.1 200 OK", 14, false},
+		{"HTTP/1.1 200 OK", 0, true},
+		{"HTTP/1.1 200 " + strings.Repeat("x", 100), 50, false},
+	}
+	for _, tt := range tests {
+		in := tt.line + "\r\nContent-Length: 0\r\n\r\n"
+		_, err := readResponse(bufio.NewReader(strings.NewReader(in)), nil, responseOptions{maxStatusLine: tt.max})
+		if tt.ok && err != nil || !tt.ok && err != ErrStatusLineTooLong {
+			t.Errorf("status line %q, max %d: error = %v; want ok = %v", tt.line, tt.max, err, tt.ok)
+		}
+	}
+}
+
+func TestReadResponseMaxStatusLineAboveBuffer(t *testing.T) {
+	const bufSize = 16 // the smallest bufio.Reader buffer
+	tests := []struct {
+		line string
+		max  int
+		ok   bool
+	}{
+		{"HTTP/1.1 200 " + strings.Repeat("x", 40), 53, true},
+		{"HTTP/1.1 200 " + strings.Repeat("x", 40), 52, false},
+		{"HTTP/1.1 200 " + strings.Repeat("x", 100), 64, false},
+	}
+	for _, tt := range tests {
+		for _, eol := range []string{"\r\n", "\n"} {
+			in := tt.line + eol + "Content-Length: 0\r\n\r\n"
+			res, err := readResponse(bufio.NewReaderSize(strings.NewReader(in), bufSize), nil, responseOptions{maxStatusLine: tt.max})
+			if tt.ok && err != nil || !tt.ok && err != ErrStatusLineTooLong {
+				t.Errorf("status line of %d bytes, max %d, buffer %d: error = %v; want ok = %v", len(tt.line), tt.max, bufSize, err, tt.ok)
+			}
+			if tt.ok && err == nil && res.Status != tt.line[len("HTTP/1.1 "):] {
+				t.Errorf("Status = %q; want %q", res.Status, tt.line[len("HTTP/1.1 "):])
+			}
+		}
+	}
+}
+
+func TestTransportMaxStatusLineBytes(t *testing.T) {
+	addr := newRawServer(t, func(c net.Conn) {
+		if _, err := readRawRequest(bufio.NewReader(c)); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 "+strings.Repeat("x", 100)+"\r\nContent-Length: 0\r\n\r\n")
+	})
+	tr := &Transport{MaxStatusLineBytes: 64}
+	defer tr.CloseIdleConnections()
+	_, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://"+addr+"/", nil))
+	if !errors.Is(err, ErrStatusLineTooLong) {
+		t.Errorf("RoundTrip error = %v; want ErrStatusLineTooLong", err)
+	}
+}
+
+func TestTransportMaxStatusLineBytesAboveBuffer(t *testing.T) {
+	const bufSize = 4 << 10
+	for _, n := range []int{6 << 10, 10 << 10} {
+		addr := newRawServer(t, func(c net.Conn) {
+			if _, err := readRawRequest(bufio.NewReader(c)); err != nil {
+				return
+			}
+			io.WriteString(c, "HTTP/1.1 200 "+strings.Repeat("x", n)+"\r\nContent-Length: 0\r\n\r\n")
+		})
+		tr := &Transport{ReadBufferSize: bufSize, MaxStatusLineBytes: 8 << 10}
+		res, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://"+addr+"/", nil))
+		if n < tr.MaxStatusLineBytes {
+			if err != nil {
+				t.Errorf("status line of %d bytes: RoundTrip = %v", n, err)
+			} else {
+				res.Body.Close()
+			}
+		} else if !errors.Is(err, ErrStatusLineTooLong) {
+			t.Errorf("status line of %d bytes: RoundTrip error = %v; want ErrStatusLineTooLong", n, err)
+		}
+		tr.CloseIdleConnections()
+	}
+}
+
+func TestReadResponseStrictStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		strict bool
+		ok     bool
+	}{
+		{"200 OK", true, true},
+		{"599", true, true},
+		{"100 Continue", true, true},
+		{"099 Low", true, false},
+		{"600 High", true, false},
+		{"600 High", false, true},
+		{"+20 Sign", true, false},
+	}
+	for _, tt := range tests {
+		in := "HTTP/1.1 " + tt.status + "\r\nContent-Length: 0\r\n\r\n"
+		_, err := readResponse(bufio.NewReader(strings.NewReader(in)), nil, responseOptions{strictStatus: tt.strict})
+		var se *InvalidStatusCodeError
+		if tt.ok && err != nil || !tt.ok && !errors.As(err, &se) {
+			t.Errorf("status %q, strict %v: error = %v; want ok = %v", tt.status, tt.strict, err, tt.ok)
+		}
+	}
+}
+
+func TestTransportStrictStatusCodes(t *testing.T) {
+	addr := newRawServer(t, func(c net.Conn) {
+		br := bufio.NewReader(c)
+		for {
+			if _, err := readRawRequest(br); err != nil {
+				return
+			}
+			io.WriteString(c, "HTTP/1.1 999 Odd\r\nContent-Length: 0\r\n\r\n")
+		}
+	})
+	for _, strict := range []bool{false, true} {
+		tr := &Transport{StrictStatusCodes: strict}
+		res, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://"+addr+"/", nil))
+		var se *InvalidStatusCodeError
+		if strict {
+			if !errors.As(err, &se) || se.Code != "999" {
+				t.Errorf("strict: RoundTrip error = %v; want InvalidStatusCodeError for \"999\"", err)
+			}
+		} else if err != nil || res.StatusCode != 999 {
+			t.Errorf("lenient: RoundTrip = %v, %v; want status 999", res, err)
+		}
+		tr.CloseIdleConnections()
+	}
+}
+
+func TestResponseReader(t *testing.T) {
+	const in = "HTTP/1.1 100 Continue\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfirst" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\nsecond" +
+		"HTTP/1.1 204 No Content\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 4\r\n\r\nlast"
+	rr := NewResponseReader(bufio.NewReader(strings.NewReader(in)))
+	wants := []struct {
+		code int
+		body string
+		read bool // whether to read the body before the next response
+	}{
+		{100, "", true},
+		{200, "first", false}, // left unread, drained by ReadResponse
+		{200, "second", false},
+		{204, "", true},
+		{200, "last", true},
+	}
+	for i, want := range wants {
+		res, err := rr.ReadResponse(nil)
+		if err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+		if res.StatusCode != want.code {
+			t.Errorf("response %d: status = %d; want %d", i, res.StatusCode, want.code)
+		}
+		if want.read {
+			if b, _ := io.ReadAll(res.Body); string(b) != want.body {
+				t.Errorf("response %d: body = %q; want %q", i, b, want.body)
+			}
+		}
+	}
+	if _, err := rr.ReadResponse(nil); err != io.EOF {
+		t.Errorf("ReadResponse after Connection: close = %v; want io.EOF", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	tests := []struct {
+		v    string
+		want time.Duration
+		ok   bool
+	}{
+		{"", 0, false},
+		{"120", 120 * time.Second, true},
+		{" 0 ", 0, true},
+		{"99999999999999999", time.Duration(maxInt64), true},
+		{"Wed, 21 Oct 2015 07:30:00 GMT", 2 * time.Minute, true},
+		{"Wed, 21 Oct 2015 07:00:00 GMT", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		res := &http.Response{Header: http.Header{}}
+		if tt.v != "" {
+			res.Header.Set("Retry-After", tt.v)
+		}
+		d, ok := ParseRetryAfter(res, now)
+		if d != tt.want || ok != tt.ok {
+			t.Errorf("ParseRetryAfter(%q) = %v, %v; want %v, %v", tt.v, d, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestReadResponseHEAD(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		wantCL int64
+	}{
+		{"content length", "HTTP/1.1 200 OK\r\nContent-Length: 1234\r\n\r\n", 1234},
+		{"chunked", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n", -1},
+		{"unbounded", "HTTP/1.0 200 OK\r\n\r\n", -1},
+	}
+	for _, tt := range tests {
+		// Whatever follows the header is the next response.
+		br := bufio.NewReader(strings.NewReader(tt.in + "HTTP/1.1 204 No Content\r\n\r\n"))
+		res, err := ReadResponse(br, &http.Request{Method: "HEAD"})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if res.Body != http.NoBody || res.ContentLength != tt.wantCL {
+			t.Errorf("%s: Body, ContentLength = %T, %d; want NoBody, %d", tt.name, res.Body, res.ContentLength, tt.wantCL)
+		}
+		next, err := ReadResponse(br, nil)
+		if err != nil || next.StatusCode != 204 {
+			t.Errorf("%s: next response = %v, %v; want the 204", tt.name, next, err)
+		}
+	}
+}
+
+func TestReadResponseBodyless(t *testing.T) {
+	for _, status := range []string{"204 No Content", "304 Not Modified"} {
+		for _, hdr := range []string{"Content-Length: 10\r\n", "Transfer-Encoding: chunked\r\n", ""} {
+			in := "HTTP/1.1 " + status + "\r\n" + hdr + "\r\n"
+			br := bufio.NewReader(strings.NewReader(in + "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"))
+			res, err := ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("%q: %v", in, err)
+			}
+			if res.Body != http.NoBody {
+				t.Errorf("%q: Body = %T; want NoBody", in, res.Body)
+			}
+			if next, err := ReadResponse(br, nil); err != nil || next.StatusCode != 200 {
+				t.Errorf("%q: next response = %v, %v; want the 200", in, next, err)
+			}
+		}
+	}
+}
+
+func TestTransportStrictBodylessResponses(t *testing.T) {
+	for _, tt := range []struct {
+		in     string
+		strict bool
+		ok     bool
+	}{
+		{"HTTP/1.1 204 No Content\r\nContent-Length: 5\r\n\r\n", false, true},
+		{"HTTP/1.1 204 No Content\r\nContent-Length: 5\r\n\r\n", true, false},
+		{"HTTP/1.1 204 No Content\r\nTransfer-Encoding: chunked\r\n\r\n", true, false},
+		{"HTTP/1.1 204 No Content\r\nContent-Length: 0\r\n\r\n", true, true},
+		{"HTTP/1.1 304 Not Modified\r\nContent-Length: 5\r\n\r\n", true, true},
+	} {
+		addr := newRawServer(t, func(c net.Conn) {
+			if _, err := readRawRequest(bufio.NewReader(c)); err != nil {
+				return
+			}
+			io.WriteString(c, tt.in)
+		})
+		tr := &Transport{StrictBodylessResponses: tt.strict}
+		res, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://"+addr+"/", nil))
+		if tt.ok {
+			if err != nil {
+				t.Errorf("%q (strict %v): %v", tt.in, tt.strict, err)
+				continue
+			}
+			res.Body.Close()
+		} else if !errors.Is(err, ErrNoContentBody) {
+			t.Errorf("%q (strict %v): error = %v; want ErrNoContentBody", tt.in, tt.strict, err)
+		}
+		tr.CloseIdleConnections()
+	}
+}
+
+func TestWriteResponse(t *testing.T) {
+	body := func(s string) io.ReadCloser { return io.NopCloser(strings.NewReader(s)) }
+	tests := []struct {
+		name string
+		res  *http.Response
+		want string
+	}{
+		{
+			name: "content length",
+			res:  &http.Response{StatusCode: 200, ContentLength: 2, Body: body("ok")},
+			want: "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok",
+		},
+		{
+			name: "unknown length chunked",
+			res:  &http.Response{StatusCode: 200, ContentLength: -1, Body: body("ok")},
+			want: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\n\r\n",
+		},
+		{
+			name: "unknown length with close",
+			res:  &http.Response{StatusCode: 200, ContentLength: -1, Close: true, Body: body("ok")},
+			want: "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nok",
+		},
+		{
+			name: "HTTP/1.0",
+			res:  &http.Response{StatusCode: 200, Proto: "HTTP/1.0", ProtoMajor: 1, ContentLength: -1, Body: body("ok")},
+			want: "HTTP/1.0 200 OK\r\n\r\nok",
+		},
+		{
+			name: "trailer",
+			res: &http.Response{
+				StatusCode:    200,
+				ContentLength: -1,
+				Header:        http.Header{"Trailer": {"X-Sum"}},
+				Trailer:       http.Header{"X-Sum": {"1"}},
+				Body:          body("ok"),
+			},
+			want: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n2\r\nok\r\n0\r\nX-Sum: 1\r\n\r\n",
+		},
+		{
+			name: "HEAD",
+			res:  &http.Response{StatusCode: 200, ContentLength: -1, Body: body("ok"), Request: &http.Request{Method: "HEAD"}},
+			want: "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\n",
+		},
+	}
+	for _, tt := range tests {
+		orig := *tt.res
+		var b strings.Builder
+		if err := WriteResponse(&b, tt.res); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("%s: wrote\n%q\nwant\n%q", tt.name, b.String(), tt.want)
+		}
+		if tt.res.Proto != orig.Proto || tt.res.ProtoMajor != orig.ProtoMajor || len(tt.res.TransferEncoding) != len(orig.TransferEncoding) {
+			t.Errorf("%s: response modified", tt.name)
+		}
+	}
+
+	var b strings.Builder
+	res := &http.Response{StatusCode: 200, ContentLength: -1, Header: http.Header{"Trailer": {"Content-Length"}}, Body: body("ok")}
+	if err := WriteResponse(&b, res); err == nil || b.Len() != 0 {
+		t.Errorf("invalid Trailer: error = %v, wrote %q; want an error and nothing written", err, b.String())
+	}
+}
+
+func TestResponseKeepAlive(t *testing.T) {
+	head := &http.Request{Method: "HEAD"}
+	tests := []struct {
+		name string
+		res  *http.Response
+		want bool
+	}{
+		{"content length", &http.Response{StatusCode: 200, ProtoMajor: 1, ProtoMinor: 1, ContentLength: 5}, true},
+		{"chunked", &http.Response{StatusCode: 200, ProtoMajor: 1, ProtoMinor: 1, ContentLength: -1, TransferEncoding: []string{"chunked"}}, true},
+		{"read to close", &http.Response{StatusCode: 200, ProtoMajor: 1, ProtoMinor: 1, ContentLength: -1}, false},
+		{"read to close HEAD", &http.Response{StatusCode: 200, ProtoMajor: 1, ProtoMinor: 1, ContentLength: -1, Request: head}, true},
+		{"read to close 204", &http.Response{StatusCode: 204, ProtoMajor: 1, ProtoMinor: 1, ContentLength: -1}, true},
+		{"Close", &http.Response{StatusCode: 200, ProtoMajor: 1, ProtoMinor: 1, Close: true}, false},
+		{"Connection close", &http.Response{StatusCode: 200, ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{"Connection": {"foo, Close"}}}, false},
+		{"HTTP/1.0", &http.Response{StatusCode: 200, ProtoMajor: 1, ProtoMinor: 0}, false},
+		{"HTTP/1.0 keep-alive", &http.Response{StatusCode: 200, ProtoMajor: 1, ProtoMinor: 0, Header: http.Header{"Connection": {"keep-alive"}}}, true},
+		{"protocol switch", &http.Response{StatusCode: 101, ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{"Connection": {"Upgrade"}, "Upgrade": {"websocket"}}}, false},
+		{"HTTP/2", &http.Response{StatusCode: 200, ProtoMajor: 2, ContentLength: -1, Close: true}, true},
+	}
+	for _, tt := range tests {
+		if got := ResponseKeepAlive(tt.res); got != tt.want {
+			t.Errorf("%s: ResponseKeepAlive = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -19,5 +19,11 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	if t == nil {
 		panic("transport is nil")
 	}
+	if t.Authenticator != nil {
+		return t.roundTripAuthenticated(req)
+	}
+	if t.AutoRetryThrottled > 0 {
+		return t.roundTripRetryThrottled(req)
+	}
 	return t.roundTrip(req)
 }
@@ -0,0 +1,186 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+)
+
+// maxServeConnDrain is how much of an unread request body ServeConn
+// discards to keep the connection alive.
+const maxServeConnDrain = 256 << 10
+
+// ServeConn serves HTTP/1 requests on conn until the client closes it,
+// a request or response asks for the connection to be closed, or an
+// error occurs, and then closes conn.
+//
+// For each request it calls handler and writes the response returned,
+// whose Request field it sets. A zero response protocol version is
+// sent as HTTP/1.1. A 100 Continue is sent when the handler first
+// reads the body of a request that expects it. The part of a request
+// body the handler leaves unread is discarded before the next request
+// is read.
+//
+// A malformed request is answered as by WriteParseErrorResponse, and a
+// handler error with 500 Internal Server Error; in both cases the
+// error is returned. A client closing the connection between requests
+// is not an error.
+//
+// A handler may take over the connection with HijackConn, in which
+// case ServeConn returns the handler's error, ignoring its response,
+// and leaves conn open.
+func ServeConn(conn net.Conn, handler func(*http.Request) (*http.Response, error)) error {
+	br := bufio.NewReader(conn)
+	bw := bufio.NewWriter(conn)
+	sc := &serveConn{conn: conn, br: br, bw: bw}
+	defer func() {
+		if !sc.hijacked {
+			conn.Close()
+		}
+	}()
+	for {
+		if _, err := br.Peek(1); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		req, err := readRequest(br, nil)
+		if err != nil {
+			if !isCommonNetReadError(err) {
+				WriteParseErrorResponse(conn, err)
+			}
+			return err
+		}
+		req = req.WithContext(context.WithValue(req.Context(), serveConnContextKey, sc))
+		req.RemoteAddr = conn.RemoteAddr().String()
+		if requestExpectsContinue(req) && req.ProtoAtLeast(1, 1) && req.ContentLength != 0 {
+			req.Body = &continueBody{ReadCloser: req.Body, w: bw}
+		}
+
+		res, err := handler(req)
+		if sc.hijacked {
+			return err
+		}
+		if err == nil && res == nil {
+			err = errors.New("http: ServeConn handler returned a nil response")
+		}
+		if err != nil {
+			const publicErr = "500 Internal Server Error"
+			io.WriteString(conn, "HTTP/1.1 "+publicErr+"\r\nContent-Type: text/plain; charset=utf-8\r\nConnection: close\r\n\r\n"+publicErr)
+			return err
+		}
+
+		keepAlive := serveConnKeepAlive(req, res)
+		res.Request = req
+		if res.ProtoMajor == 0 {
+			res.Proto, res.ProtoMajor, res.ProtoMinor = "HTTP/1.1", 1, 1
+		}
+		if !keepAlive {
+			res.Close = true
+		} else if !req.ProtoAtLeast(1, 1) {
+			if res.Header == nil {
+				res.Header = make(http.Header)
+			}
+			res.Header.Set("Connection", "keep-alive")
+		}
+		err = res.Write(bw)
+		if err == nil {
+			err = bw.Flush()
+		}
+		if err != nil {
+			return err
+		}
+
+		if cb, ok := req.Body.(*continueBody); ok && !cb.sent {
+			// The client is waiting for a 100 Continue that
+			// it will not get; it may or may not send the body.
+			return nil
+		}
+		if req.Body != nil {
+			n, _ := io.CopyN(io.Discard, req.Body, maxServeConnDrain+1)
+			req.Body.Close()
+			if n > maxServeConnDrain {
+				return nil
+			}
+		}
+		if !keepAlive {
+			return nil
+		}
+	}
+}
+
+// serveConnKeepAlive reports whether the connection can be reused after
+// res is sent in reply to req.
+func serveConnKeepAlive(req *http.Request, res *http.Response) bool {
+	if req.Close || res.Close || requestWantsClose(req) {
+		return false
+	}
+	// Without a known length or chunking, the end of the body is
+	// signaled by closing the connection.
+	hasBody := res.Body != nil && res.Body != http.NoBody && req.Method != "HEAD" &&
+		bodyAllowedForStatus(res.StatusCode)
+	chunked := len(res.TransferEncoding) > 0 && res.TransferEncoding[0] == "chunked"
+	if hasBody && res.ContentLength < 0 && (!chunked || !req.ProtoAtLeast(1, 1)) {
+		return false
+	}
+	return req.ProtoAtLeast(1, 1) || requestWantsHttp10KeepAlive(req)
+}
+
+// continueBody sends a 100 Continue to the client on the first read of
+// a request body that expects one.
+type continueBody struct {
+	io.ReadCloser
+	w    *bufio.Writer
+	sent bool
+}
+
+func (b *continueBody) Read(p []byte) (int, error) {
+	if !b.sent {
+		b.sent = true
+		b.w.WriteString("HTTP/1.1 100 Continue\r\n\r\n")
+		if err := b.w.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return b.ReadCloser.Read(p)
+}
+
+var serveConnContextKey = &contextKey{"serve-conn"}
+
+// serveConn is the connection ServeConn serves, as seen by HijackConn.
+type serveConn struct {
+	conn     net.Conn
+	br       *bufio.Reader
+	bw       *bufio.Writer
+	hijacked bool
+}
+
+// HijackConn lets a ServeConn handler take over the connection req was
+// read from, for instance to switch protocols after writing a 101
+// Switching Protocols response to it itself. It returns the connection
+// and the reader ServeConn read req from, which holds any bytes the
+// client sent after the request header that were already buffered,
+// including the unread part of req.Body. ServeConn writes no response
+// to req and no longer reads from or closes the connection, which the
+// caller must close.
+//
+// HijackConn fails with http.ErrHijacked if called again for the same
+// connection, and with an error if req was not read by ServeConn.
+func HijackConn(req *http.Request) (net.Conn, *bufio.Reader, error) {
+	sc, _ := req.Context().Value(serveConnContextKey).(*serveConn)
+	if sc == nil {
+		return nil, nil, errors.New("http: HijackConn called for a request not read by ServeConn")
+	}
+	if sc.hijacked {
+		return nil, nil, http.ErrHijacked
+	}
+	if err := sc.bw.Flush(); err != nil {
+		return nil, nil, err
+	}
+	sc.hijacked = true
+	return sc.conn, sc.br, nil
+}
@@ -0,0 +1,196 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// startServeConn runs ServeConn on one end of a pipe and returns the
+// other end and a channel that receives its result.
+func startServeConn(t *testing.T, handler func(*http.Request) (*http.Response, error)) (net.Conn, <-chan error) {
+	t.Helper()
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() { c1.Close() })
+	done := make(chan error, 1)
+	go func() { done <- ServeConn(c2, handler) }()
+	return c1, done
+}
+
+func textResponse(s string) *http.Response {
+	return &http.Response{
+		StatusCode:    200,
+		Header:        http.Header{"Content-Type": {"text/plain"}},
+		ContentLength: int64(len(s)),
+		Body:          io.NopCloser(strings.NewReader(s)),
+	}
+}
+
+func TestServeConnKeepAlive(t *testing.T) {
+	c, done := startServeConn(t, func(req *http.Request) (*http.Response, error) {
+		return textResponse(req.URL.Path), nil
+	})
+	br := bufio.NewReader(c)
+	for _, path := range []string{"/a", "/b"} {
+		go io.WriteString(c, "GET "+path+" HTTP/1.1\r\nHost: x\r\n\r\n")
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		if res.StatusCode != 200 || res.Proto != "HTTP/1.1" || res.Close || string(body) != path {
+			t.Errorf("response = %d %s (Close %v) %q; want 200 HTTP/1.1 %q", res.StatusCode, res.Proto, res.Close, body, path)
+		}
+	}
+	c.Close()
+	if err := <-done; err != nil {
+		t.Errorf("ServeConn = %v; want nil after the client closes", err)
+	}
+}
+
+func TestServeConnClose(t *testing.T) {
+	tests := []struct {
+		name string
+		req  string
+		res  func() *http.Response
+	}{
+		{"request close", "GET / HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n", func() *http.Response { return textResponse("x") }},
+		{"HTTP/1.0", "GET / HTTP/1.0\r\n\r\n", func() *http.Response { return textResponse("x") }},
+		{"unknown length", "GET / HTTP/1.1\r\nHost: x\r\n\r\n", func() *http.Response {
+			res := textResponse("x")
+			res.ContentLength = -1
+			return res
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, done := startServeConn(t, func(*http.Request) (*http.Response, error) { return tt.res(), nil })
+			go io.WriteString(c, tt.req)
+			res, err := http.ReadResponse(bufio.NewReader(c), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.ReadAll(res.Body)
+			if !res.Close {
+				t.Error("response does not close the connection")
+			}
+			if err := <-done; err != nil {
+				t.Errorf("ServeConn = %v", err)
+			}
+		})
+	}
+}
+
+func TestServeConnErrors(t *testing.T) {
+	errHandler := errors.New("handler failed")
+	c, done := startServeConn(t, func(*http.Request) (*http.Response, error) { return nil, errHandler })
+	go io.WriteString(c, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	res, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 500 {
+		t.Errorf("status = %d; want 500", res.StatusCode)
+	}
+	if err := <-done; err != errHandler {
+		t.Errorf("ServeConn = %v; want %v", err, errHandler)
+	}
+
+	c, done = startServeConn(t, func(*http.Request) (*http.Response, error) {
+		t.Error("handler called for a malformed request")
+		return nil, nil
+	})
+	go io.WriteString(c, "GET / HTTP/1.1\r\nNoColon\r\n\r\n")
+	res, err = http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 400 {
+		t.Errorf("status = %d; want 400", res.StatusCode)
+	}
+	if err := <-done; err == nil {
+		t.Error("ServeConn = nil; want the parse error")
+	}
+}
+
+func TestServeConnExpectContinue(t *testing.T) {
+	c, done := startServeConn(t, func(req *http.Request) (*http.Response, error) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		return textResponse(string(body)), nil
+	})
+	go io.WriteString(c, "POST / HTTP/1.1\r\nHost: x\r\nExpect: 100-continue\r\nContent-Length: 4\r\n\r\n")
+	br := bufio.NewReader(c)
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "HTTP/1.1 100 Continue\r\n" {
+		t.Fatalf("first line = %q; want a 100 Continue", line)
+	}
+	br.ReadString('\n')
+	go io.WriteString(c, "body")
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(res.Body); string(body) != "body" {
+		t.Errorf("body = %q; want %q", body, "body")
+	}
+	c.Close()
+	<-done
+}
+
+func TestHijackConn(t *testing.T) {
+	hijacked := make(chan net.Conn, 1)
+	c, done := startServeConn(t, func(req *http.Request) (*http.Response, error) {
+		conn, br, err := HijackConn(req)
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err := HijackConn(req); err != http.ErrHijacked {
+			t.Errorf("second HijackConn error = %v; want http.ErrHijacked", err)
+		}
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		// The bytes sent right after the request are buffered in br.
+		line, _ := br.ReadString('\n')
+		io.WriteString(conn, "echo: "+line)
+		hijacked <- conn
+		return textResponse("ignored"), nil
+	})
+	go io.WriteString(c, "GET / HTTP/1.1\r\nHost: x\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\nhello\n")
+	br := bufio.NewReader(c)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 101 {
+		t.Fatalf("status = %d; want 101", res.StatusCode)
+	}
+	if line, _ := br.ReadString('\n'); line != "echo: hello\n" {
+		t.Errorf("after hijacking: %q; want %q", line, "echo: hello\n")
+	}
+	if err := <-done; err != nil {
+		t.Errorf("ServeConn = %v", err)
+	}
+	// ServeConn leaves the hijacked connection open.
+	conn := <-hijacked
+	go io.WriteString(conn, "still open\n")
+	if line, _ := br.ReadString('\n'); line != "still open\n" {
+		t.Errorf("read %q from the hijacked connection", line)
+	}
+	conn.Close()
+}
+
+func TestHijackConnNotServeConn(t *testing.T) {
+	req := mustNewRequest(t, "GET", "http://example.com/", nil)
+	if _, _, err := HijackConn(req); err == nil {
+		t.Error("HijackConn of a request not read by ServeConn: no error")
+	}
+}
@@ -28,6 +28,8 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/puernya/go-http/internal/ascii"
+
 	"golang.org/x/net/http/httpguts"
 )
 
@@ -408,16 +410,64 @@ func (w *response) ReadFrom(src io.Reader) (n int64, err error) {
 	return n, err
 }
 
+// Stream implements Streamer. Once the handler has written a 101
+// Switching Protocols header, Stream sends it and takes over the
+// connection, as Hijack does, and returns a Stream over it for the
+// switched-to protocol. Reads from the Stream first return any bytes
+// the client sent after the request that were already buffered. The
+// Stream also has Close, CloseWrite and deadline methods, which
+// forward to the connection.
+//
+// Stream returns nil if no 101 header was written, or if the
+// connection was already hijacked.
 func (w *response) Stream() Stream {
-	return &h1Stream{w.conn.rwc}
+	if !w.wroteHeader || w.status != http.StatusSwitchingProtocols {
+		return nil
+	}
+	rwc, buf, err := w.Hijack()
+	if err != nil {
+		return nil
+	}
+	if err := buf.Flush(); err != nil {
+		rwc.Close()
+		return nil
+	}
+	return &h1Stream{c: rwc, r: buf.Reader}
 }
 
+// h1Stream is the Stream of a server connection taken over after a
+// 101 Switching Protocols response.
 type h1Stream struct {
 	c net.Conn
+	r *bufio.Reader // bytes buffered before the takeover; nil once drained
+}
+
+func (s *h1Stream) Read(p []byte) (int, error) {
+	if s.r != nil {
+		// The reader may not read from the connection itself any
+		// more; only drain what it buffered.
+		if s.r.Buffered() > 0 {
+			return s.r.Read(p)
+		}
+		s.r = nil
+	}
+	return s.c.Read(p)
+}
+
+func (s *h1Stream) Write(p []byte) (int, error) { return s.c.Write(p) }
+
+func (s *h1Stream) Close() error { return s.c.Close() }
+
+func (s *h1Stream) CloseWrite() error {
+	if cw, ok := s.c.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return fmt.Errorf("CloseWrite: %w", http.ErrNotSupported)
 }
 
-func (s *h1Stream) Read(p []byte) (int, error)
-func (s *h1Stream) Write(p []byte) (int, error)
+func (s *h1Stream) SetDeadline(t time.Time) error      { return s.c.SetDeadline(t) }
+func (s *h1Stream) SetReadDeadline(t time.Time) error  { return s.c.SetReadDeadline(t) }
+func (s *h1Stream) SetWriteDeadline(t time.Time) error { return s.c.SetWriteDeadline(t) }
 
 // debugServerConnections controls whether all server connections are wrapped
 // with a verbose logging wrapper.
@@ -453,6 +503,9 @@ type connReader struct {
 	inRead  bool
 	aborted bool  // set true before conn.rwc deadline is set to past
 	remain  int64 // bytes remaining
+
+	maxBytes int64 // if positive, ConnLimits.MaxBytes
+	nread    int64 // bytes read from rwc
 }
 
 func (cr *connReader) lock() {
@@ -487,6 +540,7 @@ func (cr *connReader) startBackgroundRead() {
 func (cr *connReader) backgroundRead() {
 	n, err := cr.rwc.Read(cr.byteBuf[:])
 	cr.lock()
+	cr.nread += int64(n)
 	if n == 1 {
 		cr.hasByte = true
 		// We were past the end of the previous request's body already
@@ -587,6 +641,16 @@ func (cr *connReader) Read(p []byte) (n int, err error) {
 	if int64(len(p)) > cr.remain {
 		p = p[:cr.remain]
 	}
+	if cr.maxBytes > 0 {
+		left := cr.maxBytes - cr.nread
+		if left <= 0 {
+			cr.unlock()
+			return 0, ErrConnByteLimit
+		}
+		if int64(len(p)) > left {
+			p = p[:left]
+		}
+	}
 	if cr.hasByte {
 		p[0] = cr.byteBuf[0]
 		cr.hasByte = false
@@ -603,6 +667,7 @@ func (cr *connReader) Read(p []byte) (n int, err error) {
 		cr.handleReadErrorLocked(err)
 	}
 	cr.remain -= int64(n)
+	cr.nread += int64(n)
 	cr.unlock()
 
 	cr.cond.Broadcast()
@@ -726,16 +791,7 @@ func (ecr *expectContinueReader) Read(p []byte) (n int, err error) {
 	if ecr.closed.Load() {
 		return 0, http.ErrBodyReadAfterClose
 	}
-	w := ecr.resp
-	if w.canWriteContinue.Load() {
-		w.writeContinueMu.Lock()
-		if w.canWriteContinue.Load() {
-			w.conn.bufw.WriteString("HTTP/1.1 100 Continue\r\n\r\n")
-			w.conn.bufw.Flush()
-			w.canWriteContinue.Store(false)
-		}
-		w.writeContinueMu.Unlock()
-	}
+	ecr.resp.writeContinue()
 	n, err = ecr.readCloser.Read(p)
 	if err == io.EOF {
 		ecr.sawEOF.Store(true)
@@ -748,6 +804,50 @@ func (ecr *expectContinueReader) Close() error {
 	return ecr.readCloser.Close()
 }
 
+// errContinueNotAllowed is returned by WriteContinue when a 100
+// Continue can no longer be sent for the request.
+var errContinueNotAllowed = errors.New("http: 100 Continue sent after response header or for request not expecting it")
+
+// writeContinue sends a 100 Continue if one may still be sent.
+// It reports whether it did so.
+func (w *response) writeContinue() bool {
+	if !w.canWriteContinue.Load() {
+		return false
+	}
+	w.writeContinueMu.Lock()
+	defer w.writeContinueMu.Unlock()
+	if !w.canWriteContinue.Load() {
+		return false
+	}
+	w.conn.bufw.WriteString("HTTP/1.1 100 Continue\r\n\r\n")
+	w.conn.bufw.Flush()
+	w.canWriteContinue.Store(false)
+	return true
+}
+
+// WriteContinue sends an "HTTP/1.1 100 Continue" interim response to w.
+//
+// If w is a ResponseWriter from this package, the 100 Continue is only
+// sent if the request carried "Expect: 100-continue" and neither a
+// 100 Continue nor the final response header has been written yet;
+// otherwise an error is returned. Reading the request body sends the
+// 100 Continue automatically, so calling WriteContinue is only needed
+// to send it earlier. Any other writer, such as a hijacked
+// connection, receives the status line unconditionally.
+func WriteContinue(w io.Writer) error {
+	if rw, ok := w.(*response); ok {
+		if rw.conn.hijacked() {
+			return http.ErrHijacked
+		}
+		if !rw.writeContinue() {
+			return errContinueNotAllowed
+		}
+		return nil
+	}
+	_, err := io.WriteString(w, "HTTP/1.1 100 Continue\r\n\r\n")
+	return err
+}
+
 // TimeFormat is the time format to use when generating times in HTTP
 // headers. It is like [time.RFC1123] but hard-codes GMT as the time
 // zone. The time being formatted must be in UTC for Format to
@@ -788,7 +888,7 @@ func (c *conn) readRequest(ctx context.Context) (w *response, err error) {
 		peek, _ := c.bufr.Peek(4) // ReadRequest will get err below
 		c.bufr.Discard(numLeadingCRorLF(peek))
 	}
-	req, err := readRequest(c.bufr)
+	req, err := readRequest(c.bufr, c.server)
 	if err != nil {
 		if c.r.hitReadLimit() {
 			return nil, errTooLarge
@@ -796,10 +896,6 @@ func (c *conn) readRequest(ctx context.Context) (w *response, err error) {
 		return nil, err
 	}
 
-	if !http1ServerSupportsRequest(req) {
-		return nil, statusError{http.StatusHTTPVersionNotSupported, "unsupported protocol version"}
-	}
-
 	c.lastMethod = req.Method
 	c.r.setInfiniteReadLimit()
 
@@ -823,7 +919,7 @@ func (c *conn) readRequest(ctx context.Context) (w *response, err error) {
 	}
 	delete(req.Header, "Host")
 
-	ctx, cancelCtx := context.WithCancel(ctx)
+	ctx, cancelCtx := context.WithCancel(withFraming(ctx, req))
 	req = req.WithContext(ctx)
 	req.RemoteAddr = c.remoteAddr
 	req.TLS = c.tlsState
@@ -853,6 +949,10 @@ func (c *conn) readRequest(ctx context.Context) (w *response, err error) {
 	if isH2Upgrade {
 		w.closeAfterReply = true
 	}
+	if n := c.server.GetBodyBufferSize; n > 0 && !isH2Upgrade && !requestExpectsContinue(req) {
+		// Errors leave req.Body returning them to the handler.
+		EnsureGetBodyBuffered(req, n)
+	}
 	w.cw.res = w
 	w.w = newBufioWriterSize(&w.cw, bufferBeforeChunkingSize)
 	return w, nil
@@ -984,6 +1084,42 @@ func (w *response) WriteHeader(code int) {
 	}
 }
 
+// errEarlyHintsAfterHeader is returned by WriteEarlyHints when the
+// final response header has already been written.
+var errEarlyHintsAfterHeader = errors.New("http: early hints sent after response header was written")
+
+// WriteEarlyHints sends a 103 Early Hints interim response on w
+// carrying the given Link header values (RFC 8297).
+//
+// The links are added to w's header map, so they are also sent with
+// the final response, as RFC 8297 recommends. Values already present
+// in the header map are not added again, so WriteEarlyHints may be
+// called more than once. It does not finalize the response; the
+// handler must still call WriteHeader or Write as usual.
+func WriteEarlyHints(w http.ResponseWriter, links ...string) error {
+	switch rw := w.(type) {
+	case *response:
+		if rw.conn.hijacked() {
+			return http.ErrHijacked
+		}
+		if rw.wroteHeader {
+			return errEarlyHintsAfterHeader
+		}
+	case *http2responseWriter:
+		if rw.rws == nil || rw.rws.wroteHeader {
+			return errEarlyHintsAfterHeader
+		}
+	}
+	h := w.Header()
+	for _, link := range links {
+		if !slices.Contains(h["Link"], link) {
+			h.Add("Link", link)
+		}
+	}
+	w.WriteHeader(http.StatusEarlyHints)
+	return nil
+}
+
 // extraHeader is the set of headers sometimes added by chunkWriter.writeHeader.
 // This type is used to avoid extra allocations from cloning and/or populating
 // the response Header map and all its 1-element slices.
@@ -1652,6 +1788,52 @@ type statusError struct {
 
 func (e statusError) Error() string { return http.StatusText(e.code) + ": " + e.text }
 
+// WriteParseErrorResponse writes to w the HTTP/1.1 response the Server
+// sends when reading a request fails with err: 431 Request Header
+// Fields Too Large for a header over the size limit, 414 URI Too Long
+// for ErrRequestURITooLong, 501 Not Implemented for an unsupported
+// transfer coding, 505 HTTP Version Not Supported for
+// ErrUnsupportedVersion and 400 Bad Request otherwise. The response
+// carries Connection: close; the caller is responsible for closing the
+// connection afterwards.
+func WriteParseErrorResponse(w io.Writer, err error) error {
+	const errorHeaders = "\r\nContent-Type: text/plain; charset=utf-8\r\nConnection: close\r\n\r\n"
+
+	switch {
+	case err == errTooLarge:
+		const publicErr = "431 Request Header Fields Too Large"
+		_, err = io.WriteString(w, "HTTP/1.1 "+publicErr+errorHeaders+publicErr)
+
+	case isUnsupportedTEError(err):
+		// Respond as per RFC 7230 Section 3.3.1 which says,
+		//      A server that receives a request message with a
+		//      transfer coding it does not understand SHOULD
+		//      respond with 501 (Unimplemented).
+		code := http.StatusNotImplemented
+
+		// We purposefully aren't echoing back the transfer-encoding's value,
+		// so as to mitigate the risk of cross side scripting by an attacker.
+		_, err = fmt.Fprintf(w, "HTTP/1.1 %d %s%sUnsupported transfer encoding", code, http.StatusText(code), errorHeaders)
+
+	case errors.Is(err, ErrRequestURITooLong):
+		const publicErr = "414 URI Too Long"
+		_, err = io.WriteString(w, "HTTP/1.1 "+publicErr+errorHeaders+publicErr)
+
+	case errors.Is(err, ErrUnsupportedVersion):
+		const publicErr = "505 HTTP Version Not Supported"
+		_, err = io.WriteString(w, "HTTP/1.1 "+publicErr+errorHeaders+publicErr)
+
+	default:
+		if v, ok := err.(statusError); ok {
+			_, err = fmt.Fprintf(w, "HTTP/1.1 %d %s: %s%s%d %s: %s", v.code, http.StatusText(v.code), v.text, errorHeaders, v.code, http.StatusText(v.code), v.text)
+			break
+		}
+		const publicErr = "400 Bad Request"
+		_, err = io.WriteString(w, "HTTP/1.1 "+publicErr+errorHeaders+publicErr)
+	}
+	return err
+}
+
 // ErrAbortHandler is a sentinel panic value to abort a handler.
 // While any panic from ServeHTTP aborts the response to the client,
 // panicking with ErrAbortHandler also suppresses logging of a stack
@@ -1763,7 +1945,7 @@ func (c *conn) serve(ctx context.Context) {
 	c.cancelCtx = cancelCtx
 	defer cancelCtx()
 
-	c.r = &connReader{conn: c, rwc: c.rwc}
+	c.r = &connReader{conn: c, rwc: c.rwc, maxBytes: c.server.ConnLimits.MaxBytes}
 	c.bufr = newBufioReader(c.r)
 	c.bufw = newBufioWriterSize(checkConnErrorWriter{c}, 4<<10)
 
@@ -1777,6 +1959,7 @@ func (c *conn) serve(ctx context.Context) {
 		return
 	}
 
+	var numRequests int
 	for {
 		w, err := c.readRequest(ctx)
 		if c.r.remain != c.server.initialReadLimitSize() {
@@ -1787,49 +1970,32 @@ func (c *conn) serve(ctx context.Context) {
 			return
 		}
 		if err != nil {
-			const errorHeaders = "\r\nContent-Type: text/plain; charset=utf-8\r\nConnection: close\r\n\r\n"
-
-			switch {
-			case err == errTooLarge:
+			if isCommonNetReadError(err) || errors.Is(err, ErrConnByteLimit) {
+				return // don't reply
+			}
+			WriteParseErrorResponse(c.rwc, err)
+			if err == errTooLarge {
 				// Their HTTP client may or may not be
 				// able to read this if we're
 				// responding to them and hanging up
 				// while they're still writing their
 				// request. Undefined behavior.
-				const publicErr = "431 Request Header Fields Too Large"
-				fmt.Fprintf(c.rwc, "HTTP/1.1 "+publicErr+errorHeaders+publicErr)
 				c.closeWriteAndWait()
-				return
-
-			case isUnsupportedTEError(err):
-				// Respond as per RFC 7230 Section 3.3.1 which says,
-				//      A server that receives a request message with a
-				//      transfer coding it does not understand SHOULD
-				//      respond with 501 (Unimplemented).
-				code := http.StatusNotImplemented
-
-				// We purposefully aren't echoing back the transfer-encoding's value,
-				// so as to mitigate the risk of cross side scripting by an attacker.
-				fmt.Fprintf(c.rwc, "HTTP/1.1 %d %s%sUnsupported transfer encoding", code, http.StatusText(code), errorHeaders)
-				return
-
-			case isCommonNetReadError(err):
-				return // don't reply
-
-			default:
-				if v, ok := err.(statusError); ok {
-					fmt.Fprintf(c.rwc, "HTTP/1.1 %d %s: %s%s%d %s: %s", v.code, http.StatusText(v.code), v.text, errorHeaders, v.code, http.StatusText(v.code), v.text)
-					return
-				}
-				const publicErr = "400 Bad Request"
-				fmt.Fprintf(c.rwc, "HTTP/1.1 "+publicErr+errorHeaders+publicErr)
-				return
 			}
+			return
+		}
+		numRequests++
+		if n := c.server.ConnLimits.MaxRequests; n > 0 && numRequests >= n {
+			w.closeAfterReply = true
 		}
 
 		// Expect 100 Continue support
 		req := w.req
 		if requestExpectsContinue(req) {
+			if fn := c.server.ExpectContinueHandler; fn != nil && !fn(req) {
+				w.sendExpectationFailed()
+				return
+			}
 			if req.ProtoAtLeast(1, 1) && req.ContentLength != 0 {
 				// Wrap the Body reader with one that replies on the connection
 				req.Body = &expectContinueReader{readCloser: req.Body, resp: w}
@@ -1842,8 +2008,10 @@ func (c *conn) serve(ctx context.Context) {
 
 		c.curReq.Store(w)
 
-		if requestBodyRemains(req.Body) {
-			registerOnHitEOF(req.Body, w.conn.r.startBackgroundRead)
+		// w.reqBody, not req.Body: the latter may have been replaced
+		// by an in-memory copy (see Server.GetBodyBufferSize).
+		if requestBodyRemains(w.reqBody) {
+			registerOnHitEOF(w.reqBody, w.conn.r.startBackgroundRead)
 		} else {
 			w.conn.r.startBackgroundRead()
 		}
@@ -2187,6 +2355,77 @@ type Server struct {
 	// prioritization.
 	DisableClientPriority bool
 
+	// ExpectContinueHandler optionally decides whether an HTTP/1
+	// request carrying "Expect: 100-continue" may proceed. It is
+	// called with the request headers before the Handler runs and
+	// before any of the body is read. If it returns false, the server
+	// replies with 417 Expectation Failed and closes the connection
+	// without reading the body or calling the Handler.
+	//
+	// If nil, all such requests proceed and 100 Continue is sent
+	// when the Handler first reads the body.
+	ExpectContinueHandler func(*http.Request) bool
+
+	// LenientTransferEncoding controls how HTTP/1.1 requests with a
+	// Transfer-Encoding other than "chunked" are handled. By default
+	// such requests are rejected with 501 Not Implemented, as RFC 9112
+	// permits; the read error matches ErrUnsupportedTransferEncoding.
+	//
+	// If LenientTransferEncoding is true, the body is instead read as
+	// chunked if chunked is the final transfer coding, and until the
+	// client closes its side of the connection otherwise. Content-Length
+	// is ignored in both cases and the connection is not reused.
+	LenientTransferEncoding bool
+
+	// MaxChunkSize and MaxChunkCount, if positive, limit chunked
+	// request bodies: reading a body fails with ErrChunkTooLarge if a
+	// chunk declares a size larger than MaxChunkSize, and with
+	// ErrTooManyChunks if the body has more than MaxChunkCount chunks.
+	// Zero means no limit.
+	MaxChunkSize  int64
+	MaxChunkCount int
+
+	// AbsoluteFormSchemes lists the URL schemes accepted in
+	// absolute-form request targets, such as those sent to forward
+	// proxies ("GET ftp://example.com/ HTTP/1.1"). Requests with any
+	// other scheme are rejected with 400 Bad Request; the read error
+	// is a *DisallowedSchemeError. Schemes compare case-insensitively.
+	// If nil, only "http" and "https" are accepted.
+	AbsoluteFormSchemes []string
+
+	// GetBodyBufferSize, if positive, makes the server read HTTP/1
+	// request bodies of up to GetBodyBufferSize bytes into memory
+	// before calling the handler, and set Request.GetBody so the
+	// handler can read the body again. Request.Body still returns
+	// the same bytes. Larger bodies are streamed as usual, without
+	// GetBody, as are bodies of requests that expect 100 Continue.
+	GetBodyBufferSize int64
+
+	// MaxRequestURIBytes, if positive, limits the length of the
+	// request target in the request line. Longer targets are
+	// rejected with 414 URI Too Long; the read error wraps
+	// ErrRequestURITooLong. Otherwise the target is only limited by
+	// MaxHeaderBytes.
+	MaxRequestURIBytes int
+
+	// VersionPolicy selects the HTTP versions accepted in HTTP/1
+	// request lines. Requests with other versions are rejected
+	// with 505 HTTP Version Not Supported; the read error wraps
+	// ErrUnsupportedVersion. The zero value, VersionHTTP1Only,
+	// accepts HTTP/1.x requests only.
+	VersionPolicy VersionPolicy
+
+	// ConnLimits bounds the use of each HTTP/1 connection.
+	ConnLimits ConnLimits
+
+	// StrictHost, if true, rejects requests whose host, from the
+	// Host header or an absolute-form target, is not a valid RFC
+	// 3986 host[:port]: a registered name, an IPv4 address or a
+	// bracketed IP literal, with an optional numeric port. The read
+	// error wraps ErrInvalidHost. Otherwise only control characters
+	// and other bytes never valid in a Host header are rejected.
+	StrictHost bool
+
 	inShutdown atomic.Bool // true when server is in shutdown
 
 	disableKeepAlives atomic.Bool
@@ -2345,6 +2584,27 @@ func (s *Server) closeListenersLocked() error {
 	return err
 }
 
+// ErrConnByteLimit is returned when reading from an HTTP/1 connection
+// would cross the Server's ConnLimits.MaxBytes. A handler sees it when
+// reading a request body; a request line or header that crosses the
+// limit makes the server close the connection without a response.
+var ErrConnByteLimit = errors.New("http: connection byte limit exceeded")
+
+// ConnLimits bounds the use of a single HTTP/1 connection by a Server,
+// to keep clients from holding one connection open indefinitely.
+type ConnLimits struct {
+	// MaxRequests, if positive, is the number of requests served on
+	// a connection. The response to the last one is sent with
+	// Connection: close and the connection is then closed.
+	MaxRequests int
+
+	// MaxBytes, if positive, limits the number of bytes read from a
+	// connection, counting request lines, headers and bodies. Reads
+	// beyond it fail with ErrConnByteLimit and the connection is
+	// closed.
+	MaxBytes int64
+}
+
 // A ConnState represents the state of a client connection to a server.
 // It's used by the optional [Server.ConnState] hook.
 type ConnState int
@@ -2689,6 +2949,51 @@ func (s *Server) readHeaderTimeout() time.Duration {
 	return s.ReadTimeout
 }
 
+// allowsScheme reports whether scheme may appear in an absolute-form
+// request target. s may be nil, meaning the default server
+// configuration.
+func (s *Server) allowsScheme(scheme string) bool {
+	schemes := []string{"http", "https"}
+	if s != nil && s.AbsoluteFormSchemes != nil {
+		schemes = s.AbsoluteFormSchemes
+	}
+	return slices.ContainsFunc(schemes, func(v string) bool { return ascii.EqualFold(v, scheme) })
+}
+
+// maxRequestURIBytes returns the limit on the length of request
+// targets, or 0 for none. s may be nil.
+func (s *Server) maxRequestURIBytes() int {
+	if s == nil {
+		return 0
+	}
+	return s.MaxRequestURIBytes
+}
+
+// versionPolicy returns the accepted request versions. s may be nil.
+func (s *Server) versionPolicy() VersionPolicy {
+	if s == nil {
+		return VersionHTTP1Only
+	}
+	return s.VersionPolicy
+}
+
+func (s *Server) strictHost() bool {
+	return s != nil && s.StrictHost
+}
+
+// transferOptions returns the options for reading request bodies.
+// s may be nil, meaning the default server configuration.
+func (s *Server) transferOptions() transferOptions {
+	if s == nil {
+		return transferOptions{}
+	}
+	return transferOptions{
+		lenientTE:     s.LenientTransferEncoding,
+		maxChunkSize:  s.MaxChunkSize,
+		maxChunkCount: s.MaxChunkCount,
+	}
+}
+
 func (s *Server) doKeepAlives() bool {
 	return !s.disableKeepAlives.Load() && !s.shuttingDown()
 }
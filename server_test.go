@@ -0,0 +1,357 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWriteEarlyHints(t *testing.T) {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := WriteEarlyHints(w, "</a.css>; rel=preload"); err != nil {
+			t.Error(err)
+		}
+		if err := WriteEarlyHints(w, "</a.css>; rel=preload", "</b.js>; rel=preload"); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte("ok"))
+		if err := WriteEarlyHints(w, "</c.js>; rel=preload"); err == nil {
+			t.Error("WriteEarlyHints after the final header succeeded")
+		}
+	}))
+	c, err := net.Dial("tcp", url[len("http://"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	br := bufio.NewReader(c)
+	var codes []int
+	var links [][]string
+	for {
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		codes = append(codes, res.StatusCode)
+		links = append(links, res.Header["Link"])
+		if res.StatusCode >= 200 {
+			break
+		}
+	}
+	if len(codes) != 3 || codes[0] != 103 || codes[1] != 103 || codes[2] != 200 {
+		t.Fatalf("status codes = %v; want [103 103 200]", codes)
+	}
+	if len(links[0]) != 1 || len(links[1]) != 2 || len(links[2]) != 2 {
+		t.Errorf("Link fields = %q; want 1, 2 and 2 values", links)
+	}
+}
+
+func TestServerExpectContinueHandler(t *testing.T) {
+	var called atomic.Bool
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called.Store(true)
+	}), func(s *Server) {
+		s.ExpectContinueHandler = func(r *http.Request) bool {
+			return r.Header.Get("X-Allow") == "yes"
+		}
+	})
+	c, err := net.Dial("tcp", url[len("http://"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("PUT / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n"))
+	res, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusExpectationFailed {
+		t.Errorf("status = %d; want 417", res.StatusCode)
+	}
+	if !res.Close {
+		t.Error("connection not closed after 417")
+	}
+	if called.Load() {
+		t.Error("Handler called")
+	}
+}
+
+func TestWriteContinue(t *testing.T) {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := WriteContinue(w); err != nil {
+			t.Error(err)
+		}
+		if err := WriteContinue(w); err == nil {
+			t.Error("second WriteContinue succeeded")
+		}
+		io.Copy(w, r.Body)
+	}))
+	c, err := net.Dial("tcp", url[len("http://"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("PUT / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n"))
+	br := bufio.NewReader(c)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusContinue {
+		t.Fatalf("status = %d; want 100", res.StatusCode)
+	}
+	c.Write([]byte("hello"))
+	res, err = http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != 200 || string(body) != "hello" {
+		t.Errorf("got %d %q; want 200 \"hello\"", res.StatusCode, body)
+	}
+}
+
+func TestServerAbsoluteFormSchemes(t *testing.T) {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Scheme)
+	}), func(s *Server) {
+		s.AbsoluteFormSchemes = []string{"ftp"}
+	})
+	for target, want := range map[string]int{
+		"ftp://example.com/":  http.StatusOK,
+		"http://example.com/": http.StatusBadRequest,
+	} {
+		c, err := net.Dial("tcp", url[len("http://"):])
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+		res, err := http.ReadResponse(bufio.NewReader(c), nil)
+		c.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != want {
+			t.Errorf("GET %s: status = %d; want %d", target, res.StatusCode, want)
+		}
+	}
+}
+
+func TestServerGetBodyBufferSize(t *testing.T) {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		again := "none"
+		if r.GetBody != nil {
+			rc, err := r.GetBody()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			b2, _ := io.ReadAll(rc)
+			again = string(b2)
+		}
+		io.WriteString(w, string(b)+"|"+again)
+	}), func(s *Server) {
+		s.GetBodyBufferSize = 5
+	})
+	tests := []struct {
+		body, want string
+		expect     bool
+	}{
+		{"hello", "hello|hello", false},
+		{"hello, world", "hello, world|none", false},
+		{"hello", "hello|none", true},
+	}
+	for _, tt := range tests {
+		req := mustNewRequest(t, "POST", url, strings.NewReader(tt.body))
+		if tt.expect {
+			req.Header.Set("Expect", "100-continue")
+		}
+		tr := &Transport{ExpectContinueTimeout: time.Second}
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		tr.CloseIdleConnections()
+		if string(b) != tt.want {
+			t.Errorf("body %q, Expect %v: handler saw %q; want %q", tt.body, tt.expect, b, tt.want)
+		}
+	}
+}
+
+func TestWriteParseErrorResponse(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+		body string
+	}{
+		{errTooLarge, 431, "431 Request Header Fields Too Large"},
+		{&unsupportedTEError{"unsupported transfer encoding: \"x\""}, 501, "Unsupported transfer encoding"},
+		{badRequestError("missing required Host header"), 400, "400 Bad Request: missing required Host header"},
+		{errors.New("malformed"), 400, "400 Bad Request"},
+		{fmt.Errorf("%w: 9000 bytes", ErrRequestURITooLong), 414, "414 URI Too Long"},
+		{fmt.Errorf("%w: malformed HTTP version", ErrUnsupportedVersion), 505, "505 HTTP Version Not Supported"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteParseErrorResponse(&buf, tt.err); err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.ReadResponse(bufio.NewReader(&buf), nil)
+		if err != nil {
+			t.Fatalf("%v: reading response: %v", tt.err, err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		if res.StatusCode != tt.code || string(body) != tt.body || !res.Close {
+			t.Errorf("%v: got %d %q (close %v); want %d %q with Connection: close",
+				tt.err, res.StatusCode, body, res.Close, tt.code, tt.body)
+		}
+	}
+}
+
+func TestServerParseErrorResponse(t *testing.T) {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), func(s *Server) {
+		s.MaxHeaderBytes = 1 << 10
+	})
+	for in, want := range map[string]int{
+		"GET / HTTP/1.1\r\nHost: x\r\nX-Big: " + strings.Repeat("a", 8<<10) + "\r\n\r\n": 431,
+		"GET / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: gzip\r\n\r\n":                   501,
+		"GET / HTTP/1.1\r\n\r\n": 400,
+	} {
+		c, err := net.Dial("tcp", url[len("http://"):])
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Write([]byte(in))
+		res, err := http.ReadResponse(bufio.NewReader(c), nil)
+		c.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != want {
+			t.Errorf("status = %d; want %d", res.StatusCode, want)
+		}
+	}
+}
+
+func TestServerMaxRequestURIBytes(t *testing.T) {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), func(s *Server) {
+		s.MaxRequestURIBytes = 64
+	})
+	for in, want := range map[string]int{
+		"GET /" + strings.Repeat("a", 63) + " HTTP/1.1\r\nHost: x\r\n\r\n": 200,
+		"GET /" + strings.Repeat("a", 64) + " HTTP/1.1\r\nHost: x\r\n\r\n": 414,
+		"GET / HTTP/1.x\r\nHost: x\r\n\r\n":                                505,
+	} {
+		c, err := net.Dial("tcp", url[len("http://"):])
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Write([]byte(in))
+		res, err := http.ReadResponse(bufio.NewReader(c), nil)
+		c.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != want {
+			t.Errorf("%.20q...: status = %d; want %d", in, res.StatusCode, want)
+		}
+	}
+
+	_, err := readRequest(bufio.NewReader(strings.NewReader("GET /"+strings.Repeat("a", 64)+" HTTP/1.1\r\n\r\n")), &Server{MaxRequestURIBytes: 64})
+	if !errors.Is(err, ErrRequestURITooLong) {
+		t.Errorf("readRequest error = %v; want ErrRequestURITooLong", err)
+	}
+}
+
+func TestServerVersionPolicy(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for policy, want := range map[VersionPolicy]int{
+		VersionHTTP1Only:       505,
+		VersionHTTP1AndPreface: 200,
+	} {
+		_, url := newTestServer(t, h, func(s *Server) { s.VersionPolicy = policy })
+		c, err := net.Dial("tcp", url[len("http://"):])
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Write([]byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"))
+		res, err := http.ReadResponse(bufio.NewReader(c), nil)
+		c.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != want {
+			t.Errorf("policy %d: status = %d; want %d", policy, res.StatusCode, want)
+		}
+	}
+}
+
+func TestServerConnLimitsMaxRequests(t *testing.T) {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), func(s *Server) {
+		s.ConnLimits.MaxRequests = 2
+	})
+	c, err := net.Dial("tcp", url[len("http://"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte(strings.Repeat("GET / HTTP/1.1\r\nHost: x\r\n\r\n", 3)))
+	br := bufio.NewReader(c)
+	for i, wantClose := range []bool{false, true} {
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+		if res.Close != wantClose {
+			t.Errorf("response %d: Close = %v; want %v", i, res.Close, wantClose)
+		}
+	}
+	if _, err := http.ReadResponse(br, nil); err == nil {
+		t.Error("third request on the connection was served")
+	}
+}
+
+func TestServerConnLimitsMaxBytes(t *testing.T) {
+	const req = "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 100\r\n\r\n"
+	bodyErr := make(chan error, 1)
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		bodyErr <- err
+	}), func(s *Server) {
+		s.ConnLimits.MaxBytes = int64(len(req)) + 50
+	})
+	c, err := net.Dial("tcp", url[len("http://"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte(req + strings.Repeat("a", 100)))
+	if err := <-bodyErr; !errors.Is(err, ErrConnByteLimit) {
+		t.Errorf("reading the body: %v; want ErrConnByteLimit", err)
+	}
+
+	// A request that crosses the limit in its header is not answered.
+	c2, err := net.Dial("tcp", url[len("http://"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	c2.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\nX-Pad: " + strings.Repeat("a", len(req)+50) + "\r\n\r\n"))
+	c2.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := c2.Read(make([]byte, 1)); n != 0 || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read = %d, %v; want the connection closed without a response", n, err)
+	}
+}
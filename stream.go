@@ -1,8 +1,17 @@
 package http
 
-import "io"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
 
-// The interface is implemented by the http.ResponseWriter.
+// The interface is implemented by the http.ResponseWriter, and by the
+// Body of a 101 Switching Protocols response returned by Transport.
 type Streamer interface {
 	Stream() Stream
 }
@@ -11,3 +20,237 @@ type Stream interface {
 	io.Reader
 	io.Writer
 }
+
+var (
+	errNotProtocolSwitch = errors.New("http: response is not a protocol switch")
+	errBodyNotDuplex     = errors.New("http: response body does not support writing")
+)
+
+// AsStream returns the duplex Stream of a 101 Switching Protocols
+// response, through which the switched-to protocol is spoken. It
+// returns an error if res is not a protocol switch or if its Body
+// cannot be written to.
+func AsStream(res *http.Response) (Stream, error) {
+	if res == nil || !isProtocolSwitchResp(res) {
+		return nil, errNotProtocolSwitch
+	}
+	switch body := res.Body.(type) {
+	case Streamer:
+		return body.Stream(), nil
+	case Stream:
+		return body, nil
+	}
+	return nil, errBodyNotDuplex
+}
+
+// StreamCounters holds the byte totals of a Stream returned by
+// CountedStream. The counters may be read while the Stream is in use.
+type StreamCounters struct {
+	Read    atomic.Int64 // bytes returned by Read
+	Written atomic.Int64 // bytes accepted by Write
+}
+
+// CountedStream returns a Stream that forwards to s and counts the
+// bytes transferred in each direction.
+//
+// The returned Stream also has Close, CloseWrite, SetDeadline,
+// SetReadDeadline and SetWriteDeadline methods, which forward to s
+// if s implements them and return an error wrapping
+// http.ErrNotSupported otherwise.
+func CountedStream(s Stream) (Stream, *StreamCounters) {
+	c := &countedStream{s: s}
+	return c, &c.n
+}
+
+type countedStream struct {
+	s Stream
+	n StreamCounters
+}
+
+func (c *countedStream) Read(p []byte) (int, error) {
+	n, err := c.s.Read(p)
+	c.n.Read.Add(int64(n))
+	return n, err
+}
+
+func (c *countedStream) Write(p []byte) (int, error) {
+	n, err := c.s.Write(p)
+	c.n.Written.Add(int64(n))
+	return n, err
+}
+
+func (c *countedStream) Close() error {
+	if cl, ok := c.s.(io.Closer); ok {
+		return cl.Close()
+	}
+	return fmt.Errorf("Close: %w", http.ErrNotSupported)
+}
+
+func (c *countedStream) CloseWrite() error {
+	if cw, ok := c.s.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return fmt.Errorf("CloseWrite: %w", http.ErrNotSupported)
+}
+
+func (c *countedStream) SetDeadline(t time.Time) error {
+	if d, ok := c.s.(interface{ SetDeadline(time.Time) error }); ok {
+		return d.SetDeadline(t)
+	}
+	return fmt.Errorf("SetDeadline: %w", http.ErrNotSupported)
+}
+
+func (c *countedStream) SetReadDeadline(t time.Time) error {
+	if d, ok := c.s.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return fmt.Errorf("SetReadDeadline: %w", http.ErrNotSupported)
+}
+
+func (c *countedStream) SetWriteDeadline(t time.Time) error {
+	if d, ok := c.s.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return fmt.Errorf("SetWriteDeadline: %w", http.ErrNotSupported)
+}
+
+// BridgeStreams copies data between a and b in both directions
+// concurrently until both directions are done, and returns the number
+// of bytes copied each way.
+//
+// When one direction reaches EOF, the write side of its destination is
+// closed if the destination has a CloseWrite method, and the other
+// direction continues. If CloseWrite is not supported, or a copy fails,
+// or ctx is done, both Streams are torn down: their deadlines are set
+// in the past if they have a SetDeadline method, or they are closed if
+// they implement io.Closer. BridgeStreams always waits for both copies
+// to return, so a Stream supporting neither can delay its return.
+//
+// The returned error is the first copy error, or the context's cause
+// if ctx was done first. Reaching EOF in both directions is not an
+// error.
+func BridgeStreams(ctx context.Context, a, b Stream) (aToB, bToA int64, err error) {
+	return BridgeStreamsIdle(ctx, a, b, 0)
+}
+
+// ErrIdleTimeout is returned by BridgeStreamsIdle when no bytes moved
+// in either direction for the idle timeout.
+var ErrIdleTimeout error = idleTimeoutError{}
+
+type idleTimeoutError struct{}
+
+func (idleTimeoutError) Timeout() bool   { return true }
+func (idleTimeoutError) Temporary() bool { return true }
+func (idleTimeoutError) Error() string   { return "http: stream bridge idle timeout" }
+
+// BridgeStreamsIdle is like BridgeStreams, but also tears the bridge
+// down and returns ErrIdleTimeout once no bytes have been read from
+// either Stream for idleTimeout. The timeout is tracked with a timer
+// that is extended by every transfer; teardown uses the Streams'
+// deadline capability when available, as described for BridgeStreams.
+// An idleTimeout of zero or less means no idle timeout.
+func BridgeStreamsIdle(ctx context.Context, a, b Stream, idleTimeout time.Duration) (aToB, bToA int64, err error) {
+	var last atomic.Int64 // UnixNano of the last read from either Stream
+	last.Store(time.Now().UnixNano())
+	var idleC <-chan time.Time
+	var timer *time.Timer
+	if idleTimeout > 0 {
+		timer = time.NewTimer(idleTimeout)
+		defer timer.Stop()
+		idleC = timer.C
+	}
+
+	type result struct {
+		n    int64
+		err  error
+		aToB bool
+	}
+	resc := make(chan result, 2)
+	pipe := func(dst, src Stream, aToB bool) {
+		n, err := io.Copy(dst, &activityReader{src, &last})
+		if err == nil {
+			if cw, ok := dst.(interface{ CloseWrite() error }); !ok || cw.CloseWrite() != nil {
+				err = errHalfCloseUnsupported
+			}
+		}
+		resc <- result{n, err, aToB}
+	}
+	go pipe(b, a, true)
+	go pipe(a, b, false)
+
+	aborted := false
+	abort := func() {
+		if !aborted {
+			aborted = true
+			abortStream(a)
+			abortStream(b)
+		}
+	}
+	done := ctx.Done()
+	for pending := 2; pending > 0; {
+		select {
+		case <-done:
+			done = nil
+			if err == nil && !aborted {
+				err = context.Cause(ctx)
+			}
+			abort()
+		case <-idleC:
+			if d := idleTimeout - time.Since(time.Unix(0, last.Load())); d > 0 {
+				timer.Reset(d)
+				continue
+			}
+			idleC = nil
+			if err == nil && !aborted {
+				err = ErrIdleTimeout
+			}
+			abort()
+		case r := <-resc:
+			pending--
+			if r.aToB {
+				aToB = r.n
+			} else {
+				bToA = r.n
+			}
+			if r.err == errHalfCloseUnsupported {
+				abort()
+			} else if r.err != nil {
+				if err == nil && !aborted {
+					err = r.err
+				}
+				abort()
+			}
+		}
+	}
+	return aToB, bToA, err
+}
+
+// activityReader records the time of each successful read in last.
+type activityReader struct {
+	r    io.Reader
+	last *atomic.Int64
+}
+
+func (ar *activityReader) Read(p []byte) (int, error) {
+	n, err := ar.r.Read(p)
+	if n > 0 {
+		ar.last.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// errHalfCloseUnsupported reports internally that a copy in
+// BridgeStreams ended at EOF but its destination could not be
+// half-closed.
+var errHalfCloseUnsupported = errors.New("http: stream does not support CloseWrite")
+
+// abortStream unblocks pending I/O on s by expiring its deadline,
+// or by closing it if it has no deadline support.
+func abortStream(s Stream) {
+	if d, ok := s.(interface{ SetDeadline(time.Time) error }); ok && d.SetDeadline(aLongTimeAgo) == nil {
+		return
+	}
+	if c, ok := s.(io.Closer); ok {
+		c.Close()
+	}
+}
@@ -0,0 +1,255 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newEchoUpgradeServer returns the address of a server that answers
+// each request with 101 Switching Protocols and then echoes what it
+// reads.
+func newEchoUpgradeServer(t *testing.T) string {
+	return newRawServer(t, func(c net.Conn) {
+		br := bufio.NewReader(c)
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		io.Copy(c, br)
+	})
+}
+
+func upgradeRequest(t *testing.T, addr string) *http.Response {
+	t.Helper()
+	req := mustNewRequest(t, "GET", "http://"+addr+"/", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	res, err := (&Transport{}).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d; want 101", res.StatusCode)
+	}
+	return res
+}
+
+func testEcho(t *testing.T, s Stream) {
+	t.Helper()
+	if _, err := io.WriteString(s, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(s, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("echoed %q; want %q", buf, "ping")
+	}
+}
+
+func TestProtocolSwitchBodyStreamer(t *testing.T) {
+	res := upgradeRequest(t, newEchoUpgradeServer(t))
+	s, ok := res.Body.(Streamer)
+	if !ok {
+		t.Fatalf("Body %T does not implement Streamer", res.Body)
+	}
+	testEcho(t, s.Stream())
+}
+
+func TestAsStream(t *testing.T) {
+	res := upgradeRequest(t, newEchoUpgradeServer(t))
+	s, err := AsStream(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEcho(t, s)
+
+	if _, err := AsStream(&http.Response{StatusCode: 200, Body: http.NoBody}); err == nil {
+		t.Error("AsStream of a 200 response succeeded")
+	}
+	if _, err := AsStream(nil); err == nil {
+		t.Error("AsStream(nil) succeeded")
+	}
+}
+
+func TestCountedStream(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	s, n := CountedStream(c1)
+	go func() {
+		buf := make([]byte, 5)
+		io.ReadFull(c2, buf)
+		c2.Write([]byte("abc"))
+	}()
+	if _, err := io.WriteString(s, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(s, make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if r, w := n.Read.Load(), n.Written.Load(); r != 3 || w != 5 {
+		t.Errorf("counters = %d read, %d written; want 3, 5", r, w)
+	}
+
+	// net.Pipe has no CloseWrite.
+	cw := s.(interface{ CloseWrite() error })
+	if err := cw.CloseWrite(); !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("CloseWrite = %v; want ErrNotSupported", err)
+	}
+}
+
+// tcpPair returns the two ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c1, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c1.Close()
+		c2.Close()
+	})
+	return c1, c2
+}
+
+func TestBridgeStreams(t *testing.T) {
+	client1, a := tcpPair(t)
+	b, client2 := tcpPair(t)
+	type result struct {
+		aToB, bToA int64
+		err        error
+	}
+	done := make(chan result, 1)
+	go func() {
+		aToB, bToA, err := BridgeStreams(context.Background(), a, b)
+		done <- result{aToB, bToA, err}
+	}()
+
+	io.WriteString(client1, "hello")
+	client1.(*net.TCPConn).CloseWrite()
+	got, _ := io.ReadAll(client2)
+	if string(got) != "hello" {
+		t.Errorf("client2 read %q; want %q", got, "hello")
+	}
+	io.WriteString(client2, "world!")
+	client2.(*net.TCPConn).CloseWrite()
+	got, _ = io.ReadAll(client1)
+	if string(got) != "world!" {
+		t.Errorf("client1 read %q; want %q", got, "world!")
+	}
+
+	if r := <-done; r != (result{5, 6, nil}) {
+		t.Errorf("BridgeStreams = %d, %d, %v; want 5, 6, nil", r.aToB, r.bToA, r.err)
+	}
+}
+
+func TestBridgeStreamsContext(t *testing.T) {
+	_, a := tcpPair(t)
+	b, _ := tcpPair(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := BridgeStreams(ctx, a, b)
+		done <- err
+	}()
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("err = %v; want context.Canceled", err)
+	}
+}
+
+func TestBridgeStreamsIdle(t *testing.T) {
+	client1, a := tcpPair(t)
+	b, client2 := tcpPair(t)
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		_, _, err := BridgeStreamsIdle(context.Background(), a, b, 100*time.Millisecond)
+		done <- err
+	}()
+	// Traffic within the timeout keeps the bridge up.
+	for range 3 {
+		time.Sleep(50 * time.Millisecond)
+		io.WriteString(client1, "x")
+		io.ReadFull(client2, make([]byte, 1))
+	}
+	if err := <-done; err != ErrIdleTimeout {
+		t.Errorf("err = %v; want ErrIdleTimeout", err)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Errorf("bridge torn down after %v despite traffic", d)
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	streams := make(chan Stream, 1)
+	_, u := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s := w.(Streamer).Stream(); s != nil {
+			t.Error("Stream before a 101 header is not nil")
+		}
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Upgrade", "echo")
+		w.WriteHeader(http.StatusSwitchingProtocols)
+		s := w.(Streamer).Stream()
+		streams <- s
+		if s == nil {
+			return
+		}
+		defer s.(io.Closer).Close()
+		io.Copy(s, s)
+	}))
+	c, err := net.Dial("tcp", u[len("http://"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// Bytes sent right behind the request are buffered by the server
+	// before the handler runs, and must be the first the Stream reads.
+	io.WriteString(c, "GET / HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nearly")
+	br := bufio.NewReader(c)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d; want 101", res.StatusCode)
+	}
+	s := <-streams
+	if s == nil {
+		t.Fatal("Stream after a 101 header is nil")
+	}
+	if _, ok := s.(interface {
+		CloseWrite() error
+		SetDeadline(time.Time) error
+		SetReadDeadline(time.Time) error
+		SetWriteDeadline(time.Time) error
+	}); !ok {
+		t.Errorf("Stream %T lacks CloseWrite or deadline methods", s)
+	}
+	io.WriteString(c, "ping")
+	buf := make([]byte, len("earlyping"))
+	if _, err := io.ReadFull(br, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "earlyping" {
+		t.Errorf("echoed %q; want %q", buf, "earlyping")
+	}
+}
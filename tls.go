@@ -3,6 +3,7 @@ package http
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"net"
 )
 
@@ -13,3 +14,26 @@ type TLSConn interface {
 	HandshakeContext(ctx context.Context) error
 	ConnectionState() tls.ConnectionState
 }
+
+// DispatchByALPN completes the TLS handshake on conn, if needed, and
+// returns the protocol to serve it with, based on the negotiated ALPN
+// protocol: HTTP2 for "h2", and HTTP1 for "http/1.1" or when no
+// protocol was negotiated. The result has exactly one protocol set.
+//
+// An error is returned if the handshake fails or another protocol was
+// negotiated.
+func DispatchByALPN(conn TLSConn) (Protocols, error) {
+	var p Protocols
+	if err := conn.Handshake(); err != nil {
+		return p, err
+	}
+	switch proto := conn.ConnectionState().NegotiatedProtocol; proto {
+	case "h2":
+		p.SetHTTP2(true)
+	case "", "http/1.1":
+		p.SetHTTP1(true)
+	default:
+		return p, fmt.Errorf("http: unsupported ALPN protocol %q", proto)
+	}
+	return p, nil
+}
@@ -0,0 +1,54 @@
+package http
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+func TestDispatchByALPN(t *testing.T) {
+	cert, pool := newTestCert(t, "example.com")
+	tests := []struct {
+		clientProtos []string
+		want         string // Protocols.String, or "" for an error
+	}{
+		{[]string{"h2", "http/1.1"}, "{HTTP2}"},
+		{[]string{"http/1.1"}, "{HTTP1}"},
+		{nil, "{HTTP1}"},
+		{[]string{"spdy/3"}, ""},
+	}
+	for _, tt := range tests {
+		c1, c2 := net.Pipe()
+		srv := tls.Server(c1, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"h2", "http/1.1", "spdy/3"},
+		})
+		go func() {
+			cc := tls.Client(c2, &tls.Config{ServerName: "example.com", RootCAs: pool, NextProtos: tt.clientProtos})
+			cc.Handshake()
+			c2.Close()
+		}()
+		p, err := DispatchByALPN(srv)
+		c1.Close()
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("NextProtos %q: DispatchByALPN = %v; want error", tt.clientProtos, p)
+			}
+			continue
+		}
+		if err != nil || p.String() != tt.want {
+			t.Errorf("NextProtos %q: DispatchByALPN = %v, %v; want %v", tt.clientProtos, p, err, tt.want)
+		}
+	}
+}
+
+func TestDispatchByALPNHandshakeError(t *testing.T) {
+	cert, _ := newTestCert(t, "example.com")
+	c1, c2 := net.Pipe()
+	c2.Close()
+	srv := tls.Server(c1, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer srv.Close()
+	if p, err := DispatchByALPN(srv); err == nil {
+		t.Errorf("DispatchByALPN = %v, nil; want handshake error", p)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"maps"
 	"net/http"
 	"net/textproto"
+	"strings"
 	"sync"
 
 	"github.com/puernya/go-http/internal"
@@ -64,15 +65,46 @@ type transferReader struct {
 	Chunked       bool
 	Close         bool
 	Trailer       http.Header
+
+	opts      transferOptions
+	unbounded bool // lenient unknown Transfer-Encoding; read until close
+}
+
+// transferOptions configures readTransfer.
+// The zero value gives the default, strict behavior.
+type transferOptions struct {
+	// lenientTE makes a Transfer-Encoding other than "chunked" frame the
+	// body as chunked if chunked is the final coding, and as read until
+	// close otherwise, instead of failing with an unsupportedTEError.
+	lenientTE bool
+
+	// maxChunkSize and maxChunkCount, if positive, limit the size of
+	// each chunk and the number of chunks of a chunked body.
+	maxChunkSize  int64
+	maxChunkCount int
 }
 
+// ErrChunkTooLarge and ErrTooManyChunks are returned when reading a
+// chunked request body that exceeds [Server.MaxChunkSize] or
+// [Server.MaxChunkCount].
+var (
+	ErrChunkTooLarge = internal.ErrChunkTooLarge
+	ErrTooManyChunks = internal.ErrTooManyChunks
+)
+
 func (t *transferReader) protoAtLeast(m, n int) bool {
 	return t.ProtoMajor > m || (t.ProtoMajor == m && t.ProtoMinor >= n)
 }
 
+// noResponseBodyExpected reports whether a response to a request with
+// the given method has no body, even if its header announces one.
+func noResponseBodyExpected(requestMethod string) bool {
+	return requestMethod == "HEAD"
+}
+
 // msg is *Request or *Response.
-func readTransfer(msg any, r *bufio.Reader) (err error) {
-	t := &transferReader{RequestMethod: "GET"}
+func readTransfer(msg any, r *bufio.Reader, opts transferOptions) (err error) {
+	t := &transferReader{RequestMethod: "GET", opts: opts}
 
 	// Unify input
 	isResponse := false
@@ -110,11 +142,22 @@ func readTransfer(msg any, r *bufio.Reader) (err error) {
 		return err
 	}
 
+	// A response to HEAD never has a body, whatever its header says.
+	// fixLength already returns 0 for it given the request method,
+	// which is why a Response must carry its Request. Neither do 1xx,
+	// 204 and 304 responses.
+	headResponse := isResponse && noResponseBodyExpected(t.RequestMethod)
+	noBody := headResponse || isResponse && !bodyAllowedForStatus(t.StatusCode)
+
 	realLength, err := fixLength(isResponse, t.StatusCode, t.RequestMethod, t.Header, t.Chunked)
 	if err != nil {
 		return err
 	}
-	if isResponse && t.RequestMethod == "HEAD" {
+	if t.unbounded && !noBody {
+		realLength = -1
+		t.Close = true
+	}
+	if headResponse {
 		if n, err := parseContentLength(t.Header["Content-Length"]); err != nil {
 			return err
 		} else {
@@ -144,12 +187,10 @@ func readTransfer(msg any, r *bufio.Reader) (err error) {
 	// Prepare body reader. ContentLength < 0 means chunked encoding
 	// or close connection when finished, since multipart is not supported yet
 	switch {
+	case noBody:
+		t.Body = http.NoBody
 	case t.Chunked:
-		if isResponse && (t.RequestMethod == "HEAD" || !bodyAllowedForStatus(t.StatusCode)) {
-			t.Body = http.NoBody
-		} else {
-			t.Body = &body{src: internal.NewChunkedReader(r), hdr: msg, r: r, closing: t.Close}
-		}
+		t.Body = &body{src: internal.NewChunkedReaderLimit(r, t.opts.maxChunkSize, t.opts.maxChunkCount), hdr: msg, r: r, closing: t.Close}
 	case realLength == 0:
 		t.Body = http.NoBody
 	case realLength > 0:
@@ -205,15 +246,70 @@ func (t *transferReader) parseTransferEncoding() error {
 	// only if set to "chunked". This is one of the most security sensitive
 	// surfaces in HTTP/1.1 due to the risk of request smuggling, so we keep it
 	// strict and simple.
+	if len(raw) == 1 && ascii.EqualFold(raw[0], "chunked") {
+		t.Chunked = true
+		return nil
+	}
+	if t.opts.lenientTE {
+		t.parseLenientTransferEncoding(raw)
+		return nil
+	}
 	if len(raw) != 1 {
 		return &unsupportedTEError{fmt.Sprintf("too many transfer encodings: %q", raw)}
 	}
-	if !ascii.EqualFold(raw[0], "chunked") {
-		return &unsupportedTEError{fmt.Sprintf("unsupported transfer encoding: %q", raw[0])}
+	return &unsupportedTEError{fmt.Sprintf("unsupported transfer encoding: %q", raw[0])}
+}
+
+// parseLenientTransferEncoding handles a Transfer-Encoding other than
+// a single "chunked" when lenient parsing is enabled. The body is
+// chunked if chunked is the final coding, as RFC 9112 section 6.3
+// requires, and read until the connection closes otherwise. Any
+// Content-Length is ignored and the connection is closed afterwards.
+// The remaining codings are left to the caller in the header.
+func (t *transferReader) parseLenientTransferEncoding(raw []string) {
+	codings := transferCodings(raw)
+	delete(t.Header, "Content-Length")
+	t.Close = true
+	if n := len(codings); n > 0 && ascii.EqualFold(codings[n-1], "chunked") {
+		t.Chunked = true
+		codings = codings[:n-1]
+	} else {
+		t.unbounded = true
+	}
+	if len(codings) > 0 {
+		t.Header["Transfer-Encoding"] = []string{strings.Join(codings, ", ")}
 	}
+}
 
-	t.Chunked = true
-	return nil
+// transferCodings splits Transfer-Encoding field values into their
+// codings, dropping empty list elements.
+func transferCodings(vv []string) []string {
+	var codings []string
+	for _, v := range vv {
+		for c := range strings.SplitSeq(v, ",") {
+			if c = textproto.TrimString(c); c != "" {
+				codings = append(codings, c)
+			}
+		}
+	}
+	return codings
+}
+
+// IsChunked reports whether the final transfer coding listed in the
+// Transfer-Encoding fields of header is "chunked", which RFC 9112
+// requires of any message body that is transfer-coded but not
+// delimited by closing the connection. Lists spread over several
+// fields and mixed case are handled; parameters are not allowed on
+// "chunked" and make IsChunked return false.
+//
+// Reading a message removes Transfer-Encoding from its header; for a
+// parsed message, use IsChunked on its TransferEncoding:
+//
+//	IsChunked(http.Header{"Transfer-Encoding": req.TransferEncoding})
+func IsChunked(header http.Header) bool {
+	codings := transferCodings(header["Transfer-Encoding"])
+	n := len(codings)
+	return n > 0 && ascii.EqualFold(codings[n-1], "chunked")
 }
 
 // Determine whether to hang up after sending a request and body, or
@@ -237,6 +333,11 @@ func shouldClose(major, minor int, header http.Header, removeCloseHeader bool) b
 	return hasClose
 }
 
+// ErrUnsupportedTransferEncoding is matched by errors.Is for errors
+// reporting a request or response whose Transfer-Encoding is not
+// supported.
+var ErrUnsupportedTransferEncoding = errors.New("http: unsupported transfer encoding")
+
 // unsupportedTEError reports unsupported transfer-encodings.
 type unsupportedTEError struct {
 	err string
@@ -246,6 +347,10 @@ func (uste *unsupportedTEError) Error() string {
 	return uste.err
 }
 
+func (uste *unsupportedTEError) Is(target error) bool {
+	return target == ErrUnsupportedTransferEncoding
+}
+
 // isUnsupportedTEError checks if the error is of type
 // unsupportedTEError. It is usually invoked with a non-nil err.
 func isUnsupportedTEError(err error) bool {
@@ -0,0 +1,130 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUnknownTransferEncoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		te      string
+		body    string
+		lenient bool
+		want    string // body, or "" if the request is rejected
+		wantTE  string
+	}{
+		{
+			name: "strict gzip",
+			te:   "gzip",
+			body: "abc",
+		},
+		{
+			name: "strict gzip chunked",
+			te:   "gzip, chunked",
+			body: "3\r\nabc\r\n0\r\n\r\n",
+		},
+		{
+			name:    "lenient chunked last",
+			te:      "gzip, chunked",
+			body:    "3\r\nabc\r\n0\r\n\r\n",
+			lenient: true,
+			want:    "abc",
+			wantTE:  "gzip",
+		},
+		{
+			name:    "lenient read until close",
+			te:      "gzip",
+			body:    "abcdef",
+			lenient: true,
+			want:    "abcdef",
+			wantTE:  "gzip",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 2\r\nTransfer-Encoding: " + tt.te + "\r\n\r\n" + tt.body
+			srv := &Server{LenientTransferEncoding: tt.lenient}
+			req, err := readRequest(bufio.NewReader(strings.NewReader(raw)), srv)
+			if tt.want == "" {
+				if !errors.Is(err, ErrUnsupportedTransferEncoding) {
+					t.Fatalf("err = %v; want ErrUnsupportedTransferEncoding", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.want {
+				t.Errorf("body = %q; want %q", body, tt.want)
+			}
+			if got := req.Header.Get("Transfer-Encoding"); got != tt.wantTE {
+				t.Errorf("Transfer-Encoding = %q; want %q", got, tt.wantTE)
+			}
+			if req.Header.Get("Content-Length") != "" {
+				t.Errorf("Content-Length kept")
+			}
+			if !req.Close {
+				t.Errorf("Close = false; want true")
+			}
+		})
+	}
+}
+
+func TestServerChunkLimits(t *testing.T) {
+	raw := "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"3\r\nabc\r\n3\r\ndef\r\n0\r\n\r\n"
+	for _, tt := range []struct {
+		srv     *Server
+		wantErr error
+	}{
+		{&Server{}, nil},
+		{&Server{MaxChunkSize: 2}, ErrChunkTooLarge},
+		{&Server{MaxChunkCount: 1}, ErrTooManyChunks},
+	} {
+		req, err := readRequest(bufio.NewReader(strings.NewReader(raw)), tt.srv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadAll(req.Body)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("MaxChunkSize %d, MaxChunkCount %d: err = %v; want %v",
+				tt.srv.MaxChunkSize, tt.srv.MaxChunkCount, err, tt.wantErr)
+		}
+	}
+}
+
+func TestIsChunked(t *testing.T) {
+	tests := []struct {
+		te   []string
+		want bool
+	}{
+		{[]string{"chunked"}, true},
+		{[]string{"Chunked"}, true},
+		{[]string{"gzip, chunked"}, true},
+		{[]string{"gzip", "chunked"}, true},
+		{[]string{"chunked, "}, true},
+		{[]string{"chunked, gzip"}, false},
+		{[]string{"chunked", "gzip"}, false},
+		{[]string{"chunked;x=1"}, false},
+		{[]string{""}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.te != nil {
+			h["Transfer-Encoding"] = tt.te
+		}
+		if got := IsChunked(h); got != tt.want {
+			t.Errorf("IsChunked(Transfer-Encoding: %q) = %v; want %v", tt.te, got, tt.want)
+		}
+	}
+}
@@ -11,11 +11,15 @@ package http
 
 import (
 	"bufio"
+	"bytes"
 	"compress/flate"
 	"compress/gzip"
 	"container/list"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -24,9 +28,13 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptrace"
+	"net/netip"
 	"net/textproto"
 	"net/url"
+	"os"
 	"reflect"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -34,6 +42,7 @@ import (
 	"github.com/puernya/go-http/internal/ascii"
 
 	"golang.org/x/net/http/httpguts"
+	"golang.org/x/net/proxy"
 )
 
 // Transport is an implementation of [net/http.RoundTripper] that supports HTTP,
@@ -59,8 +68,8 @@ import (
 // automatically (100 expect-continue) or ignored. The one
 // exception is HTTP status code 101 (Switching Protocols), which is
 // considered a terminal status and returned by [Transport.RoundTrip]. To see the
-// ignored 1xx responses, use the httptrace trace package's
-// ClientTrace.Got1xxResponse.
+// ignored 1xx responses, set [Transport.On1xxResponse] or use the
+// httptrace trace package's ClientTrace.Got1xxResponse.
 //
 // Transport only retries a request upon encountering a network error
 // if the connection has already been used successfully and if the
@@ -77,6 +86,10 @@ type Transport struct {
 	idleConnWait map[connectMethodKey]wantConnQueue  // waiting getConns
 	idleLRU      connLRU
 
+	// pipelineConns holds HTTP/1 connections with pipelined requests in
+	// flight that can accept more; see MaxPipelineDepth. Guarded by idleMu.
+	pipelineConns map[connectMethodKey][]*persistConn
+
 	reqMu       sync.Mutex
 	reqCanceler map[*http.Request]context.CancelCauseFunc
 
@@ -88,6 +101,32 @@ type Transport struct {
 	connsPerHostWait map[connectMethodKey]wantConnQueue // waiting getConns
 	dialsInProgress  wantConnQueue
 
+	// Proxy specifies a function to return a proxy for a given
+	// Request. If the function returns a non-nil error, the
+	// request is aborted with the provided error.
+	//
+	// The proxy type is determined by the URL scheme. "http",
+	// "https", "socks5", and "socks5h" are supported. If the scheme is
+	// empty, "http" is assumed. With an HTTP proxy, requests for https
+	// URLs are tunneled through the proxy with a CONNECT request, and
+	// requests for http URLs are sent to the proxy in absolute form.
+	// With a SOCKS5 proxy, all requests are tunneled with a SOCKS5
+	// CONNECT command, and the target host name is resolved by the
+	// proxy; any TLS handshake with the target happens after the SOCKS5
+	// handshake.
+	//
+	// If the proxy URL contains a userinfo subcomponent, the proxy
+	// request will pass the username and password in a
+	// Proxy-Authorization header, or use them for SOCKS5
+	// username/password authentication.
+	//
+	// If Proxy is nil or returns a nil *URL, no proxy is used.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// ProxyConnectHeader optionally specifies headers to send to
+	// proxies during CONNECT requests.
+	ProxyConnectHeader http.Header
+
 	// DialContext specifies the dial function for creating unencrypted TCP connections.
 	// If DialContext is nil (and the deprecated Dial below is also nil),
 	// then the transport dials using package net.
@@ -110,6 +149,50 @@ type Transport struct {
 	// If both are set, DialContext takes priority.
 	Dial func(network, addr string) (net.Conn, error)
 
+	// DialTimeout, if non-zero, is the maximum amount of time a
+	// single dial may take, independent of the request context's
+	// deadline. It bounds DialContext and DialTLSContext (or the
+	// default dialer) but not the deprecated Dial and DialTLS hooks,
+	// which take no context.
+	// Zero means no limit other than the request context.
+	DialTimeout time.Duration
+
+	// SendProxyProtocol, if non-nil, makes the Transport write a
+	// PROXY protocol header with the given Version (1 or 2) on each
+	// newly dialed connection, before any TLS handshake or request.
+	// Reused connections do not carry it again. A nil Source or
+	// Destination is replaced by the dialed connection's local or
+	// remote address, respectively.
+	//
+	// The header is not sent on connections created by DialTLS,
+	// DialTLSContext or GetTLSConn.
+	SendProxyProtocol *ProxyInfo
+
+	// DNSCache, if non-nil, resolves the host names dialed by the
+	// Transport, caching the results. The addresses are then tried in
+	// order, or raced if HappyEyeballs is set, each as one dial, until
+	// one succeeds, so DialContext and Dial receive an IP address and
+	// port instead of a host name. It is not used by DialTLS,
+	// DialTLSContext or GetTLSConn.
+	//
+	// A DNSCache may be shared between Transports, including those
+	// created by Clone.
+	DNSCache *DNSCache
+
+	// HappyEyeballs, if true, makes the Transport resolve the host
+	// names it dials, through DNSCache if set, and race connection
+	// attempts to their addresses as described by RFC 8305: attempts
+	// alternate between IPv6 and IPv4 addresses, starting with the
+	// family of the first address, and a new attempt starts each
+	// HappyEyeballsDelay while earlier ones are pending, or as soon as
+	// one fails. The first connection established is used and the
+	// other attempts are abandoned. Each attempt is made with
+	// DialContext or Dial, if set, as for DNSCache.
+	//
+	// HappyEyeballsDelay zero means 250ms.
+	HappyEyeballs      bool
+	HappyEyeballsDelay time.Duration
+
 	// DialTLSContext specifies an optional dial function for creating
 	// TLS connections for non-proxied HTTPS requests.
 	//
@@ -117,9 +200,9 @@ type Transport struct {
 	// DialContext and TLSClientConfig are used.
 	//
 	// If DialTLSContext is set, the Dial and DialContext hooks are not used for HTTPS
-	// requests and the TLSClientConfig and TLSHandshakeTimeout
-	// are ignored. The returned net.Conn is assumed to already be
-	// past the TLS handshake.
+	// requests and the TLSClientConfig is ignored. If the returned
+	// TLSConn has not completed its handshake, the Transport calls
+	// its HandshakeContext method, bounded by TLSHandshakeTimeout.
 	DialTLSContext func(ctx context.Context, network, addr string) (TLSConn, error)
 
 	// DialTLS specifies an optional dial function for creating
@@ -130,14 +213,57 @@ type Transport struct {
 	// If both are set, DialTLSContext takes priority.
 	DialTLS func(network, addr string) (TLSConn, error)
 
+	// GetTLSConn optionally creates the TLS connection for HTTPS
+	// requests to addr, for instance to supply a TLSConn with a
+	// customized ClientHello for that host. It takes priority over
+	// DialTLSContext and DialTLS.
+	//
+	// The returned TLSConn need not have completed its handshake:
+	// the Transport drives HandshakeContext itself, bounded by
+	// TLSHandshakeTimeout and followed by the PinnedSPKIHashes check.
+	// Unlike DialTLSContext, setting GetTLSConn does not disable
+	// HTTP/2; if the connection negotiates "h2" via ALPN, it is used
+	// for HTTP/2.
+	GetTLSConn func(ctx context.Context, network, addr string) (TLSConn, error)
+
 	// TLSClientConfig specifies the TLS configuration to use with
 	// tls.Client.
 	// If nil, the default configuration is used.
 	// If non-nil, HTTP/2 support may not be enabled by default.
 	TLSClientConfig *tls.Config
 
+	// PinnedSPKIHashes optionally lists SHA-256 hashes of the
+	// DER-encoded SubjectPublicKeyInfo of trusted certificates.
+	// If non-empty, after each TLS handshake at least one certificate
+	// presented by the server must match one of the hashes, or the
+	// connection is closed and RoundTrip fails with
+	// ErrCertificatePinMismatch. The check applies in addition to the
+	// usual certificate verification configured by TLSClientConfig,
+	// and also to connections from DialTLSContext and DialTLS.
+	PinnedSPKIHashes [][]byte
+
+	// ServerNameOverride, if non-empty, is the server name used in
+	// TLS handshakes and certificate verification instead of the
+	// host being dialed or TLSClientConfig.ServerName.
+	ServerNameOverride string
+
+	// OmitSNI, if true, makes TLS handshakes send no server_name
+	// extension. The server's certificate is still verified against
+	// the name that would otherwise have been sent, unless
+	// TLSClientConfig.InsecureSkipVerify is set.
+	//
+	// ServerNameOverride and OmitSNI apply to handshakes with the
+	// origin server set up with TLSClientConfig. They do not apply to
+	// the handshake with an HTTPS proxy, nor to connections from
+	// GetTLSConn, DialTLSContext or DialTLS.
+	OmitSNI bool
+
 	// TLSHandshakeTimeout specifies the maximum amount of time to
-	// wait for a TLS handshake. Zero means no timeout.
+	// wait for a TLS handshake. It applies both to connections
+	// established with TLSClientConfig and to TLSConns returned by
+	// DialTLSContext or DialTLS, whose HandshakeContext is called with
+	// a context that is canceled when the timeout expires.
+	// Zero means no timeout.
 	TLSHandshakeTimeout time.Duration
 
 	// DisableKeepAlives, if true, disables HTTP keep-alives and
@@ -183,6 +309,7 @@ type Transport struct {
 	// time to wait for a server's response headers after fully
 	// writing the request (including its body, if any). This
 	// time does not include the time to read the response body.
+	// When it expires, RoundTrip fails with ErrResponseHeaderTimeout.
 	ResponseHeaderTimeout time.Duration
 
 	// ExpectContinueTimeout, if non-zero, specifies the amount of
@@ -194,6 +321,24 @@ type Transport struct {
 	// This time does not include the time to send the request header.
 	ExpectContinueTimeout time.Duration
 
+	// PhaseTimeouts bounds the phases of a request separately. Each
+	// non-zero timeout takes precedence over the corresponding
+	// DialTimeout, TLSHandshakeTimeout or ResponseHeaderTimeout, and
+	// makes RoundTrip, or reads of the response body, fail with a
+	// *PhaseTimeoutError naming the phase. They apply in addition to
+	// the deadline of the request context.
+	PhaseTimeouts PhaseTimeouts
+
+	// On1xxResponse, if non-nil, is called for each interim (1xx)
+	// response received before the final response, such as
+	// 100 Continue or 103 Early Hints. 101 Switching Protocols is
+	// a terminal status and is not reported here.
+	//
+	// The header must not be modified or retained after
+	// On1xxResponse returns. It is called in addition to any
+	// httptrace ClientTrace.Got1xxResponse hook.
+	On1xxResponse func(code int, header http.Header)
+
 	// TLSNextProto specifies how the Transport switches to an
 	// alternate protocol (such as HTTP/2) after a TLS ALPN
 	// protocol negotiation. If Transport dials a TLS connection
@@ -216,6 +361,40 @@ type Transport struct {
 	// Zero means to use a default limit.
 	MaxResponseHeaderBytes int64
 
+	// MaxStatusLineBytes, if positive, limits the length of an
+	// HTTP/1 response status line, not counting its CRLF. A longer
+	// status line fails the request with ErrStatusLineTooLong before
+	// the status code is parsed. The status line also counts toward
+	// MaxResponseHeaderBytes.
+	//
+	// Zero means no limit other than MaxResponseHeaderBytes.
+	MaxStatusLineBytes int
+
+	// StrictStatusCodes, if true, makes the Transport fail HTTP/1
+	// requests whose response status code is not a three-digit
+	// number from 100 to 599 with an *InvalidStatusCodeError. By
+	// default any three-digit code is accepted. An empty reason
+	// phrase is accepted either way.
+	StrictStatusCodes bool
+
+	// StrictBodylessResponses, if true, makes the Transport fail
+	// HTTP/1 requests whose response is a 204 No Content with a
+	// Transfer-Encoding or a non-zero Content-Length, with
+	// ErrNoContentBody. By default such a header is ignored: 1xx,
+	// 204 and 304 responses, like responses to HEAD, never have a
+	// body.
+	StrictBodylessResponses bool
+
+	// HeaderOrder, if non-empty, lists the field names in the order
+	// the Transport encodes them in the HEADERS frame of an HTTP/2
+	// request. Names are case-insensitive and may include the
+	// pseudo-header names ":authority", ":method", ":path", ":scheme"
+	// and ":protocol". Fields not listed follow the listed ones.
+	// Pseudo-headers always precede regular fields, as HTTP/2
+	// requires. WithHeaderOrder overrides it for a single request.
+	// HTTP/1 requests are not affected.
+	HeaderOrder []string
+
 	// WriteBufferSize specifies the size of the write buffer used
 	// when writing to the transport.
 	// If zero, a default (currently 4KB) is used.
@@ -242,15 +421,138 @@ type Transport struct {
 	// HTTP2 configures HTTP/2 connections.
 	HTTP2 *HTTP2Config
 
+	// HTTP2PerHost optionally specifies HTTP/2 configuration for
+	// connections to particular hosts. Keys are either a host
+	// ("example.com") or a host and port ("example.com:8443");
+	// a host:port entry takes precedence over a host entry.
+	//
+	// Non-zero fields of a matching entry override those in HTTP2.
+	// Hosts with no matching entry use HTTP2 alone.
+	HTTP2PerHost map[string]*HTTP2Config
+
+	// OnGoAway, if non-nil, is called when an HTTP/2 server sends a
+	// GOAWAY frame, before the connection is removed from the pool.
+	// host is the host:port of the connection. lastStreamID, errCode
+	// and debug are the corresponding fields of the frame; debug must
+	// not be retained after OnGoAway returns.
+	//
+	// OnGoAway is called from the connection's read loop and should
+	// not block.
+	OnGoAway func(host string, lastStreamID uint32, errCode uint32, debug []byte)
+
+	// OnResponseHeaderStats, if non-nil, is called by RoundTrip with
+	// the size of the final response header for each successful
+	// request, before RoundTrip returns. It does not include interim
+	// (1xx) responses or trailers.
+	OnResponseHeaderStats func(req *http.Request, stats ResponseHeaderStats)
+
 	// Protocols is the set of protocols supported by the transport.
 	//
 	// If Protocols includes UnencryptedHTTP2 and does not include HTTP1,
 	// the transport will use unencrypted HTTP/2 for requests for http:// URLs.
+	// Without UnencryptedHTTP2, here or in the result of ProtocolsForRequest,
+	// the transport refuses to send a request over unencrypted HTTP/2, even
+	// through an HTTP/2 transport registered for "http" with AllowHTTP set,
+	// and fails it with ErrUnencryptedHTTP2NotAllowed.
 	//
 	// If Protocols is nil, the default is usually HTTP/1 only.
 	// If ForceAttemptHTTP2 is true, or if TLSNextProto contains an "h2" entry,
 	// the default is HTTP/1 and HTTP/2.
 	Protocols *Protocols
+
+	// ProtocolsForRequest optionally chooses the protocols to use
+	// for a request, overriding Protocols for that request's
+	// connection selection. It is consulted before an existing
+	// connection is reused, and connections set up for different
+	// results are pooled separately.
+	//
+	// Only HTTP1, HTTP2 (for https URLs) and UnencryptedHTTP2 (for
+	// http URLs) are considered. A result without HTTP/2 forces
+	// HTTP/1; a result with HTTP/2 but without HTTP1 requires HTTP/2,
+	// failing the request if the server does not negotiate it.
+	// HTTP/2 must be enabled on the Transport, through Protocols or
+	// ForceAttemptHTTP2, for it to be selected.
+	//
+	// ProtocolsForRequest is called once for each call to RoundTrip,
+	// and its result holds for the whole request, including retries.
+	ProtocolsForRequest func(req *http.Request) Protocols
+
+	// MaxPipelineDepth, if greater than one, enables HTTP/1.1
+	// pipelining: up to MaxPipelineDepth requests may be in flight on
+	// a single connection, with responses read in the order the
+	// requests were written. Only requests without a body using GET,
+	// HEAD, OPTIONS or TRACE are pipelined; other requests only use
+	// connections with nothing in flight.
+	//
+	// A pipelined request waits for the responses ahead of it,
+	// including their bodies, so a slow response delays the others
+	// and counts against their ResponseHeaderTimeout. If the
+	// connection fails, all requests in flight on it fail.
+	//
+	// Zero or one means no pipelining.
+	MaxPipelineDepth int
+
+	// MaxRequestsPerConn, if positive, limits the number of requests
+	// sent on a single connection. The request reaching the limit is
+	// sent with "Connection: close" on HTTP/1, and the connection is
+	// closed once its response has been read; on HTTP/2, the
+	// connection takes no new requests and is closed once idle.
+	// Later requests use a new connection.
+	//
+	// Zero means no limit.
+	MaxRequestsPerConn int
+
+	// ConnMaxLifetime, if positive, is the maximum amount of time a
+	// connection may be reused for, counted from when it was dialed,
+	// regardless of activity. An HTTP/1 connection past it is closed
+	// when its current response has been read, instead of becoming
+	// idle; an HTTP/2 connection takes no new requests and is closed
+	// once idle. Idle connections past it are not reused.
+	//
+	// Zero means no limit.
+	ConnMaxLifetime time.Duration
+
+	// ProxyAuth, if non-nil, answers 407 Proxy Authentication
+	// Required responses to the CONNECT requests that set up
+	// tunnels through HTTP proxies: the CONNECT is sent once more
+	// with the Proxy-Authorization header it returns, such as with
+	// BasicAuth or DigestAuth, on the same connection unless the
+	// proxy closes it or sends a long 407 response body. When
+	// ProxyAuth is set, the first CONNECT is sent without
+	// credentials, even if the proxy URL has some, so that the proxy
+	// can tell which authentication scheme it wants.
+	ProxyAuth Authenticator
+
+	// Authenticator, if non-nil, answers 401 Unauthorized
+	// responses: the request is sent once more with the
+	// Authorization header it returns, such as with DigestAuth.
+	// Only idempotent requests that have no body or a GetBody are
+	// retried, as after network errors. If it is also a
+	// RequestAuthorizer, such as BearerAuth, requests without an
+	// Authorization header get one before they are sent.
+	Authenticator Authenticator
+
+	// AutoRetryThrottled, if positive, is the number of times a
+	// request is retried after a 429 Too Many Requests or 503
+	// Service Unavailable response with a Retry-After header, once
+	// the time the header asks for has passed. Only idempotent
+	// requests that have no body or a GetBody are retried, as after
+	// network errors, and only if the wait is at most MaxRetryAfter
+	// and ends before the request context's deadline; otherwise the
+	// response is returned.
+	AutoRetryThrottled int
+
+	// MaxRetryAfter is the longest Retry-After wait honored for
+	// AutoRetryThrottled. Zero means one minute.
+	MaxRetryAfter time.Duration
+}
+
+func (t *Transport) responseOptions() responseOptions {
+	return responseOptions{
+		maxStatusLine:  t.MaxStatusLineBytes,
+		strictStatus:   t.StrictStatusCodes,
+		strictBodyless: t.StrictBodylessResponses,
+	}
 }
 
 func (t *Transport) writeBufferSize() int {
@@ -274,34 +576,99 @@ func (t *Transport) maxHeaderResponseSize() int64 {
 	return 10 << 20 // conservative default; same as http2
 }
 
+// http2ConfigForAddr returns the HTTP2PerHost entry for addr,
+// which is a host:port, or nil if there is none.
+func (t *Transport) http2ConfigForAddr(addr string) *HTTP2Config {
+	if len(t.HTTP2PerHost) == 0 || addr == "" {
+		return nil
+	}
+	if conf, ok := t.HTTP2PerHost[addr]; ok {
+		return conf
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return t.HTTP2PerHost[host]
+}
+
 // Clone returns a deep copy of t's exported fields.
 func (t *Transport) Clone() *Transport {
 	t.nextProtoOnce.Do(t.onceSetNextProtoDefaults)
 	t2 := &Transport{
-		DialContext:            t.DialContext,
-		Dial:                   t.Dial,
-		DialTLS:                t.DialTLS,
-		DialTLSContext:         t.DialTLSContext,
-		TLSHandshakeTimeout:    t.TLSHandshakeTimeout,
-		DisableKeepAlives:      t.DisableKeepAlives,
-		DisableCompression:     t.DisableCompression,
-		MaxIdleConns:           t.MaxIdleConns,
-		MaxIdleConnsPerHost:    t.MaxIdleConnsPerHost,
-		MaxConnsPerHost:        t.MaxConnsPerHost,
-		IdleConnTimeout:        t.IdleConnTimeout,
-		ResponseHeaderTimeout:  t.ResponseHeaderTimeout,
-		ExpectContinueTimeout:  t.ExpectContinueTimeout,
-		MaxResponseHeaderBytes: t.MaxResponseHeaderBytes,
-		ForceAttemptHTTP2:      t.ForceAttemptHTTP2,
-		WriteBufferSize:        t.WriteBufferSize,
-		ReadBufferSize:         t.ReadBufferSize,
+		Proxy:                   t.Proxy,
+		ProxyConnectHeader:      t.ProxyConnectHeader.Clone(),
+		DialContext:             t.DialContext,
+		Dial:                    t.Dial,
+		DialTimeout:             t.DialTimeout,
+		DNSCache:                t.DNSCache,
+		HappyEyeballs:           t.HappyEyeballs,
+		HappyEyeballsDelay:      t.HappyEyeballsDelay,
+		DialTLS:                 t.DialTLS,
+		DialTLSContext:          t.DialTLSContext,
+		GetTLSConn:              t.GetTLSConn,
+		TLSHandshakeTimeout:     t.TLSHandshakeTimeout,
+		ServerNameOverride:      t.ServerNameOverride,
+		OmitSNI:                 t.OmitSNI,
+		DisableKeepAlives:       t.DisableKeepAlives,
+		DisableCompression:      t.DisableCompression,
+		MaxIdleConns:            t.MaxIdleConns,
+		MaxIdleConnsPerHost:     t.MaxIdleConnsPerHost,
+		MaxConnsPerHost:         t.MaxConnsPerHost,
+		IdleConnTimeout:         t.IdleConnTimeout,
+		ResponseHeaderTimeout:   t.ResponseHeaderTimeout,
+		ExpectContinueTimeout:   t.ExpectContinueTimeout,
+		PhaseTimeouts:           t.PhaseTimeouts,
+		On1xxResponse:           t.On1xxResponse,
+		OnGoAway:                t.OnGoAway,
+		OnResponseHeaderStats:   t.OnResponseHeaderStats,
+		MaxResponseHeaderBytes:  t.MaxResponseHeaderBytes,
+		MaxStatusLineBytes:      t.MaxStatusLineBytes,
+		StrictStatusCodes:       t.StrictStatusCodes,
+		StrictBodylessResponses: t.StrictBodylessResponses,
+		ForceAttemptHTTP2:       t.ForceAttemptHTTP2,
+		ProtocolsForRequest:     t.ProtocolsForRequest,
+		MaxPipelineDepth:        t.MaxPipelineDepth,
+		MaxRequestsPerConn:      t.MaxRequestsPerConn,
+		ConnMaxLifetime:         t.ConnMaxLifetime,
+		ProxyAuth:               t.ProxyAuth,
+		Authenticator:           t.Authenticator,
+		AutoRetryThrottled:      t.AutoRetryThrottled,
+		MaxRetryAfter:           t.MaxRetryAfter,
+		WriteBufferSize:         t.WriteBufferSize,
+		ReadBufferSize:          t.ReadBufferSize,
 	}
 	if t.TLSClientConfig != nil {
 		t2.TLSClientConfig = t.TLSClientConfig.Clone()
 	}
+	if t.PinnedSPKIHashes != nil {
+		t2.PinnedSPKIHashes = make([][]byte, len(t.PinnedSPKIHashes))
+		for i, pin := range t.PinnedSPKIHashes {
+			t2.PinnedSPKIHashes[i] = bytes.Clone(pin)
+		}
+	}
+	if t.HeaderOrder != nil {
+		t2.HeaderOrder = slices.Clone(t.HeaderOrder)
+	}
+	if t.SendProxyProtocol != nil {
+		p := *t.SendProxyProtocol
+		t2.SendProxyProtocol = &p
+	}
 	if t.HTTP2 != nil {
 		t2.HTTP2 = &HTTP2Config{}
 		*t2.HTTP2 = *t.HTTP2
+		t2.HTTP2.Settings = slices.Clone(t.HTTP2.Settings)
+	}
+	if t.HTTP2PerHost != nil {
+		t2.HTTP2PerHost = make(map[string]*HTTP2Config, len(t.HTTP2PerHost))
+		for host, conf := range t.HTTP2PerHost {
+			if conf != nil {
+				c := *conf
+				c.Settings = slices.Clone(conf.Settings)
+				conf = &c
+			}
+			t2.HTTP2PerHost[host] = conf
+		}
 	}
 	if t.Protocols != nil {
 		t2.Protocols = &Protocols{}
@@ -317,6 +684,33 @@ func (t *Transport) Clone() *Transport {
 	return t2
 }
 
+// ResponseHeaderStats describes the size of a response header, as
+// reported to Transport.OnResponseHeaderStats.
+//
+// Sizes are computed from the decoded header fields, so they are
+// comparable between HTTP/1 and HTTP/2 responses: HTTP/1 line
+// delimiters and HPACK compression are not counted, nor are the
+// status line and HTTP/2 pseudo-header fields.
+type ResponseHeaderStats struct {
+	// Count is the number of header field lines, counting each
+	// value of a multi-valued field separately.
+	Count int
+
+	// Bytes is the total length of the field names and values.
+	Bytes int64
+}
+
+func responseHeaderStats(h http.Header) ResponseHeaderStats {
+	var st ResponseHeaderStats
+	for k, vv := range h {
+		for _, v := range vv {
+			st.Count++
+			st.Bytes += int64(len(k) + len(v))
+		}
+	}
+	return st
+}
+
 // h2Transport is the interface we expect to be able to call from
 // net/http against an *http2.Transport that's either bundled into
 // h2_bundle.go or supplied by the user via x/net/http2.
@@ -329,7 +723,7 @@ type h2Transport interface {
 }
 
 func (t *Transport) hasCustomTLSDialer() bool {
-	return t.DialTLS != nil || t.DialTLSContext != nil
+	return t.GetTLSConn != nil || t.DialTLS != nil || t.DialTLSContext != nil
 }
 
 // onceSetNextProtoDefaults initializes TLSNextProto.
@@ -403,7 +797,7 @@ func (t *Transport) protocols() Protocols {
 		if t.TLSNextProto["h2"] != nil {
 			p.SetHTTP2(true)
 		}
-	case !t.ForceAttemptHTTP2 && (t.TLSClientConfig != nil || t.Dial != nil || t.DialContext != nil || t.hasCustomTLSDialer()):
+	case !t.ForceAttemptHTTP2 && (t.TLSClientConfig != nil || t.Dial != nil || t.DialContext != nil || t.DialTLS != nil || t.DialTLSContext != nil):
 		// Be conservative and don't automatically enable
 		// http2 if they've specified a custom TLS config or
 		// custom dialers. Let them opt-in themselves via
@@ -429,6 +823,15 @@ type transportRequest struct {
 
 	mu  sync.Mutex // guards err
 	err error      // first setError value for mapRoundTripError to consider
+
+	pipelined  bool // connection was taken by getPipelineConn
+	lastOnConn bool // request reached Transport.MaxRequestsPerConn
+
+	// wrote is set by writeLoop once any of the request has been
+	// written to the connection. It is tracked per request, rather
+	// than by comparing persistConn.nwrite, because with pipelining
+	// writeLoop may be writing later requests on the connection.
+	wrote atomic.Bool
 }
 
 func (tr *transportRequest) extraHeaders() http.Header {
@@ -449,7 +852,7 @@ func (tr *transportRequest) setError(err error) {
 // useRegisteredProtocol reports whether an alternate protocol (as registered
 // with Transport.RegisterProtocol) should be respected for this request.
 func (t *Transport) useRegisteredProtocol(req *http.Request) bool {
-	if req.URL.Scheme == "https" && requestRequiresHTTP1(req) {
+	if req.URL.Scheme == "https" && t.requestOnlyH1(req) {
 		// If this request requires HTTP/1, don't use the
 		// "https" alternate protocol, which is used by the
 		// HTTP/2 code to take over requests if there's an
@@ -470,9 +873,11 @@ func (t *Transport) alternateRoundTripper(req *http.Request) http.RoundTripper {
 	return altProto[req.URL.Scheme]
 }
 
-func validateHeaders(hdrs http.Header) string {
+// validateHeaders checks the header fields in hdrs, which may include
+// the :protocol pseudo-header if allowProtocol is set.
+func validateHeaders(hdrs http.Header, allowProtocol bool) string {
 	for k, vv := range hdrs {
-		if !httpguts.ValidHeaderFieldName(k) {
+		if !httpguts.ValidHeaderFieldName(k) && !(allowProtocol && k == ":protocol") {
 			return fmt.Sprintf("field name %q", k)
 		}
 		for _, v := range vv {
@@ -504,20 +909,24 @@ func (t *Transport) roundTrip(req *http.Request) (_ *http.Response, err error) {
 	isHTTP := scheme == "http" || scheme == "https"
 	if isHTTP {
 		// Validate the outgoing headers.
-		if err := validateHeaders(req.Header); err != "" {
+		if err := validateHeaders(req.Header, isExtendedConnect(req)); err != "" {
 			closeRequestBody(req)
 			return nil, fmt.Errorf("github.com/puernya/go-http: invalid header %s", err)
 		}
 
 		// Validate the outgoing trailers too.
-		if err := validateHeaders(req.Trailer); err != "" {
+		if err := validateHeaders(req.Trailer, false); err != "" {
 			closeRequestBody(req)
 			return nil, fmt.Errorf("github.com/puernya/go-http: invalid trailer %s", err)
 		}
 	}
 
 	origReq := req
+	if p := t.protocolsForRequest(req); p != nil {
+		req = req.WithContext(context.WithValue(req.Context(), requestProtocolsContextKey, *p))
+	}
 	req = setupRewindBody(req)
+	budget := contextByteBudget(ctx)
 
 	if altRT := t.alternateRoundTripper(req); altRT != nil {
 		if resp, err := altRT.RoundTrip(req); err != ErrSkipAltProtocol {
@@ -578,6 +987,11 @@ func (t *Transport) roundTrip(req *http.Request) (_ *http.Response, err error) {
 		default:
 		}
 
+		if err := budget.charge(requestHeadSize(req)); err != nil {
+			closeRequestBody(req)
+			return nil, err
+		}
+
 		// treq gets modified by roundTrip, so we need to recreate for each retry.
 		treq := &transportRequest{Request: req, trace: trace, ctx: ctx, cancel: cancel}
 		cm, err := t.connectMethodForRequest(treq)
@@ -600,6 +1014,10 @@ func (t *Transport) roundTrip(req *http.Request) (_ *http.Response, err error) {
 		if pconn.alt != nil {
 			// HTTP/2 path.
 			resp, err = pconn.alt.RoundTrip(req)
+		} else if isExtendedConnect(req) {
+			t.putOrCloseIdleConn(pconn)
+			closeRequestBody(req)
+			return nil, ErrExtendedConnectNotSupported
 		} else {
 			resp, err = pconn.roundTrip(treq)
 		}
@@ -613,6 +1031,26 @@ func (t *Transport) roundTrip(req *http.Request) (_ *http.Response, err error) {
 				cancel(errRequestDone)
 			}
 			resp.Request = origReq
+			if fn := t.OnResponseHeaderStats; fn != nil {
+				fn(origReq, responseHeaderStats(resp.Header))
+			}
+			if budget != nil {
+				if err := budget.charge(responseHeaderStats(resp.Header).Bytes); err != nil {
+					resp.Body.Close()
+					return nil, err
+				}
+				if !isProtocolSwitchResp(resp) {
+					resp.Body = &budgetBody{resp.Body, budget}
+				}
+			}
+			if !isProtocolSwitchResp(resp) {
+				if d := t.PhaseTimeouts.ResponseBody; d > 0 {
+					resp.Body = newDeadlineBody(resp.Body, time.Now().Add(d), &PhaseTimeoutError{Phase: PhaseResponseBody})
+				}
+				if d, ok := contextResponseBodyDeadline(ctx); ok {
+					resp.Body = newDeadlineBody(resp.Body, d, os.ErrDeadlineExceeded)
+				}
+			}
 			return resp, nil
 		}
 
@@ -630,6 +1068,11 @@ func (t *Transport) roundTrip(req *http.Request) (_ *http.Response, err error) {
 			if e, ok := err.(transportReadFromServerError); ok {
 				err = e.err
 			}
+			if budget.exceeded() {
+				// The request body crossed the budget, and the
+				// write failed because of it.
+				err = ErrByteBudgetExceeded
+			}
 			if b, ok := req.Body.(*readTrackingBody); ok && !b.didClose.Load() {
 				// Issue 49621: Close the request body if pconn.roundTrip
 				// didn't do so already. This can happen if the pconn
@@ -648,6 +1091,111 @@ func (t *Transport) roundTrip(req *http.Request) (_ *http.Response, err error) {
 	}
 }
 
+// roundTripAuthenticated is roundTripRetryThrottled, answering a 401
+// response with t.Authenticator.
+func (t *Transport) roundTripAuthenticated(req *http.Request) (*http.Response, error) {
+	if ra, ok := t.Authenticator.(RequestAuthorizer); ok && req.Header.Get("Authorization") == "" {
+		auth, err := ra.Authorize(req)
+		if err != nil {
+			closeRequestBody(req)
+			return nil, err
+		}
+		if auth != "" {
+			r := *req
+			r.Header = req.Header.Clone()
+			if r.Header == nil {
+				r.Header = make(http.Header)
+			}
+			r.Header.Set("Authorization", auth)
+			req = &r
+		}
+	}
+	resp, err := t.roundTripRetryThrottled(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !isReplayableRequest(req) {
+		return resp, err
+	}
+	auth, ok, err := t.Authenticator.Authenticate(req, resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if !ok {
+		return resp, nil
+	}
+	// Drain a short body so that the connection can be reused.
+	io.CopyN(io.Discard, resp.Body, 4<<10)
+	resp.Body.Close()
+
+	r, err := replayRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Authorization", auth)
+	return t.roundTripRetryThrottled(r)
+}
+
+// replayRequest returns a copy of req with its own Header, and a body
+// from GetBody, to send req again.
+func replayRequest(req *http.Request) (*http.Request, error) {
+	r := *req
+	r.Header = req.Header.Clone()
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	return &r, nil
+}
+
+// roundTripRetryThrottled is roundTrip, retrying the request as
+// described for Transport.AutoRetryThrottled.
+func (t *Transport) roundTripRetryThrottled(req *http.Request) (*http.Response, error) {
+	replayable := isReplayableRequest(req)
+	ctx := req.Context()
+	for retries := 0; ; retries++ {
+		resp, err := t.roundTrip(req)
+		if err != nil || !replayable || retries >= t.AutoRetryThrottled {
+			return resp, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+			return resp, nil
+		}
+		wait, ok := ParseRetryAfter(resp, time.Now())
+		if !ok || wait > t.maxRetryAfter() {
+			return resp, nil
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return resp, nil
+		}
+		// Drain a short body so that the connection can be reused.
+		io.CopyN(io.Discard, resp.Body, 4<<10)
+		resp.Body.Close()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, context.Cause(ctx)
+		case <-timer.C:
+		}
+		if req, err = replayRequest(req); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *Transport) maxRetryAfter() time.Duration {
+	if t.MaxRetryAfter > 0 {
+		return t.MaxRetryAfter
+	}
+	return time.Minute
+}
+
 func awaitLegacyCancel(ctx context.Context, cancel context.CancelCauseFunc, req *http.Request) {
 	select {
 	case <-req.Cancel:
@@ -662,11 +1210,16 @@ type readTrackingBody struct {
 	io.ReadCloser
 	didRead  bool // not atomic.Bool because only one goroutine (the user's) should be accessing
 	didClose atomic.Bool
+	budget   *byteBudget // see WithByteBudget; may be nil
 }
 
 func (r *readTrackingBody) Read(data []byte) (int, error) {
 	r.didRead = true
-	return r.ReadCloser.Read(data)
+	n, err := r.ReadCloser.Read(data)
+	if berr := r.budget.charge(int64(n)); berr != nil {
+		return n, berr
+	}
+	return n, err
 }
 
 func (r *readTrackingBody) Close() error {
@@ -685,7 +1238,7 @@ func setupRewindBody(req *http.Request) *http.Request {
 		return req
 	}
 	newReq := *req
-	newReq.Body = &readTrackingBody{ReadCloser: req.Body}
+	newReq.Body = &readTrackingBody{ReadCloser: req.Body, budget: contextByteBudget(req.Context())}
 	return &newReq
 }
 
@@ -708,7 +1261,7 @@ func rewindBody(req *http.Request) (rewound *http.Request, err error) {
 		return nil, err
 	}
 	newReq := *req
-	newReq.Body = &readTrackingBody{ReadCloser: body}
+	newReq.Body = &readTrackingBody{ReadCloser: body, budget: contextByteBudget(req.Context())}
 	return &newReq, nil
 }
 
@@ -848,6 +1401,32 @@ func (t *Transport) CloseIdleConnections() {
 	}
 }
 
+// ErrNoHTTP2Conn is returned by PingHost when the transport has no
+// HTTP/2 connection to the host.
+var ErrNoHTTP2Conn = errors.New("http: no HTTP/2 connection to host")
+
+// PingHost sends an HTTP/2 PING frame on an existing connection to
+// host and returns the round-trip time. host is a host or host:port;
+// if the port is omitted, connections to port 443 and, for
+// unencrypted HTTP/2, port 80 are considered. Connections dialed for
+// any WithConnLabel label are considered as well.
+//
+// PingHost does not dial. It returns ErrNoHTTP2Conn if the transport
+// has no HTTP/2 connection to host that can take a request, or an
+// error if ctx is done before the PING is acknowledged.
+func (t *Transport) PingHost(ctx context.Context, host string) (time.Duration, error) {
+	t.nextProtoOnce.Do(t.onceSetNextProtoDefaults)
+	t2, ok := t.h2transport.(*http2Transport)
+	if !ok {
+		return 0, ErrNoHTTP2Conn
+	}
+	addrs := []string{http2authorityAddr("https", host)}
+	if addr := http2authorityAddr("http", host); addr != addrs[0] {
+		addrs = append(addrs, addr)
+	}
+	return t2.pingHost(ctx, addrs)
+}
+
 // prepareTransportCancel sets up state to convert Transport.CancelRequest into context cancelation.
 func (t *Transport) prepareTransportCancel(req *http.Request, origCancel context.CancelCauseFunc) context.CancelCauseFunc {
 	// Historically, RoundTrip has not modified the Request in any way.
@@ -886,6 +1465,23 @@ func (t *Transport) CancelRequest(req *http.Request) {
 	}
 }
 
+var connLabelContextKey = &contextKey{"conn-label"}
+
+// WithConnLabel returns a copy of ctx that makes Transport send a
+// request made with it only on connections dialed for requests with
+// the same label. Connections are pooled separately for each label,
+// for HTTP/1 and HTTP/2 alike, so requests with different labels, or
+// with and without one, never share a connection. The empty label is
+// the same as no label.
+func WithConnLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, connLabelContextKey, label)
+}
+
+func connLabel(ctx context.Context) string {
+	label, _ := ctx.Value(connLabelContextKey).(string)
+	return label
+}
+
 //
 // Private implementation past this point.
 //
@@ -893,10 +1489,80 @@ func (t *Transport) CancelRequest(req *http.Request) {
 func (t *Transport) connectMethodForRequest(treq *transportRequest) (cm connectMethod, err error) {
 	cm.targetScheme = treq.URL.Scheme
 	cm.targetAddr = canonicalAddr(treq.URL)
-	cm.onlyH1 = requestRequiresHTTP1(treq.Request)
+	cm.onlyH1 = t.requestOnlyH1(treq.Request)
+	cm.label = connLabel(treq.ctx)
+	if t.Proxy != nil {
+		cm.proxyURL, err = t.Proxy(treq.Request)
+		if err == nil && cm.proxyURL != nil {
+			switch cm.proxyURL.Scheme {
+			case "":
+				u := *cm.proxyURL
+				u.Scheme = "http"
+				cm.proxyURL = &u
+			case "http", "https", "socks5", "socks5h":
+			default:
+				err = badStringError("unsupported proxy scheme", cm.proxyURL.Scheme)
+			}
+		}
+	}
+	if p := t.protocolsForRequest(treq.Request); !cm.onlyH1 && p != nil {
+		if cm.targetScheme == "https" {
+			cm.onlyH2 = p.HTTP2() && !p.HTTP1()
+		} else {
+			cm.onlyH2 = p.UnencryptedHTTP2() && !p.HTTP1()
+		}
+	}
 	return cm, err
 }
 
+var requestProtocolsContextKey = &contextKey{"request-protocols"}
+
+// protocolsForRequest returns the result of t.ProtocolsForRequest for
+// req, or nil if it is not set. roundTrip calls the hook once and
+// records its result in the request's context, so that every decision
+// made for the request sees the same answer.
+func (t *Transport) protocolsForRequest(req *http.Request) *Protocols {
+	if p, ok := req.Context().Value(requestProtocolsContextKey).(Protocols); ok {
+		return &p
+	}
+	if t.ProtocolsForRequest == nil {
+		return nil
+	}
+	p := t.ProtocolsForRequest(req)
+	return &p
+}
+
+// requestOnlyH1 reports whether req must be sent over HTTP/1,
+// either because of its own requirements or because
+// t.ProtocolsForRequest excludes HTTP/2 for it.
+func (t *Transport) requestOnlyH1(req *http.Request) bool {
+	if requestRequiresHTTP1(req) {
+		return true
+	}
+	p := t.protocolsForRequest(req)
+	if p == nil {
+		return false
+	}
+	if req.URL.Scheme == "https" {
+		return !p.HTTP2()
+	}
+	return !p.UnencryptedHTTP2()
+}
+
+// ErrUnencryptedHTTP2NotAllowed is returned when a request would be
+// sent over unencrypted HTTP/2 (h2c) although neither Protocols nor
+// ProtocolsForRequest includes UnencryptedHTTP2.
+var ErrUnencryptedHTTP2NotAllowed = errors.New("http: unencrypted HTTP/2 not enabled by Transport.Protocols")
+
+// unencryptedHTTP2Allowed reports whether req may be sent over
+// unencrypted HTTP/2.
+func (t *Transport) unencryptedHTTP2Allowed(req *http.Request) bool {
+	if p := t.protocolsForRequest(req); p != nil && p.UnencryptedHTTP2() {
+		return true
+	}
+	return t.Protocols != nil && t.Protocols.UnencryptedHTTP2()
+}
+
 // error values for debugging and testing, not seen by users.
 var (
 	errKeepAlivesDisabled = errors.New("http: putIdleConn: keep alives disabled")
@@ -907,6 +1573,7 @@ var (
 	errCloseIdleConns     = errors.New("http: CloseIdleConnections called")
 	errReadLoopExiting    = errors.New("http: persistConn.readLoop exiting")
 	errIdleConnTimeout    = errors.New("http: idle connection timeout")
+	errConnLifetime       = errors.New("http: connection exceeded ConnMaxLifetime")
 
 	// errServerClosedIdle is not seen by users for idempotent requests, but may be
 	// seen by a user if the server shuts down an idle connection and sends its FIN
@@ -958,6 +1625,9 @@ func (t *Transport) tryPutIdleConn(pconn *persistConn) error {
 	if pconn.isBroken() {
 		return errConnBroken
 	}
+	if pconn.pastLifetime() {
+		return errConnLifetime
+	}
 	pconn.markReused()
 	if pconn.isClientConn {
 		// internalStateHook is always set for conns created by NewClientConn.
@@ -1098,7 +1768,8 @@ func (t *Transport) queueForIdleConn(w *wantConn) (delivered bool) {
 			// See whether this connection has been idle too long, considering
 			// only the wall time (the Round(0)), in case this is a laptop or VM
 			// coming out of suspend with previously cached idle connections.
-			tooOld := !oldTime.IsZero() && pconn.idleAt.Round(0).Before(oldTime)
+			tooOld := !oldTime.IsZero() && pconn.idleAt.Round(0).Before(oldTime) ||
+				pconn.pastLifetime()
 			if tooOld {
 				// Async cleanup. Launch in its own goroutine (as if a
 				// time.AfterFunc called it); it acquires idleMu, which we're
@@ -1156,9 +1827,104 @@ func (t *Transport) removeIdleConn(pconn *persistConn) bool {
 	}
 	t.idleMu.Lock()
 	defer t.idleMu.Unlock()
+	t.removePipelineConnLocked(pconn)
 	return t.removeIdleConnLocked(pconn)
 }
 
+// canPipeline reports whether req may be pipelined behind other
+// requests on an HTTP/1 connection.
+func (t *Transport) canPipeline(req *http.Request) bool {
+	if t.MaxPipelineDepth <= 1 || t.DisableKeepAlives {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		return false
+	}
+	switch valueOrDefault(req.Method, "GET") {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+	default:
+		return false
+	}
+	return !requestWantsClose(req) && !isProtocolSwitchHeader(req.Header)
+}
+
+// getPipelineConn returns a connection for key that has pipelined
+// requests in flight and room for one more, or nil. The returned
+// connection already counts the caller's request as expected.
+func (t *Transport) getPipelineConn(key connectMethodKey) *persistConn {
+	t.idleMu.Lock()
+	defer t.idleMu.Unlock()
+	for _, pc := range t.pipelineConns[key] {
+		pc.mu.Lock()
+		ok := pc.closed == nil && pc.numExpectedResponses < t.MaxPipelineDepth &&
+			(t.MaxRequestsPerConn <= 0 || pc.numRequests < t.MaxRequestsPerConn) &&
+			!pc.pastLifetime()
+		if ok {
+			pc.numExpectedResponses++
+			pc.numRequests++
+			pc.reused = true
+		}
+		pc.mu.Unlock()
+		if ok {
+			return pc
+		}
+	}
+	return nil
+}
+
+// addPipelineConn makes pc available to getPipelineConn after a
+// pipelinable request was written on it, unless all its responses
+// have already been read.
+func (t *Transport) addPipelineConn(pc *persistConn) {
+	t.idleMu.Lock()
+	defer t.idleMu.Unlock()
+	pc.mu.Lock()
+	busy := pc.numExpectedResponses > 0 && pc.closed == nil
+	pc.mu.Unlock()
+	if !busy || slices.Contains(t.pipelineConns[pc.cacheKey], pc) {
+		return
+	}
+	if t.pipelineConns == nil {
+		t.pipelineConns = make(map[connectMethodKey][]*persistConn)
+	}
+	t.pipelineConns[pc.cacheKey] = append(t.pipelineConns[pc.cacheKey], pc)
+}
+
+// t.idleMu must be held.
+func (t *Transport) removePipelineConnLocked(pc *persistConn) {
+	key := pc.cacheKey
+	pconns := slices.DeleteFunc(t.pipelineConns[key], func(v *persistConn) bool { return v == pc })
+	if len(pconns) == 0 {
+		delete(t.pipelineConns, key)
+	} else {
+		t.pipelineConns[key] = pconns
+	}
+}
+
+// pastLifetime reports whether pc is older than
+// Transport.ConnMaxLifetime.
+func (pc *persistConn) pastLifetime() bool {
+	d := pc.t.ConnMaxLifetime
+	return d > 0 && !pc.createdAt.IsZero() && time.Since(pc.createdAt.Round(0)) > d
+}
+
+// pipelineBusy reports whether pipelined responses are still expected
+// on pc, in which case pc must not be returned to the idle pool yet.
+// Otherwise it stops further requests from being pipelined on pc.
+func (pc *persistConn) pipelineBusy() bool {
+	t := pc.t
+	t.idleMu.Lock()
+	defer t.idleMu.Unlock()
+	pc.mu.Lock()
+	n := pc.numExpectedResponses
+	pc.mu.Unlock()
+	if n > 0 {
+		return true
+	}
+	t.removePipelineConnLocked(pc)
+	return false
+}
+
 // t.idleMu must be held.
 func (t *Transport) removeIdleConnLocked(pconn *persistConn) bool {
 	if pconn.idleTimer != nil {
@@ -1194,7 +1960,150 @@ func (t *Transport) removeIdleConnLocked(pconn *persistConn) bool {
 
 var zeroDialer net.Dialer
 
-func (t *Transport) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+func (t *Transport) dial(ctx context.Context, network, addr string) (c net.Conn, err error) {
+	ctx, cancel := t.dialTimeoutContext(ctx)
+	defer cancel()
+	defer func() { err = phaseTimeoutCause(ctx, err) }()
+	if t.DNSCache != nil || t.HappyEyeballs {
+		return t.dialResolved(ctx, network, addr)
+	}
+	return t.dialAddr(ctx, network, addr)
+}
+
+// dialResolved resolves the host of addr, with t.DNSCache if set, and
+// dials the resulting addresses.
+func (t *Transport) dialResolved(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return t.dialAddr(ctx, network, addr)
+	}
+	var ips []string
+	if t.DNSCache != nil {
+		ips, err = t.DNSCache.LookupHost(ctx, host)
+	} else {
+		ips, err = net.DefaultResolver.LookupHost(ctx, host)
+	}
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	if t.HappyEyeballs && len(ips) > 1 {
+		return t.dialHappyEyeballs(ctx, network, port, ips)
+	}
+	var firstErr error
+	for _, ip := range ips {
+		c, err := t.dialAddr(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return c, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if firstErr == nil {
+		firstErr = &net.OpError{Op: "dial", Net: network, Err: &net.AddrError{Err: "no suitable address found", Addr: host}}
+	}
+	return nil, firstErr
+}
+
+// dialHappyEyeballs races dials to ips, as described for
+// Transport.HappyEyeballs.
+func (t *Transport) dialHappyEyeballs(ctx context.Context, network, port string, ips []string) (net.Conn, error) {
+	ips = interleaveAddrFamilies(ips)
+	delay := t.HappyEyeballsDelay
+	if delay <= 0 {
+		delay = 250 * time.Millisecond
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	results := make(chan result, len(ips))
+	next, pending := 0, 0
+	start := func() {
+		addr := net.JoinHostPort(ips[next], port)
+		next++
+		pending++
+		go func() {
+			c, err := t.dialAddr(ctx, network, addr)
+			results <- result{c, err}
+		}()
+	}
+	start()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var firstErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// Close the connections of attempts that still succeed.
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if r := <-results; r.c != nil {
+							r.c.Close()
+						}
+					}
+				}(pending)
+				return r.c, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+		case <-timer.C:
+		}
+		if next < len(ips) && ctx.Err() == nil {
+			start()
+			timer.Reset(delay)
+		}
+	}
+	return nil, firstErr
+}
+
+// interleaveAddrFamilies reorders ips to alternate between IPv6 and
+// IPv4 addresses, starting with the family of the first one and
+// otherwise keeping their order.
+func interleaveAddrFamilies(ips []string) []string {
+	var first, second []string
+	firstIs4 := false
+	for i, ip := range ips {
+		a, _ := netip.ParseAddr(ip)
+		is4 := a.Unmap().Is4()
+		if i == 0 {
+			firstIs4 = is4
+		}
+		if is4 == firstIs4 {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+	out := make([]string, 0, len(ips))
+	for len(first) > 0 || len(second) > 0 {
+		if len(first) > 0 {
+			out = append(out, first[0])
+			first = first[1:]
+		}
+		if len(second) > 0 {
+			out = append(out, second[0])
+			second = second[1:]
+		}
+	}
+	return out
+}
+
+// dialAddr dials addr with the Transport's dial hooks, or the default
+// dialer.
+func (t *Transport) dialAddr(ctx context.Context, network, addr string) (net.Conn, error) {
 	if t.DialContext != nil {
 		c, err := t.DialContext(ctx, network, addr)
 		if c == nil && err == nil {
@@ -1389,7 +2298,29 @@ func (q *wantConnQueue) all(f func(*wantConn)) {
 	}
 }
 
+// dialTimeoutContext returns a context for a single dial, bounded by
+// t.PhaseTimeouts.Connect or else t.DialTimeout if set.
+func (t *Transport) dialTimeoutContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d := t.PhaseTimeouts.Connect; d > 0 {
+		return context.WithTimeoutCause(ctx, d, &PhaseTimeoutError{Phase: PhaseConnect})
+	}
+	if t.DialTimeout > 0 {
+		return context.WithTimeout(ctx, t.DialTimeout)
+	}
+	return ctx, func() {}
+}
+
 func (t *Transport) customDialTLS(ctx context.Context, network, addr string) (conn TLSConn, err error) {
+	ctx, cancel := t.dialTimeoutContext(ctx)
+	defer cancel()
+	defer func() { err = phaseTimeoutCause(ctx, err) }()
+	if t.GetTLSConn != nil {
+		conn, err = t.GetTLSConn(ctx, network, addr)
+		if conn == nil && err == nil {
+			err = errors.New("http: Transport.GetTLSConn returned (nil, nil)")
+		}
+		return
+	}
 	if t.DialTLSContext != nil {
 		conn, err = t.DialTLSContext(ctx, network, addr)
 	} else {
@@ -1413,6 +2344,16 @@ func (t *Transport) getConn(treq *transportRequest, cm connectMethod) (_ *persis
 		trace.GetConn(cm.addr())
 	}
 
+	if t.canPipeline(req) {
+		if pc := t.getPipelineConn(cm.key()); pc != nil {
+			treq.pipelined = true
+			if trace != nil && trace.GotConn != nil {
+				trace.GotConn(httptrace.GotConnInfo{Conn: pc.conn, Reused: true})
+			}
+			return pc, nil
+		}
+	}
+
 	// Detach from the request context's cancellation signal.
 	// The dial should proceed even if the request is canceled,
 	// because a future request may be able to make use of the connection.
@@ -1603,41 +2544,34 @@ func (t *Transport) decConnsPerHost(key connectMethodKey) {
 // Add TLS to a persistent connection, i.e. negotiate a TLS session. If pconn is already a TLS
 // tunnel, this function establishes a nested TLS session inside the encrypted channel.
 // The remote endpoint's name may be overridden by TLSClientConfig.ServerName.
-func (pconn *persistConn) addTLS(ctx context.Context, name string, trace *httptrace.ClientTrace) error {
+//
+// toProxy reports whether the remote endpoint is an HTTPS proxy rather
+// than the origin server; Transport.ServerNameOverride and OmitSNI only
+// apply to the origin.
+func (pconn *persistConn) addTLS(ctx context.Context, name string, toProxy bool, trace *httptrace.ClientTrace) error {
 	// Initiate TLS and check remote host name against certificate.
 	cfg := cloneTLSConfig(pconn.t.TLSClientConfig)
+	if pconn.t.ServerNameOverride != "" && !toProxy {
+		cfg.ServerName = pconn.t.ServerNameOverride
+	}
 	if cfg.ServerName == "" {
 		cfg.ServerName = name
 	}
+	if pconn.t.OmitSNI && !toProxy {
+		omitSNI(cfg)
+	}
 	if pconn.cacheKey.onlyH1 {
 		cfg.NextProtos = nil
+	} else if pconn.cacheKey.onlyH2 {
+		cfg.NextProtos = []string{"h2"}
 	}
 	plainConn := pconn.conn
 	tlsConn := tls.Client(plainConn, cfg)
-	errc := make(chan error, 2)
-	var timer *time.Timer // for canceling TLS handshake
-	if d := pconn.t.TLSHandshakeTimeout; d != 0 {
-		timer = time.AfterFunc(d, func() {
-			errc <- tlsHandshakeTimeoutError{}
-		})
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
 	}
-	go func() {
-		if trace != nil && trace.TLSHandshakeStart != nil {
-			trace.TLSHandshakeStart()
-		}
-		err := tlsConn.HandshakeContext(ctx)
-		if timer != nil {
-			timer.Stop()
-		}
-		errc <- err
-	}()
-	if err := <-errc; err != nil {
+	if err := pconn.t.handshakeTLS(ctx, tlsConn); err != nil {
 		plainConn.Close()
-		if err == (tlsHandshakeTimeoutError{}) {
-			// Now that we have closed the connection,
-			// wait for the call to HandshakeContext to return.
-			<-errc
-		}
 		if trace != nil && trace.TLSHandshakeDone != nil {
 			trace.TLSHandshakeDone(tls.ConnectionState{}, err)
 		}
@@ -1652,6 +2586,64 @@ func (pconn *persistConn) addTLS(ctx context.Context, name string, trace *httptr
 	return nil
 }
 
+// handshakeTLS runs the TLS handshake on tc, bounded by
+// t.TLSHandshakeTimeout if set, and then checks t.PinnedSPKIHashes.
+// On timeout the context passed to HandshakeContext is canceled and the
+// underlying connection is closed, in case tc does not observe
+// cancellation, and the returned error is a tlsHandshakeTimeoutError,
+// or a *PhaseTimeoutError for t.PhaseTimeouts.TLSHandshake.
+func (t *Transport) handshakeTLS(ctx context.Context, tc TLSConn) error {
+	if err := t.handshakeTLSTimeout(ctx, tc); err != nil {
+		return err
+	}
+	return t.verifyPinnedSPKI(tc.ConnectionState())
+}
+
+func (t *Transport) handshakeTLSTimeout(ctx context.Context, tc TLSConn) error {
+	d, timeoutErr := t.TLSHandshakeTimeout, error(tlsHandshakeTimeoutError{})
+	if pd := t.PhaseTimeouts.TLSHandshake; pd > 0 {
+		d, timeoutErr = pd, &PhaseTimeoutError{Phase: PhaseTLSHandshake}
+	}
+	if d <= 0 {
+		return tc.HandshakeContext(ctx)
+	}
+	ctx, cancel := context.WithTimeoutCause(ctx, d, timeoutErr)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		if context.Cause(ctx) == timeoutErr {
+			if nc := tc.NetConn(); nc != nil {
+				nc.Close()
+			}
+		}
+	})
+	err := tc.HandshakeContext(ctx)
+	if !stop() && context.Cause(ctx) == timeoutErr {
+		return timeoutErr
+	}
+	return err
+}
+
+// ErrCertificatePinMismatch is returned by RoundTrip when none of the
+// certificates presented by the server matches Transport.PinnedSPKIHashes.
+var ErrCertificatePinMismatch = errors.New("http: server certificate does not match any pinned SPKI hash")
+
+// verifyPinnedSPKI checks the peer certificates in cs against
+// t.PinnedSPKIHashes.
+func (t *Transport) verifyPinnedSPKI(cs tls.ConnectionState) error {
+	if len(t.PinnedSPKIHashes) == 0 {
+		return nil
+	}
+	for _, cert := range cs.PeerCertificates {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range t.PinnedSPKIHashes {
+			if bytes.Equal(sum[:], pin) {
+				return nil
+			}
+		}
+	}
+	return ErrCertificatePinMismatch
+}
+
 type erringRoundTripper interface {
 	RoundTripErr() error
 }
@@ -1660,64 +2652,125 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod, isClientConn
 	pconn = &persistConn{
 		t:                 t,
 		cacheKey:          cm.key(),
-		reqch:             make(chan requestAndChan, 1),
+		reqch:             make(chan requestAndChan, max(1, t.MaxPipelineDepth)),
 		writech:           make(chan writeRequest, 1),
 		closech:           make(chan struct{}),
-		writeErrCh:        make(chan error, 1),
+		writeErrCh:        make(chan error, max(1, t.MaxPipelineDepth)),
 		writeLoopDone:     make(chan struct{}),
 		isClientConn:      isClientConn,
 		internalStateHook: internalStateHook,
+		createdAt:         time.Now(),
 	}
 	trace := httptrace.ContextClientTrace(ctx)
 	wrapErr := func(err error) error {
 		return err
 	}
-	if cm.scheme() == "https" && t.hasCustomTLSDialer() {
-		tc, err := t.customDialTLS(ctx, "tcp", cm.addr())
-		if err != nil {
-			return nil, wrapErr(err)
-		}
-		// Handshake here, in case DialTLS didn't. TLSNextProto below
-		// depends on it for knowing the connection state.
-		if trace != nil && trace.TLSHandshakeStart != nil {
-			trace.TLSHandshakeStart()
-		}
-		if err := tc.HandshakeContext(ctx); err != nil {
-			go tc.Close()
-			go tc.NetConn().Close()
+	// dialFirstHop connects pconn to the proxy, or to the target if
+	// there is no proxy. connectProxyTunnel calls it again if it
+	// needs a new connection to the proxy.
+	dialFirstHop := func() error {
+		if cm.scheme() == "https" && t.hasCustomTLSDialer() {
+			tc, err := t.customDialTLS(ctx, "tcp", cm.addr())
+			if err != nil {
+				return wrapErr(err)
+			}
+			// Handshake here, in case DialTLS didn't. TLSNextProto below
+			// depends on it for knowing the connection state.
+			if trace != nil && trace.TLSHandshakeStart != nil {
+				trace.TLSHandshakeStart()
+			}
+			if err := t.handshakeTLS(ctx, tc); err != nil {
+				go tc.Close()
+				go tc.NetConn().Close()
+				if trace != nil && trace.TLSHandshakeDone != nil {
+					trace.TLSHandshakeDone(tls.ConnectionState{}, err)
+				}
+				return err
+			}
+			cs := tc.ConnectionState()
 			if trace != nil && trace.TLSHandshakeDone != nil {
-				trace.TLSHandshakeDone(tls.ConnectionState{}, err)
+				trace.TLSHandshakeDone(cs, nil)
+			}
+			pconn.conn = tc
+			pconn.tlsState = &cs
+		} else {
+			conn, err := t.dial(ctx, "tcp", cm.addr())
+			if err != nil {
+				return wrapErr(err)
+			}
+			if p := t.SendProxyProtocol; p != nil {
+				if err := writeProxyHeader(conn, p); err != nil {
+					conn.Close()
+					return wrapErr(err)
+				}
+			}
+			pconn.conn = conn
+			if cm.scheme() == "https" {
+				var firstTLSHost string
+				if firstTLSHost, _, err = net.SplitHostPort(cm.addr()); err != nil {
+					return wrapErr(err)
+				}
+				if err = pconn.addTLS(ctx, firstTLSHost, cm.proxyURL != nil, trace); err != nil {
+					return wrapErr(err)
+				}
 			}
-			return nil, err
-		}
-		cs := tc.ConnectionState()
-		if trace != nil && trace.TLSHandshakeDone != nil {
-			trace.TLSHandshakeDone(cs, nil)
 		}
-		pconn.conn = tc
-		pconn.tlsState = &cs
-	} else {
-		conn, err := t.dial(ctx, "tcp", cm.addr())
+		return nil
+	}
+	if err := dialFirstHop(); err != nil {
+		return nil, err
+	}
+
+	// Proxy setup.
+	switch {
+	case cm.proxyURL == nil:
+		// Do nothing. Not using a proxy.
+	case cm.proxyURL.Scheme == "socks5" || cm.proxyURL.Scheme == "socks5h":
+		conn, err := socksTunnel(ctx, pconn.conn, cm)
 		if err != nil {
-			return nil, wrapErr(err)
+			pconn.conn.Close()
+			return nil, err
 		}
 		pconn.conn = conn
-		if cm.scheme() == "https" {
-			var firstTLSHost string
-			if firstTLSHost, _, err = net.SplitHostPort(cm.addr()); err != nil {
-				return nil, wrapErr(err)
+		if cm.targetScheme == "https" {
+			if err := pconn.addTLS(ctx, cm.tlsHost(), false, trace); err != nil {
+				return nil, err
+			}
+		}
+	case cm.targetScheme == "http":
+		pconn.isProxy = true
+		if pa := cm.proxyAuth(); pa != "" {
+			pconn.mutateHeaderFunc = func(h http.Header) {
+				h.Set("Proxy-Authorization", pa)
 			}
-			if err = pconn.addTLS(ctx, firstTLSHost, trace); err != nil {
-				return nil, wrapErr(err)
+		}
+	case cm.targetScheme == "https":
+		redial := func() (net.Conn, error) {
+			if err := dialFirstHop(); err != nil {
+				return nil, err
 			}
+			return pconn.conn, nil
+		}
+		if err := t.connectProxyTunnel(ctx, pconn.conn, cm, redial); err != nil {
+			pconn.conn.Close()
+			return nil, err
+		}
+		if err := pconn.addTLS(ctx, cm.tlsHost(), false, trace); err != nil {
+			return nil, err
 		}
 	}
 
+	if cm.onlyH2 && pconn.tlsState != nil && pconn.tlsState.NegotiatedProtocol != "h2" {
+		pconn.conn.Close()
+		return nil, errors.New("http: ProtocolsForRequest requires HTTP/2 but server did not negotiate h2")
+	}
+
 	// Possible unencrypted HTTP/2 with prior knowledge.
-	unencryptedHTTP2 := pconn.tlsState == nil &&
-		t.Protocols != nil &&
-		t.Protocols.UnencryptedHTTP2() &&
-		!t.Protocols.HTTP1()
+	unencryptedHTTP2 := pconn.tlsState == nil && !pconn.isProxy &&
+		(cm.onlyH2 || t.Protocols != nil &&
+			t.Protocols.UnencryptedHTTP2() &&
+			!t.Protocols.HTTP1() &&
+			!cm.onlyH1)
 
 	if isClientConn && (unencryptedHTTP2 || (pconn.tlsState != nil && pconn.tlsState.NegotiatedProtocol == "h2")) {
 		altProto, _ := t.altProto.Load().(map[string]http.RoundTripper)
@@ -1730,11 +2783,11 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod, isClientConn
 			pconn.conn.Close()
 			return nil, err
 		}
-		return &persistConn{t: t, cacheKey: pconn.cacheKey, alt: alt, isClientConn: true}, nil
+		return &persistConn{t: t, cacheKey: pconn.cacheKey, createdAt: pconn.createdAt, alt: alt, isClientConn: true}, nil
 	}
 
 	if unencryptedHTTP2 {
-		next, ok := t.TLSNextProto[nextProtoUnencryptedHTTP2]
+		next, ok := t.nextProtoFunc(cm, nextProtoUnencryptedHTTP2)
 		if !ok {
 			return nil, errors.New("http: Transport does not support unencrypted HTTP/2")
 		}
@@ -1743,17 +2796,17 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod, isClientConn
 			// pconn.conn was closed by next (http2configureTransports.upgradeFn).
 			return nil, e.RoundTripErr()
 		}
-		return &persistConn{t: t, cacheKey: pconn.cacheKey, alt: alt}, nil
+		return &persistConn{t: t, cacheKey: pconn.cacheKey, createdAt: pconn.createdAt, alt: alt}, nil
 	}
 
 	if s := pconn.tlsState; s != nil && s.NegotiatedProtocolIsMutual && s.NegotiatedProtocol != "" {
-		if next, ok := t.TLSNextProto[s.NegotiatedProtocol]; ok {
-			alt := next(cm.targetAddr, pconn.conn.(*tls.Conn))
+		if next, ok := t.nextProtoFunc(cm, s.NegotiatedProtocol); ok {
+			alt := next(cm.targetAddr, pconn.conn.(TLSConn))
 			if e, ok := alt.(erringRoundTripper); ok {
 				// pconn.conn was closed by next (http2configureTransports.upgradeFn).
 				return nil, e.RoundTripErr()
 			}
-			return &persistConn{t: t, cacheKey: pconn.cacheKey, alt: alt}, nil
+			return &persistConn{t: t, cacheKey: pconn.cacheKey, createdAt: pconn.createdAt, alt: alt}, nil
 		}
 	}
 
@@ -1765,6 +2818,160 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod, isClientConn
 	return pconn, nil
 }
 
+// nextProtoFunc returns the TLSNextProto function to take over a
+// connection for cm that negotiated proto. Labeled HTTP/2 connections
+// are handed to the bundled HTTP/2 transport directly, so that they
+// are pooled by label.
+func (t *Transport) nextProtoFunc(cm connectMethod, proto string) (func(authority string, c TLSConn) http.RoundTripper, bool) {
+	t2, ok := t.h2transport.(*http2Transport)
+	if cm.label == "" || !ok || (proto != "h2" && proto != nextProtoUnencryptedHTTP2) {
+		next, ok := t.TLSNextProto[proto]
+		return next, ok
+	}
+	return func(authority string, c TLSConn) http.RoundTripper {
+		if proto == "h2" {
+			return t2.upgradeConn("https", authority, cm.label, c)
+		}
+		nc, err := http2unencryptedNetConnFromTLSConn(c)
+		if err != nil {
+			go c.Close()
+			return http2erringRoundTripper{err}
+		}
+		return t2.upgradeConn("http", authority, cm.label, nc)
+	}, true
+}
+
+// socksTunnel performs a SOCKS5 handshake on conn, a connection to
+// the proxy of cm, to connect it to cm.targetAddr.
+func socksTunnel(ctx context.Context, conn net.Conn, cm connectMethod) (net.Conn, error) {
+	var auth *proxy.Auth
+	if u := cm.proxyURL.User; u != nil {
+		auth = &proxy.Auth{User: u.Username()}
+		auth.Password, _ = u.Password()
+	}
+	d, err := proxy.SOCKS5("tcp", cm.addr(), auth, connDialer{conn})
+	if err != nil {
+		return nil, err
+	}
+	return d.(proxy.ContextDialer).DialContext(ctx, "tcp", cm.targetAddr)
+}
+
+// connDialer is a proxy.Dialer that returns an already dialed
+// connection.
+type connDialer struct{ c net.Conn }
+
+func (d connDialer) Dial(network, addr string) (net.Conn, error) { return d.c, nil }
+
+func (d connDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.c, nil
+}
+
+// maxProxyAuthDrain is how much of a 407 response body
+// connectProxyTunnel discards to send the authenticated CONNECT on the
+// same connection.
+const maxProxyAuthDrain = 4 << 10
+
+// connectProxyTunnel sends a CONNECT request for cm.targetAddr to the
+// proxy on conn and reads its response, which must have a 2xx status.
+// If t.ProxyAuth answers a 407 response and conn cannot be reused,
+// conn is closed and the authenticated CONNECT is sent on the
+// connection redial returns.
+func (t *Transport) connectProxyTunnel(ctx context.Context, conn net.Conn, cm connectMethod, redial func() (net.Conn, error)) error {
+	hdr := t.ProxyConnectHeader
+	if hdr == nil {
+		hdr = make(http.Header)
+	}
+	if pa := cm.proxyAuth(); pa != "" && t.ProxyAuth == nil {
+		hdr = hdr.Clone()
+		hdr.Set("Proxy-Authorization", pa)
+	}
+	connectReq := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: cm.targetAddr},
+		Host:   cm.targetAddr,
+		Header: hdr,
+	}
+
+	// Set a (long) timeout here to make sure we don't block forever
+	// and leak a goroutine if the connection stops replying after
+	// the TCP connect.
+	connectCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+
+	// Okay to use and discard buffered reader here, because
+	// TLS server will not speak until spoken to.
+	br := bufio.NewReader(conn)
+	resp, err := t.roundTripConnect(connectCtx, conn, br, connectReq)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusProxyAuthRequired && t.ProxyAuth != nil {
+		auth, ok, err := t.ProxyAuth.Authenticate(connectReq, resp)
+		if err != nil {
+			return err
+		}
+		if ok {
+			// The CONNECT is sent again on the same connection if
+			// the 407 response body can be read entirely, and on a
+			// new one otherwise.
+			stop := context.AfterFunc(connectCtx, func() { conn.SetReadDeadline(aLongTimeAgo) })
+			n, err := io.CopyN(io.Discard, resp.Body, maxProxyAuthDrain+1)
+			if !stop() {
+				conn.Close()
+				return connectCtx.Err()
+			}
+			if resp.Close || err != io.EOF || n > maxProxyAuthDrain {
+				conn.Close()
+				if conn, err = redial(); err != nil {
+					return err
+				}
+				br = bufio.NewReader(conn)
+			}
+			connectReq.Header = hdr.Clone()
+			connectReq.Header.Set("Proxy-Authorization", auth)
+			if resp, err = t.roundTripConnect(connectCtx, conn, br, connectReq); err != nil {
+				return err
+			}
+		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		_, text, ok := strings.Cut(resp.Status, " ")
+		if !ok {
+			return errors.New("unknown status code")
+		}
+		return errors.New(text)
+	}
+	return nil
+}
+
+// roundTripConnect writes the CONNECT request connectReq to conn and
+// reads the response from br, giving up when ctx is done.
+func (t *Transport) roundTripConnect(ctx context.Context, conn net.Conn, br *bufio.Reader, connectReq *http.Request) (*http.Response, error) {
+	didReadResponse := make(chan struct{}) // closed after CONNECT write+read is done or fails
+	var (
+		resp *http.Response
+		err  error // write or read error
+	)
+	// Write the CONNECT request & read the response.
+	go func() {
+		defer close(didReadResponse)
+		err = connectReq.Write(conn)
+		if err != nil {
+			return
+		}
+		resp, err = readResponse(br, connectReq, t.responseOptions())
+	}()
+	select {
+	case <-ctx.Done():
+		conn.Close()
+		<-didReadResponse
+		return nil, ctx.Err()
+	case <-didReadResponse:
+		// resp or err now set
+	}
+	return resp, err
+}
+
 // persistConnWriter is the io.Writer written to by pc.bw.
 // It accumulates the number of bytes written to the underlying conn,
 // so the retry logic can determine whether any bytes made it across
@@ -1810,39 +3017,88 @@ var _ io.ReaderFrom = (*persistConnWriter)(nil)
 //	https://proxy.com|http            https to proxy, http to anywhere after that
 type connectMethod struct {
 	_            incomparable
-	targetScheme string // "http" or "https"
+	proxyURL     *url.URL // nil for no proxy, else full proxy URL
+	targetScheme string   // "http" or "https"
 	// If proxyURL specifies an http or https proxy, and targetScheme is http (not https),
 	// then targetAddr is not included in the connect method key, because the socket can
 	// be reused for different targetAddr values.
 	targetAddr string
-	onlyH1     bool // whether to disable HTTP/2 and force HTTP/1
+	onlyH1     bool   // whether to disable HTTP/2 and force HTTP/1
+	onlyH2     bool   // whether to require HTTP/2 (h2c with prior knowledge for http)
+	label      string // from WithConnLabel; segments the connection pool
+}
+
+// proxyAuth returns the Proxy-Authorization header to set
+// on requests, if applicable.
+func (cm *connectMethod) proxyAuth() string {
+	if cm.proxyURL == nil {
+		return ""
+	}
+	if u := cm.proxyURL.User; u != nil {
+		username := u.Username()
+		password, _ := u.Password()
+		return "Basic " + basicAuth(username, password)
+	}
+	return ""
+}
+
+func basicAuth(username, password string) string {
+	auth := username + ":" + password
+	return base64.StdEncoding.EncodeToString([]byte(auth))
 }
 
 func (cm *connectMethod) key() connectMethodKey {
+	proxyStr := ""
 	targetAddr := cm.targetAddr
+	if cm.proxyURL != nil {
+		proxyStr = cm.proxyURL.String()
+		if (cm.proxyURL.Scheme == "http" || cm.proxyURL.Scheme == "https") && cm.targetScheme == "http" {
+			targetAddr = ""
+		}
+	}
 	return connectMethodKey{
+		proxy:  proxyStr,
 		scheme: cm.targetScheme,
 		addr:   targetAddr,
 		onlyH1: cm.onlyH1,
+		onlyH2: cm.onlyH2,
+		label:  cm.label,
 	}
 }
 
 // scheme returns the first hop scheme: http, https, or socks5
 func (cm *connectMethod) scheme() string {
+	if cm.proxyURL != nil {
+		return cm.proxyURL.Scheme
+	}
 	return cm.targetScheme
 }
 
 // addr returns the first hop "host:port" to which we need to TCP connect.
 func (cm *connectMethod) addr() string {
+	if cm.proxyURL != nil {
+		return canonicalAddr(cm.proxyURL)
+	}
 	return cm.targetAddr
 }
 
+// tlsHost returns the host name to match against the peer's
+// TLS certificate.
+func (cm *connectMethod) tlsHost() string {
+	h := cm.targetAddr
+	if host, _, err := net.SplitHostPort(h); err == nil {
+		h = host
+	}
+	return h
+}
+
 // connectMethodKey is the map key version of connectMethod, with a
 // stringified proxy URL (or the empty string) instead of a pointer to
 // a URL.
 type connectMethodKey struct {
 	proxy, scheme, addr string
-	onlyH1              bool
+	onlyH1, onlyH2      bool
+	label               string
 }
 
 func (k connectMethodKey) String() string {
@@ -1850,6 +3106,11 @@ func (k connectMethodKey) String() string {
 	var h1 string
 	if k.onlyH1 {
 		h1 = ",h1"
+	} else if k.onlyH2 {
+		h1 = ",h2"
+	}
+	if k.label != "" {
+		return fmt.Sprintf("%s|%s%s|%s|%s", k.proxy, k.scheme, h1, k.addr, k.label)
 	}
 	return fmt.Sprintf("%s|%s%s|%s", k.proxy, k.scheme, h1, k.addr)
 }
@@ -1865,10 +3126,11 @@ type persistConn struct {
 	t            *Transport
 	cacheKey     connectMethodKey
 	conn         net.Conn
+	isProxy      bool // whether requests are sent to an HTTP proxy in absolute form
 	tlsState     *tls.ConnectionState
 	br           *bufio.Reader       // from conn
 	bw           *bufio.Writer       // to conn
-	nwrite       int64               // bytes written
+	nwrite       int64               // bytes written; accessed only by writeLoop
 	reqch        chan requestAndChan // written by roundTrip; read by readLoop
 	writech      chan writeRequest   // written by roundTrip; read by writeLoop
 	closech      chan struct{}       // closed when conn closed
@@ -1884,12 +3146,17 @@ type persistConn struct {
 
 	writeLoopDone chan struct{} // closed when write loop ends
 
+	sendMu sync.Mutex // orders sends on writech and reqch in roundTrip
+
+	createdAt time.Time // when the connection was dialed; see ConnMaxLifetime
+
 	// Both guarded by Transport.idleMu:
 	idleAt    time.Time   // time it last become idle
 	idleTimer *time.Timer // holding an AfterFunc to close it
 
 	mu                   sync.Mutex // guards following fields
 	numExpectedResponses int
+	numRequests          int    // requests sent or reserved, for MaxRequestsPerConn
 	closed               error  // set non-nil when conn is closed, before closech is closed
 	canceledErr          error  // set non-nil if conn is canceled
 	reused               bool   // whether conn has had successful request/response and is being reused.
@@ -1974,9 +3241,9 @@ func (pc *persistConn) closeConnIfStillIdle() {
 // The provided err is the first error that (*persistConn).roundTrip
 // happened to receive from its select statement.
 //
-// The startBytesWritten value should be the value of pc.nwrite before the roundTrip
-// started writing the request.
-func (pc *persistConn) mapRoundTripError(req *transportRequest, startBytesWritten int64, err error) error {
+// Whether any of the request reached the connection is taken from
+// req.wrote, as recorded by writeLoop.
+func (pc *persistConn) mapRoundTripError(req *transportRequest, err error) error {
 	if err == nil {
 		return nil
 	}
@@ -2011,14 +3278,14 @@ func (pc *persistConn) mapRoundTripError(req *transportRequest, startBytesWritte
 	}
 
 	if _, ok := err.(transportReadFromServerError); ok {
-		if pc.nwrite == startBytesWritten {
+		if !req.wrote.Load() {
 			return nothingWrittenError{err}
 		}
 		// Don't decorate
 		return err
 	}
 	if pc.isBroken() {
-		if pc.nwrite == startBytesWritten {
+		if !req.wrote.Load() {
 			return nothingWrittenError{err}
 		}
 		return fmt.Errorf("github.com/puernya/go-http: HTTP/1.x transport connection broken: %w", err)
@@ -2112,7 +3379,7 @@ func (pc *persistConn) readLoop() {
 		bodyWritable := isResponseBodyWritable(resp)
 		hasBody := rc.treq.Request.Method != "HEAD" && resp.ContentLength != 0
 
-		if resp.Close || rc.treq.Request.Close || resp.StatusCode <= 199 || bodyWritable {
+		if resp.Close || rc.treq.Request.Close || rc.treq.lastOnConn || resp.StatusCode <= 199 || bodyWritable {
 			// Don't do keep-alive on error if either party requested a close
 			// or we get an unexpected informational (1xx) response.
 			// StatusCode 100 is already handled above.
@@ -2128,7 +3395,7 @@ func (pc *persistConn) readLoop() {
 			alive = alive &&
 				!pc.sawEOF &&
 				pc.wroteRequest() &&
-				tryPutIdleConn(rc.treq)
+				(pc.pipelineBusy() || tryPutIdleConn(rc.treq))
 
 			if bodyWritable {
 				closeErr = errCallerOwnsConn
@@ -2195,7 +3462,7 @@ func (pc *persistConn) readLoop() {
 				bodyEOF &&
 				!pc.sawEOF &&
 				pc.wroteRequest() &&
-				tryPutIdleConn(rc.treq)
+				(pc.pipelineBusy() || tryPutIdleConn(rc.treq))
 			if bodyEOF {
 				eofc <- struct{}{}
 			}
@@ -2257,7 +3524,7 @@ func (pc *persistConn) readResponse(rc requestAndChan, trace *httptrace.ClientTr
 
 	continueCh := rc.continueCh
 	for {
-		resp, err = http.ReadResponse(pc.br, rc.treq.Request)
+		resp, err = readResponse(pc.br, rc.treq.Request, pc.t.responseOptions())
 		if err != nil {
 			return
 		}
@@ -2273,6 +3540,9 @@ func (pc *persistConn) readResponse(rc requestAndChan, trace *httptrace.ClientTr
 		// treat 101 as a terminal status, see issue 26161
 		is1xxNonTerminal := is1xx && resCode != http.StatusSwitchingProtocols
 		if is1xxNonTerminal {
+			if fn := pc.t.On1xxResponse; fn != nil {
+				fn(resCode, resp.Header)
+			}
 			if trace != nil && trace.Got1xxResponse != nil {
 				if err := trace.Got1xxResponse(resCode, textproto.MIMEHeader(resp.Header)); err != nil {
 					return nil, err
@@ -2320,8 +3590,10 @@ func (pc *persistConn) readResponse(rc requestAndChan, trace *httptrace.ClientTr
 // waitForContinue returns the function to block until
 // any response, timeout or connection close. After any of them,
 // the function returns a bool which indicates if the body should be sent.
+// It returns nil, to send the body right away, if the request does not
+// expect 100-continue or ExpectContinueTimeout is zero.
 func (pc *persistConn) waitForContinue(continueCh <-chan struct{}) func() bool {
-	if continueCh == nil {
+	if continueCh == nil || pc.t.ExpectContinueTimeout <= 0 {
 		return nil
 	}
 	return func() bool {
@@ -2372,6 +3644,13 @@ func (b *readWriteCloserBody) Read(p []byte) (n int, err error) {
 	return b.ReadWriteCloser.Read(p)
 }
 
+// Stream implements Streamer, so that a 101 Switching Protocols
+// response's Body can be used directly as a duplex Stream over the
+// connection.
+func (b *readWriteCloserBody) Stream() Stream {
+	return b
+}
+
 func (b *readWriteCloserBody) CloseWrite() error {
 	if cw, ok := b.ReadWriteCloser.(interface{ CloseWrite() error }); ok {
 		return cw.CloseWrite()
@@ -2394,7 +3673,7 @@ func (pc *persistConn) writeLoop() {
 		select {
 		case wr := <-pc.writech:
 			startBytesWritten := pc.nwrite
-			err := requestWrite(wr.req.Request, pc.bw, false, wr.req.extra, pc.waitForContinue(wr.continueCh))
+			err := requestWrite(wr.req.Request, pc.bw, pc.isProxy, wr.req.extra, pc.waitForContinue(wr.continueCh))
 			var ok bool
 			if err, ok = checkRequestBodyError(err); ok {
 				// Errors reading from the user's
@@ -2409,10 +3688,10 @@ func (pc *persistConn) writeLoop() {
 			if err == nil {
 				err = pc.bw.Flush()
 			}
-			if err != nil {
-				if pc.nwrite == startBytesWritten {
-					err = nothingWrittenError{err}
-				}
+			if pc.nwrite != startBytesWritten {
+				wr.req.wrote.Store(true)
+			} else if err != nil {
+				err = nothingWrittenError{err}
 			}
 			pc.writeErrCh <- err // to the body reader, which might recycle us
 			wr.ch <- err         // to the roundTrip function
@@ -2516,7 +3795,11 @@ func (e *timeoutError) Timeout() bool     { return true }
 func (e *timeoutError) Temporary() bool   { return true }
 func (e *timeoutError) Is(err error) bool { return err == context.DeadlineExceeded }
 
-var errTimeout error = &timeoutError{"github.com/puernya/go-http: timeout awaiting response headers"}
+// ErrResponseHeaderTimeout is returned by RoundTrip when the response
+// headers do not arrive within Transport.ResponseHeaderTimeout of
+// writing the request. It implements net.Error, and matches
+// context.DeadlineExceeded with errors.Is.
+var ErrResponseHeaderTimeout error = &timeoutError{"github.com/puernya/go-http: timeout awaiting response headers"}
 
 // errRequestCanceled is set to be identical to the one from h2 to facilitate
 // testing.
@@ -2569,7 +3852,13 @@ func (pc *persistConn) roundTrip(req *transportRequest) (resp *http.Response, er
 		pc.reserved = false
 		pc.inFlight = true
 	}
-	pc.numExpectedResponses++
+	if !req.pipelined {
+		pc.numExpectedResponses++
+		pc.numRequests++
+	}
+	if max := pc.t.MaxRequestsPerConn; max > 0 && pc.numRequests >= max {
+		req.lastOnConn = true
+	}
 	headerFn := pc.mutateHeaderFunc
 	pc.mu.Unlock()
 
@@ -2607,7 +3896,7 @@ func (pc *persistConn) roundTrip(req *transportRequest) (resp *http.Response, er
 		continueCh = make(chan struct{}, 1)
 	}
 
-	if pc.t.DisableKeepAlives &&
+	if (pc.t.DisableKeepAlives || req.lastOnConn) &&
 		!requestWantsClose(req.Request) &&
 		!isProtocolSwitchHeader(req.Header) {
 		req.extraHeaders().Set("Connection", "close")
@@ -2621,7 +3910,9 @@ func (pc *persistConn) roundTrip(req *transportRequest) (resp *http.Response, er
 	// Write the request concurrently with waiting for a response,
 	// in case the server decides to reply before reading our full
 	// request body.
-	startBytesWritten := pc.nwrite
+	// With pipelining, several requests may be sent at once; sendMu
+	// keeps the writeLoop and readLoop queues in the same order.
+	pc.sendMu.Lock()
 	writeErrCh := make(chan error, 1)
 	pc.writech <- writeRequest{req, writeErrCh, continueCh}
 
@@ -2633,6 +3924,8 @@ func (pc *persistConn) roundTrip(req *transportRequest) (resp *http.Response, er
 		continueCh: continueCh,
 		callerGone: gone,
 	}
+	pc.sendMu.Unlock()
+	pipelinable := !pc.isClientConn && pc.t.canPipeline(req.Request)
 
 	handleResponse := func(re responseAndError) (*http.Response, error) {
 		if (re.res == nil) == (re.err == nil) {
@@ -2642,12 +3935,13 @@ func (pc *persistConn) roundTrip(req *transportRequest) (resp *http.Response, er
 			req.logf("resc recv: %p, %T/%#v", re.res, re.err, re.err)
 		}
 		if re.err != nil {
-			return nil, pc.mapRoundTripError(req, startBytesWritten, re.err)
+			return nil, pc.mapRoundTripError(req, re.err)
 		}
 		return re.res, nil
 	}
 
 	var respHeaderTimer <-chan time.Time
+	var respHeaderTimeoutErr error
 	ctxDoneChan := req.ctx.Done()
 	pcClosed := pc.closech
 	for {
@@ -2659,15 +3953,19 @@ func (pc *persistConn) roundTrip(req *transportRequest) (resp *http.Response, er
 			}
 			if err != nil {
 				pc.close(fmt.Errorf("write error: %w", err))
-				return nil, pc.mapRoundTripError(req, startBytesWritten, err)
+				return nil, pc.mapRoundTripError(req, err)
+			}
+			if pipelinable {
+				pc.t.addPipelineConn(pc)
 			}
-			if d := pc.t.ResponseHeaderTimeout; d > 0 {
+			if d, err := pc.t.responseHeaderTimeout(); d > 0 {
 				if debugRoundTrip {
 					req.logf("starting timer for %v", d)
 				}
 				timer := time.NewTimer(d)
 				defer timer.Stop() // prevent leaks
 				respHeaderTimer = timer.C
+				respHeaderTimeoutErr = err
 			}
 		case <-pcClosed:
 			select {
@@ -2681,13 +3979,13 @@ func (pc *persistConn) roundTrip(req *transportRequest) (resp *http.Response, er
 			if debugRoundTrip {
 				req.logf("closech recv: %T %#v", pc.closed, pc.closed)
 			}
-			return nil, pc.mapRoundTripError(req, startBytesWritten, pc.closed)
+			return nil, pc.mapRoundTripError(req, pc.closed)
 		case <-respHeaderTimer:
 			if debugRoundTrip {
 				req.logf("timeout waiting for response headers.")
 			}
-			pc.close(errTimeout)
-			return nil, errTimeout
+			pc.close(respHeaderTimeoutErr)
+			return nil, respHeaderTimeoutErr
 		case re := <-resc:
 			return handleResponse(re)
 		case <-ctxDoneChan:
@@ -2965,6 +4263,45 @@ type fakeLocker struct{}
 
 func (fakeLocker) Lock()   {}
 func (fakeLocker) Unlock() {}
+
+// omitSNI modifies cfg so that the handshake sends no server_name
+// extension while the server's certificate is still verified against
+// cfg.ServerName, unless cfg.InsecureSkipVerify was already set.
+func omitSNI(cfg *tls.Config) {
+	name := cfg.ServerName
+	cfg.ServerName = ""
+	if cfg.InsecureSkipVerify {
+		return
+	}
+	// crypto/tls only omits SNI when ServerName is empty, and then
+	// requires InsecureSkipVerify. Verify the chain ourselves instead.
+	cfg.InsecureSkipVerify = true
+	roots, now, next := cfg.RootCAs, cfg.Time, cfg.VerifyConnection
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("http: server presented no certificates")
+		}
+		opts := x509.VerifyOptions{
+			DNSName:       name,
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+		}
+		if now != nil {
+			opts.CurrentTime = now()
+		}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+			return err
+		}
+		if next != nil {
+			return next(cs)
+		}
+		return nil
+	}
+}
+
 func cloneTLSConfig(cfg *tls.Config) *tls.Config {
 	if cfg == nil {
 		return &tls.Config{}
@@ -0,0 +1,1453 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	neturl "net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+func TestTransportOn1xxResponse(t *testing.T) {
+	addr := newRawServer(t, func(c net.Conn) {
+		if _, err := readRawRequest(bufio.NewReader(c)); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n"+
+			"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	})
+	type interim struct {
+		code int
+		link string
+	}
+	var got []interim
+	tr := &Transport{On1xxResponse: func(code int, header http.Header) {
+		got = append(got, interim{code, header.Get("Link")})
+	}}
+	defer tr.CloseIdleConnections()
+	req, _ := http.NewRequest("GET", "http://"+addr+"/", nil)
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != 200 || string(body) != "ok" {
+		t.Errorf("got %d %q; want 200 \"ok\"", res.StatusCode, body)
+	}
+	want := interim{103, "</style.css>; rel=preload"}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("On1xxResponse calls = %v; want [%v]", got, want)
+	}
+}
+
+func TestTransportHTTP2PerHost(t *testing.T) {
+	frameSizes := make(chan uint32, 2)
+	onFrame := func(fr *http2Framer, f http2Frame) bool {
+		sf, ok := f.(*http2SettingsFrame)
+		if !ok || sf.IsAck() {
+			return true
+		}
+		v, _ := sf.Value(http2SettingMaxFrameSize)
+		frameSizes <- v
+		return false
+	}
+	addr1 := newH2CaptureServer(t, onFrame)
+	addr2 := newH2CaptureServer(t, onFrame)
+	tr := &Transport{
+		Protocols: h2cProtocols(),
+		HTTP2:     &HTTP2Config{MaxReadFrameSize: 1 << 16},
+		HTTP2PerHost: map[string]*HTTP2Config{
+			addr2: {MaxReadFrameSize: 1 << 20},
+		},
+	}
+	defer tr.CloseIdleConnections()
+	for _, tt := range []struct {
+		addr string
+		want uint32
+	}{
+		{addr1, 1 << 16},
+		{addr2, 1 << 20},
+	} {
+		req, _ := http.NewRequest("GET", "http://"+tt.addr+"/", nil)
+		go func() {
+			if res, err := tr.RoundTrip(req); err == nil {
+				res.Body.Close()
+			}
+		}()
+		if got := <-frameSizes; got != tt.want {
+			t.Errorf("%s: SETTINGS_MAX_FRAME_SIZE = %d; want %d", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestTransportPingHost(t *testing.T) {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), func(s *Server) {
+		s.Protocols = h2cProtocols()
+	})
+	tr := &Transport{Protocols: h2cProtocols()}
+	defer tr.CloseIdleConnections()
+	host := url[len("http://"):]
+	if _, err := tr.PingHost(context.Background(), host); !errors.Is(err, ErrNoHTTP2Conn) {
+		t.Errorf("PingHost without a connection = %v; want ErrNoHTTP2Conn", err)
+	}
+	res, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.ProtoMajor != 2 {
+		t.Fatalf("Proto = %q; want HTTP/2.0", res.Proto)
+	}
+	rtt, err := tr.PingHost(context.Background(), host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtt <= 0 {
+		t.Errorf("rtt = %v; want > 0", rtt)
+	}
+}
+
+func TestTransportPingHostLabeledAndDefaultPort(t *testing.T) {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), func(s *Server) {
+		s.Protocols = h2cProtocols()
+	})
+	// Requests to example.test, on the default port 80, reach the
+	// test server.
+	tr := &Transport{
+		Protocols: h2cProtocols(),
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, url[len("http://"):])
+		},
+	}
+	defer tr.CloseIdleConnections()
+	req := mustNewRequest(t, "GET", "http://example.test/", nil)
+	req = req.WithContext(WithConnLabel(req.Context(), "a"))
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	for _, host := range []string{"example.test", "example.test:80"} {
+		if _, err := tr.PingHost(context.Background(), host); err != nil {
+			t.Errorf("PingHost(%q) = %v", host, err)
+		}
+	}
+	if _, err := tr.PingHost(context.Background(), "example.test:443"); !errors.Is(err, ErrNoHTTP2Conn) {
+		t.Errorf("PingHost on another port = %v; want ErrNoHTTP2Conn", err)
+	}
+}
+
+func TestTransportOnGoAway(t *testing.T) {
+	type goAway struct {
+		host   string
+		lastID uint32
+		code   uint32
+		debug  string
+	}
+	addr := newH2CaptureServer(t, func(fr *http2Framer, f http2Frame) bool {
+		if _, ok := f.(*http2HeadersFrame); ok {
+			fr.WriteGoAway(0, http2ErrCodeEnhanceYourCalm, []byte("slow down"))
+			return false
+		}
+		return true
+	})
+	got := make(chan goAway, 1)
+	tr := &Transport{
+		Protocols: h2cProtocols(),
+		OnGoAway: func(host string, lastID, code uint32, debug []byte) {
+			got <- goAway{host, lastID, code, string(debug)}
+		},
+	}
+	defer tr.CloseIdleConnections()
+	if res, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://"+addr+"/", nil)); err == nil {
+		res.Body.Close()
+	}
+	want := goAway{addr, 0, uint32(http2ErrCodeEnhanceYourCalm), "slow down"}
+	if g := <-got; g != want {
+		t.Errorf("OnGoAway(%+v); want %+v", g, want)
+	}
+}
+
+func TestTransportDialTimeout(t *testing.T) {
+	tr := &Transport{
+		DialTimeout: 50 * time.Millisecond,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Error("dial context has no deadline")
+			}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	start := time.Now()
+	_, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://example.com/", nil))
+	if err == nil {
+		t.Fatal("RoundTrip succeeded")
+	}
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("err = %v; want a timeout", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("RoundTrip took %v", d)
+	}
+}
+
+func TestTransportTLSHandshakeTimeout(t *testing.T) {
+	addr := newRawServer(t, func(c net.Conn) {
+		io.Copy(io.Discard, c) // never answer the ClientHello
+	})
+	tr := &Transport{TLSHandshakeTimeout: 50 * time.Millisecond}
+	start := time.Now()
+	_, err := tr.RoundTrip(mustNewRequest(t, "GET", "https://"+addr+"/", nil))
+	if err == nil {
+		t.Fatal("RoundTrip succeeded")
+	}
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("err = %v; want a timeout", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("RoundTrip took %v", d)
+	}
+}
+
+func TestTransportPinnedSPKIHashes(t *testing.T) {
+	cert, pool := newTestCert(t)
+	_, url := newTLSTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), cert)
+	pin := sha256.Sum256(cert.Leaf.RawSubjectPublicKeyInfo)
+	other := sha256.Sum256([]byte("other key"))
+	tests := []struct {
+		pins    [][]byte
+		wantErr error
+	}{
+		{[][]byte{pin[:]}, nil},
+		{[][]byte{other[:], pin[:]}, nil},
+		{[][]byte{other[:]}, ErrCertificatePinMismatch},
+	}
+	for _, tt := range tests {
+		tr := &Transport{
+			TLSClientConfig:  &tls.Config{RootCAs: pool},
+			PinnedSPKIHashes: tt.pins,
+		}
+		res, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil))
+		if err == nil {
+			res.Body.Close()
+		}
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%d pins: err = %v; want %v", len(tt.pins), err, tt.wantErr)
+		}
+		tr.CloseIdleConnections()
+	}
+}
+
+func TestTransportGetTLSConn(t *testing.T) {
+	cert, pool := newTestCert(t, "example.com")
+	_, url := newTLSTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), cert)
+	var gotAddr string
+	tr := &Transport{
+		GetTLSConn: func(ctx context.Context, network, addr string) (TLSConn, error) {
+			gotAddr = addr
+			c, err := new(net.Dialer).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return tls.Client(c, &tls.Config{
+				ServerName: "example.com",
+				RootCAs:    pool,
+				NextProtos: []string{"h2", "http/1.1"},
+			}), nil
+		},
+	}
+	defer tr.CloseIdleConnections()
+	res, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if want := url[len("https://"):]; gotAddr != want {
+		t.Errorf("GetTLSConn addr = %q; want %q", gotAddr, want)
+	}
+	if res.ProtoMajor != 2 {
+		t.Errorf("Proto = %q; want HTTP/2.0", res.Proto)
+	}
+}
+
+func TestTransportServerNameOverride(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		gotSNIs []string
+	)
+	cert, _ := newTestCert(t, "example.com")
+	_, url := newTLSTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), cert, func(s *Server) {
+		s.TLSConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			mu.Lock()
+			gotSNIs = append(gotSNIs, hello.ServerName)
+			mu.Unlock()
+			return &cert, nil
+		}
+		s.TLSConfig.Certificates = nil
+	})
+	proxyCert, _ := newTestCert(t) // valid for 127.0.0.1 only
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	roots.AddCert(proxyCert.Leaf)
+	proxyURL := newConnectProxy(t, &proxyCert, nil)
+
+	tests := []struct {
+		name     string
+		override string
+		omit     bool
+		proxy    bool
+		wantSNI  string
+		wantErr  bool
+	}{
+		{name: "override", override: "example.com", wantSNI: "example.com"},
+		{name: "override mismatch", override: "other.com", wantErr: true},
+		{name: "omit", override: "example.com", omit: true, wantSNI: ""},
+		{name: "omit mismatch", override: "other.com", omit: true, wantErr: true},
+		{name: "via https proxy", override: "example.com", omit: true, proxy: true, wantSNI: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			gotSNIs = nil
+			mu.Unlock()
+			tr := &Transport{
+				TLSClientConfig:    &tls.Config{RootCAs: roots},
+				ServerNameOverride: tt.override,
+				OmitSNI:            tt.omit,
+			}
+			if tt.proxy {
+				u, _ := neturl.Parse(proxyURL)
+				tr.Proxy = http.ProxyURL(u)
+			}
+			defer tr.CloseIdleConnections()
+			res, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil))
+			if tt.wantErr {
+				if err == nil {
+					res.Body.Close()
+					t.Fatal("RoundTrip succeeded; want certificate verification error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			mu.Lock()
+			defer mu.Unlock()
+			if len(gotSNIs) != 1 || gotSNIs[0] != tt.wantSNI {
+				t.Errorf("server saw SNI %q; want [%q]", gotSNIs, tt.wantSNI)
+			}
+		})
+	}
+}
+
+func TestTransportOnResponseHeaderStats(t *testing.T) {
+	addr := newRawServer(t, func(c net.Conn) {
+		if _, err := readRawRequest(bufio.NewReader(c)); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 103 Early Hints\r\nLink: </a>\r\n\r\n"+
+			"HTTP/1.1 200 OK\r\nA: 1\r\nA: 22\r\nContent-Length: 0\r\n\r\n")
+	})
+	var got []ResponseHeaderStats
+	tr := &Transport{OnResponseHeaderStats: func(req *http.Request, stats ResponseHeaderStats) {
+		got = append(got, stats)
+	}}
+	defer tr.CloseIdleConnections()
+	res, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://"+addr+"/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	// "A" + "1", "A" + "22", "Content-Length" + "0".
+	want := ResponseHeaderStats{Count: 3, Bytes: 2 + 3 + 15}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("OnResponseHeaderStats calls = %+v; want [%+v]", got, want)
+	}
+}
+
+func TestTransportProtocolsForRequest(t *testing.T) {
+	cert, pool := newTestCert(t)
+	_, url := newTLSTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), cert)
+	var calls atomic.Int32
+	tr := &Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool},
+		ForceAttemptHTTP2: true,
+		ProtocolsForRequest: func(req *http.Request) Protocols {
+			// Alternate between answers to catch repeated calls
+			// for a single request.
+			var p Protocols
+			if calls.Add(1)%2 == 1 {
+				p.SetHTTP1(req.Header.Get("X-Proto") == "1")
+				p.SetHTTP2(req.Header.Get("X-Proto") == "2")
+			} else {
+				p.SetHTTP1(req.Header.Get("X-Proto") != "1")
+				p.SetHTTP2(req.Header.Get("X-Proto") != "2")
+			}
+			return p
+		},
+	}
+	defer tr.CloseIdleConnections()
+	for _, proto := range []string{"1", "2", "1", "2"} {
+		calls.Store(0)
+		req := mustNewRequest(t, "GET", url, nil)
+		req.Header.Set("X-Proto", proto)
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if got := strconv.Itoa(res.ProtoMajor); got != proto {
+			t.Errorf("X-Proto %s: got HTTP/%s", proto, got)
+		}
+		if n := calls.Load(); n != 1 {
+			t.Errorf("X-Proto %s: ProtocolsForRequest called %d times; want 1", proto, n)
+		}
+	}
+}
+
+// TestTransportPipelineConnFailure runs pipelined requests on a
+// connection that the server closes after answering the first one.
+// The others must be retried on a new connection. Run with -race: the
+// requests' round trips and the connection's writeLoop run
+// concurrently.
+func TestTransportPipelineConnFailure(t *testing.T) {
+	const n = 8
+	var conns atomic.Int32
+	pipelined := make(chan int, 1)
+	addr := newRawServer(t, func(c net.Conn) {
+		br := bufio.NewReader(c)
+		if conns.Add(1) == 1 {
+			var paths []string
+			c.SetReadDeadline(time.Now().Add(2 * time.Second))
+			for len(paths) < n {
+				req, err := readRawRequest(br)
+				if err != nil {
+					break
+				}
+				paths = append(paths, req.URL.Path)
+			}
+			pipelined <- len(paths)
+			if len(paths) > 0 {
+				io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: "+strconv.Itoa(len(paths[0]))+"\r\n\r\n"+paths[0])
+			}
+			return // close mid-stream
+		}
+		for {
+			req, err := readRawRequest(br)
+			if err != nil {
+				return
+			}
+			io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: "+strconv.Itoa(len(req.URL.Path))+"\r\n\r\n"+req.URL.Path)
+		}
+	})
+	tr := &Transport{MaxPipelineDepth: n}
+	defer tr.CloseIdleConnections()
+
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			path := "/" + strconv.Itoa(i)
+			res, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://"+addr+path, nil))
+			if err != nil {
+				t.Errorf("%s: %v", path, err)
+				return
+			}
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil || string(body) != path {
+				t.Errorf("%s: body %q, %v; want %q", path, body, err, path)
+			}
+		}()
+		if i == 0 {
+			// Let the first request claim the connection; the rest
+			// are sent at once.
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	wg.Wait()
+	if got := <-pipelined; got != n {
+		t.Errorf("first connection carried %d requests; want %d", got, n)
+	}
+}
+
+func TestTransportSendProxyProtocol(t *testing.T) {
+	src := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}
+	for _, p := range []*ProxyInfo{
+		{Version: 1},
+		{Version: 2, Source: src},
+	} {
+		t.Run("v"+strconv.Itoa(p.Version), func(t *testing.T) {
+			infos := make(chan *ProxyInfo, 4)
+			addr := newRawServer(t, func(c net.Conn) {
+				br := bufio.NewReader(c)
+				for {
+					req, info, err := ReadRequestWithProxyProto(br)
+					if err != nil {
+						return
+					}
+					io.Copy(io.Discard, req.Body)
+					infos <- info
+					io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+				}
+			})
+			tr := &Transport{SendProxyProtocol: p}
+			defer tr.CloseIdleConnections()
+			var local net.Addr
+			for i := range 2 {
+				req := mustNewRequest(t, "GET", "http://"+addr+"/", nil)
+				req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+					GotConn: func(info httptrace.GotConnInfo) { local = info.Conn.LocalAddr() },
+				}))
+				res, err := tr.RoundTrip(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				res.Body.Close()
+				info := <-infos
+				if i == 1 {
+					if info != nil {
+						t.Errorf("reused connection sent PROXY header %+v", info)
+					}
+					continue
+				}
+				if info == nil {
+					t.Fatal("no PROXY header on new connection")
+				}
+				wantSrc := local.String()
+				if p.Source != nil {
+					wantSrc = p.Source.String()
+				}
+				if info.Version != p.Version || addrString(info.Source) != wantSrc || addrString(info.Destination) != addr {
+					t.Errorf("PROXY header = v%d %v -> %v; want v%d %v -> %v",
+						info.Version, info.Source, info.Destination, p.Version, wantSrc, addr)
+				}
+			}
+		})
+	}
+}
+
+func TestTransportUnencryptedHTTP2NotAllowed(t *testing.T) {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), func(s *Server) {
+		s.Protocols = h2cProtocols()
+	})
+
+	// An HTTP/2 transport registered for "http" with AllowHTTP does
+	// not enable h2c on its own.
+	tr := &Transport{}
+	t2, err := http2configureTransports(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t2.AllowHTTP = true
+	tr.RegisterProtocol("http", t2)
+	if _, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil)); err != ErrUnencryptedHTTP2NotAllowed {
+		t.Errorf("RoundTrip error = %v; want ErrUnencryptedHTTP2NotAllowed", err)
+	}
+
+	for name, tr := range map[string]*Transport{
+		"Protocols": {Protocols: h2cProtocols()},
+		"ProtocolsForRequest": {ProtocolsForRequest: func(*http.Request) Protocols {
+			return *h2cProtocols()
+		}},
+	} {
+		res, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		res.Body.Close()
+		if res.ProtoMajor != 2 {
+			t.Errorf("%s: Proto = %q; want HTTP/2.0", name, res.Proto)
+		}
+		tr.CloseIdleConnections()
+	}
+}
+
+func TestWithConnLabel(t *testing.T) {
+	for _, h2 := range []bool{false, true} {
+		name := "HTTP1"
+		if h2 {
+			name = "HTTP2"
+		}
+		t.Run(name, func(t *testing.T) {
+			var (
+				srvOpts []func(*Server)
+				tr      = &Transport{}
+			)
+			if h2 {
+				srvOpts = append(srvOpts, func(s *Server) { s.Protocols = h2cProtocols() })
+				tr.Protocols = h2cProtocols()
+			}
+			_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, r.RemoteAddr)
+			}), srvOpts...)
+			defer tr.CloseIdleConnections()
+			conns := map[string]string{} // label to remote address
+			for _, label := range []string{"a", "b", "", "a", "b", ""} {
+				req := mustNewRequest(t, "GET", url, nil)
+				req = req.WithContext(WithConnLabel(req.Context(), label))
+				res, err := tr.RoundTrip(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, _ := io.ReadAll(res.Body)
+				res.Body.Close()
+				if prev, ok := conns[label]; ok && prev != string(b) {
+					t.Errorf("label %q: request used connection %s; want %s", label, b, prev)
+				}
+				conns[label] = string(b)
+			}
+			if conns["a"] == conns["b"] || conns["a"] == conns[""] || conns["b"] == conns[""] {
+				t.Errorf("labels share connections: %v", conns)
+			}
+		})
+	}
+}
+
+func TestTransportMaxRequestsPerConn(t *testing.T) {
+	for _, h2 := range []bool{false, true} {
+		name := "HTTP1"
+		if h2 {
+			name = "HTTP2"
+		}
+		t.Run(name, func(t *testing.T) {
+			var srvOpts []func(*Server)
+			tr := &Transport{MaxRequestsPerConn: 2}
+			if h2 {
+				srvOpts = append(srvOpts, func(s *Server) { s.Protocols = h2cProtocols() })
+				tr.Protocols = h2cProtocols()
+			}
+			_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Conn-Close", strconv.FormatBool(r.Close))
+				io.WriteString(w, r.RemoteAddr)
+			}), srvOpts...)
+			defer tr.CloseIdleConnections()
+			var addrs []string
+			for i := range 5 {
+				res, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil))
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, _ := io.ReadAll(res.Body)
+				res.Body.Close()
+				addrs = append(addrs, string(b))
+				if wantClose := !h2 && i%2 == 1; res.Header.Get("X-Conn-Close") != strconv.FormatBool(wantClose) {
+					t.Errorf("request %d: Connection: close sent = %s; want %v", i, res.Header.Get("X-Conn-Close"), wantClose)
+				}
+			}
+			if addrs[0] != addrs[1] || addrs[2] != addrs[3] || addrs[1] == addrs[2] || addrs[3] == addrs[4] {
+				t.Errorf("connections used = %q; want pairs of requests per connection", addrs)
+			}
+		})
+	}
+}
+
+func TestTransportConnMaxLifetime(t *testing.T) {
+	const lifetime = 100 * time.Millisecond
+	for _, h2 := range []bool{false, true} {
+		name := "HTTP1"
+		if h2 {
+			name = "HTTP2"
+		}
+		t.Run(name, func(t *testing.T) {
+			var srvOpts []func(*Server)
+			tr := &Transport{ConnMaxLifetime: lifetime}
+			if h2 {
+				srvOpts = append(srvOpts, func(s *Server) { s.Protocols = h2cProtocols() })
+				tr.Protocols = h2cProtocols()
+			}
+			_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, r.RemoteAddr)
+			}), srvOpts...)
+			defer tr.CloseIdleConnections()
+			get := func() string {
+				res, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer res.Body.Close()
+				b, _ := io.ReadAll(res.Body)
+				return string(b)
+			}
+			first := get()
+			if again := get(); again != first {
+				t.Errorf("connection not reused within ConnMaxLifetime: %s, then %s", first, again)
+			}
+			time.Sleep(2 * lifetime)
+			if later := get(); later == first {
+				t.Errorf("connection %s reused past ConnMaxLifetime", first)
+			}
+		})
+	}
+}
+
+func TestInterleaveAddrFamilies(t *testing.T) {
+	tests := []struct {
+		in, want []string
+	}{
+		{
+			[]string{"2001:db8::1", "2001:db8::2", "192.0.2.1", "192.0.2.2"},
+			[]string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2"},
+		},
+		{
+			[]string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "2001:db8::1"},
+			[]string{"192.0.2.1", "2001:db8::1", "192.0.2.2", "192.0.2.3"},
+		},
+		{
+			[]string{"::ffff:192.0.2.1", "2001:db8::1"},
+			[]string{"::ffff:192.0.2.1", "2001:db8::1"},
+		},
+		{[]string{"192.0.2.1"}, []string{"192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		if got := interleaveAddrFamilies(tt.in); !slices.Equal(got, tt.want) {
+			t.Errorf("interleaveAddrFamilies(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTransportHappyEyeballs(t *testing.T) {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	_, port, _ := net.SplitHostPort(url[len("http://"):])
+	hangAddr := net.JoinHostPort("2001:db8::1", port)
+	failAddr := net.JoinHostPort("2001:db8::2", port)
+	tests := []struct {
+		name  string
+		ips   []string
+		delay time.Duration
+	}{
+		// The stalled first attempt is overtaken after the delay.
+		{"stalled", []string{"2001:db8::1", "127.0.0.1"}, 20 * time.Millisecond},
+		// A failed attempt starts the next one without waiting.
+		{"failed", []string{"2001:db8::2", "127.0.0.1"}, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			canceled := make(chan bool, 1)
+			tr := &Transport{
+				HappyEyeballs:      true,
+				HappyEyeballsDelay: tt.delay,
+				DNSCache:           &DNSCache{Resolver: &fakeResolver{addrs: map[string][]string{"example.test": tt.ips}}},
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					switch addr {
+					case hangAddr:
+						<-ctx.Done()
+						canceled <- true
+						return nil, ctx.Err()
+					case failAddr:
+						return nil, errors.New("unreachable")
+					}
+					return new(net.Dialer).DialContext(ctx, network, addr)
+				},
+			}
+			defer tr.CloseIdleConnections()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			res, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://example.test:"+port+"/", nil).WithContext(ctx))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if tt.name == "stalled" {
+				select {
+				case <-canceled:
+				case <-time.After(5 * time.Second):
+					t.Error("stalled attempt not abandoned")
+				}
+			}
+		})
+	}
+}
+
+func TestTransportProxyHTTP(t *testing.T) {
+	reqs := make(chan *http.Request, 1)
+	proxyAddr := newRawServer(t, func(c net.Conn) {
+		br := bufio.NewReader(c)
+		for {
+			req, err := readRawRequest(br)
+			if err != nil {
+				return
+			}
+			reqs <- req
+			io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+		}
+	})
+	tr := &Transport{
+		Proxy: func(*http.Request) (*neturl.URL, error) {
+			return &neturl.URL{Host: proxyAddr, User: neturl.UserPassword("user", "pass")}, nil
+		},
+	}
+	defer tr.CloseIdleConnections()
+	res, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://example.test/path?q=1", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	req := <-reqs
+	if got, want := req.RequestURI, "http://example.test/path?q=1"; got != want {
+		t.Errorf("request target = %q; want %q", got, want)
+	}
+	if got, want := req.Header.Get("Proxy-Authorization"), "Basic "+basicAuth("user", "pass"); got != want {
+		t.Errorf("Proxy-Authorization = %q; want %q", got, want)
+	}
+}
+
+func TestTransportProxyConnect(t *testing.T) {
+	cert, pool := newTestCert(t, "example.com")
+	_, url := newTLSTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}), cert)
+	connects := make(chan *http.Request, 1)
+	proxyURL := newConnectProxy(t, nil, func(r *http.Request) bool {
+		connects <- r
+		return r.Header.Get("Proxy-Authorization") == "Basic "+basicAuth("user", "pass")
+	})
+	for _, user := range []*neturl.Userinfo{neturl.UserPassword("user", "pass"), neturl.User("other")} {
+		pu, _ := neturl.Parse(proxyURL)
+		pu.User = user
+		tr := &Transport{
+			Proxy:              http.ProxyURL(pu),
+			ProxyConnectHeader: http.Header{"X-Tunnel": {"yes"}},
+			TLSClientConfig:    &tls.Config{RootCAs: pool},
+		}
+		res, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil))
+		connect := <-connects
+		if got, want := connect.Host, url[len("https://"):]; got != want {
+			t.Errorf("CONNECT target = %q; want %q", got, want)
+		}
+		if connect.Header.Get("X-Tunnel") != "yes" {
+			t.Error("ProxyConnectHeader not sent")
+		}
+		if user.Username() == "user" {
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if string(b) != "ok" {
+				t.Errorf("body = %q; want \"ok\"", b)
+			}
+		} else if err == nil {
+			res.Body.Close()
+			t.Error("request through rejecting proxy succeeded")
+		}
+		tr.CloseIdleConnections()
+	}
+}
+
+func TestTransportProxyUnsupportedScheme(t *testing.T) {
+	tr := &Transport{
+		Proxy: func(*http.Request) (*neturl.URL, error) {
+			return &neturl.URL{Scheme: "gopher", Host: "proxy.test"}, nil
+		},
+	}
+	_, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://example.test/", nil))
+	if err == nil || !strings.Contains(err.Error(), "unsupported proxy scheme") {
+		t.Errorf("RoundTrip error = %v; want unsupported proxy scheme", err)
+	}
+}
+
+// newSOCKS5Proxy starts a SOCKS5 proxy that requires the given
+// username/password, if user is non-empty, and sends the requested
+// target addresses on targets. Host names in targets are connected to
+// as 127.0.0.1. It returns the proxy address.
+func newSOCKS5Proxy(t *testing.T, user, pass string, targets chan<- string) string {
+	return newRawServer(t, func(c net.Conn) {
+		br := bufio.NewReader(c)
+		readN := func(n int) []byte {
+			b := make([]byte, n)
+			if _, err := io.ReadFull(br, b); err != nil {
+				return nil
+			}
+			return b
+		}
+		hdr := readN(2) // VER, NMETHODS
+		if hdr == nil || hdr[0] != 5 || readN(int(hdr[1])) == nil {
+			return
+		}
+		if user == "" {
+			c.Write([]byte{5, 0})
+		} else {
+			c.Write([]byte{5, 2})
+			ver := readN(2) // VER, ULEN
+			if ver == nil {
+				return
+			}
+			u := readN(int(ver[1]))
+			plen := readN(1)
+			if u == nil || plen == nil {
+				return
+			}
+			p := readN(int(plen[0]))
+			if string(u) != user || string(p) != pass {
+				c.Write([]byte{1, 1})
+				return
+			}
+			c.Write([]byte{1, 0})
+		}
+		req := readN(4) // VER, CMD, RSV, ATYP
+		if req == nil || req[1] != 1 {
+			return
+		}
+		var host string
+		switch req[3] {
+		case 1:
+			host = net.IP(readN(4)).String()
+		case 3:
+			n := readN(1)
+			host = string(readN(int(n[0])))
+		case 4:
+			host = net.IP(readN(16)).String()
+		}
+		port := readN(2)
+		if port == nil {
+			return
+		}
+		portStr := strconv.Itoa(int(port[0])<<8 | int(port[1]))
+		targets <- net.JoinHostPort(host, portStr)
+		if net.ParseIP(host) == nil {
+			host = "127.0.0.1"
+		}
+		upstream, err := net.Dial("tcp", net.JoinHostPort(host, portStr))
+		if err != nil {
+			c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer upstream.Close()
+		c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		go io.Copy(upstream, br)
+		io.Copy(c, upstream)
+	})
+}
+
+func TestTransportProxySOCKS5(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, r.Host) })
+	cert, pool := newTestCert(t, "example.test")
+	_, httpsURL := newTLSTestServer(t, h, cert)
+	_, httpURL := newTestServer(t, h)
+	targets := make(chan string, 1)
+	proxyAddr := newSOCKS5Proxy(t, "user", "pass", targets)
+	for _, u := range []string{httpURL, httpsURL} {
+		scheme, addr, _ := strings.Cut(u, "://")
+		_, port, _ := net.SplitHostPort(addr)
+		target := "example.test:" + port
+		tr := &Transport{
+			Proxy: http.ProxyURL(&neturl.URL{
+				Scheme: "socks5h",
+				Host:   proxyAddr,
+				User:   neturl.UserPassword("user", "pass"),
+			}),
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}
+		res, err := tr.RoundTrip(mustNewRequest(t, "GET", scheme+"://"+target+"/", nil))
+		if err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+		b, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if string(b) != target {
+			t.Errorf("%s: Host = %q; want %q", scheme, b, target)
+		}
+		if got := <-targets; got != target {
+			t.Errorf("%s: SOCKS5 target = %q; want %q", scheme, got, target)
+		}
+		tr.CloseIdleConnections()
+	}
+
+	tr := &Transport{
+		Proxy: http.ProxyURL(&neturl.URL{Scheme: "socks5", Host: proxyAddr, User: neturl.UserPassword("user", "wrong")}),
+	}
+	if res, err := tr.RoundTrip(mustNewRequest(t, "GET", httpURL, nil)); err == nil {
+		res.Body.Close()
+		t.Error("request with bad SOCKS5 credentials succeeded")
+	}
+}
+
+func TestTransportHTTP2TETrailers(t *testing.T) {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Join(r.Header["Te"], ","))
+	}), func(s *Server) { s.Protocols = h2cProtocols() })
+	tr := &Transport{Protocols: h2cProtocols()}
+	defer tr.CloseIdleConnections()
+	tests := []struct {
+		te   []string
+		want string
+	}{
+		{[]string{"trailers"}, "trailers"},
+		{[]string{"gzip, trailers;q=1"}, "trailers"},
+		{[]string{"gzip", "Trailers"}, "trailers"},
+		{[]string{"gzip"}, ""},
+	}
+	for _, tt := range tests {
+		req := mustNewRequest(t, "GET", url, nil)
+		req.Header["Te"] = tt.te
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("TE %q: %v", tt.te, err)
+		}
+		b, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if string(b) != tt.want {
+			t.Errorf("TE %q: server got %q; want %q", tt.te, b, tt.want)
+		}
+	}
+}
+
+func TestTransportAutoRetryThrottled(t *testing.T) {
+	var calls atomic.Int32
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if calls.Add(1) <= 2 {
+			w.Header().Set("Retry-After", r.URL.Query().Get("wait"))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write(body)
+	}))
+	tests := []struct {
+		name      string
+		retries   int
+		wait      string
+		body      string
+		getBody   bool
+		wantCode  int
+		wantCalls int32
+	}{
+		{name: "retried", retries: 2, wait: "0", wantCode: 200, wantCalls: 3},
+		{name: "body", retries: 2, wait: "0", body: "hello", getBody: true, wantCode: 200, wantCalls: 3},
+		{name: "out of retries", retries: 1, wait: "0", wantCode: 429, wantCalls: 2},
+		{name: "wait too long", retries: 2, wait: "120", wantCode: 429, wantCalls: 1},
+		{name: "no Retry-After", retries: 2, wait: "", wantCode: 429, wantCalls: 1},
+		{name: "body without GetBody", retries: 2, wait: "0", body: "hello", wantCode: 429, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls.Store(0)
+			tr := &Transport{AutoRetryThrottled: tt.retries}
+			defer tr.CloseIdleConnections()
+			req := mustNewRequest(t, "GET", url+"?wait="+tt.wait, nil)
+			if tt.body != "" {
+				req = mustNewRequest(t, "POST", url+"?wait="+tt.wait, strings.NewReader(tt.body))
+				req.Header.Set("Idempotency-Key", "1")
+				if !tt.getBody {
+					req.GetBody = nil
+				}
+			}
+			res, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != tt.wantCode || calls.Load() != tt.wantCalls {
+				t.Errorf("status = %d after %d requests; want %d after %d", res.StatusCode, calls.Load(), tt.wantCode, tt.wantCalls)
+			}
+			if res.StatusCode == 200 && string(body) != tt.body {
+				t.Errorf("body echoed = %q; want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestTransportAutoRetryThrottledContext(t *testing.T) {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	tr := &Transport{AutoRetryThrottled: 1}
+	defer tr.CloseIdleConnections()
+
+	// A wait past the deadline returns the response at once.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil).WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 503 {
+		t.Errorf("status = %d; want 503", res.StatusCode)
+	}
+
+	// Canceling the context ends the wait.
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil).WithContext(ctx)); !errors.Is(err, context.Canceled) {
+		t.Errorf("RoundTrip error = %v; want context.Canceled", err)
+	}
+}
+
+// newAuthConnectProxy returns the URL of a CONNECT proxy that wants
+// Basic credentials, answering a CONNECT without them with a 407
+// response made by reject. It counts the connections it accepts.
+func newAuthConnectProxy(t *testing.T, conns *atomic.Int32, reject func(c net.Conn)) string {
+	addr := newRawServer(t, func(c net.Conn) {
+		conns.Add(1)
+		br := bufio.NewReader(c)
+		for {
+			req, err := readRawRequest(br)
+			if err != nil {
+				return
+			}
+			if req.Header.Get("Proxy-Authorization") != "Basic "+basicAuth("user", "pass") {
+				reject(c)
+				continue
+			}
+			upstream, err := net.Dial("tcp", req.Host)
+			if err != nil {
+				return
+			}
+			defer upstream.Close()
+			io.WriteString(c, "HTTP/1.1 200 OK\r\n\r\n")
+			go io.Copy(upstream, br)
+			io.Copy(c, upstream)
+			return
+		}
+	})
+	return "http://" + addr
+}
+
+func TestTransportProxyAuth(t *testing.T) {
+	cert, pool := newTestCert(t, "example.com")
+	_, url := newTLSTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}), cert)
+	const challenge = "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic realm=\"test\"\r\n"
+	tests := []struct {
+		name      string
+		reject    string
+		wantConns int32
+	}{
+		{"short body", challenge + "Content-Length: 4\r\n\r\ndeny", 1},
+		{"long body", challenge + fmt.Sprintf("Content-Length: %d\r\n\r\n", 64<<10) + strings.Repeat("x", 64<<10), 2},
+		{"close", challenge + "Connection: close\r\nContent-Length: 0\r\n\r\n", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conns atomic.Int32
+			proxyURL := newAuthConnectProxy(t, &conns, func(c net.Conn) { io.WriteString(c, tt.reject) })
+			pu, _ := neturl.Parse(proxyURL)
+			tr := &Transport{
+				Proxy:           http.ProxyURL(pu),
+				ProxyAuth:       &BasicAuth{Username: "user", Password: "pass"},
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			}
+			defer tr.CloseIdleConnections()
+			res, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if string(b) != "ok" {
+				t.Errorf("body = %q; want \"ok\"", b)
+			}
+			if got := conns.Load(); got != tt.wantConns {
+				t.Errorf("proxy connections = %d; want %d", got, tt.wantConns)
+			}
+		})
+	}
+}
+
+func TestTransportProxyAuthEndlessBody(t *testing.T) {
+	cert, pool := newTestCert(t, "example.com")
+	_, url := newTLSTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}), cert)
+	var conns atomic.Int32
+	proxyURL := newAuthConnectProxy(t, &conns, func(c net.Conn) {
+		io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n"+
+			"Proxy-Authenticate: Basic realm=\"test\"\r\nTransfer-Encoding: chunked\r\n\r\n")
+		// Send chunks until the client hangs up.
+		chunk := "400\r\n" + strings.Repeat("x", 0x400) + "\r\n"
+		c.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		for {
+			if _, err := io.WriteString(c, chunk); err != nil {
+				c.Close()
+				return
+			}
+		}
+	})
+	pu, _ := neturl.Parse(proxyURL)
+	tr := &Transport{
+		Proxy:           http.ProxyURL(pu),
+		ProxyAuth:       &BasicAuth{Username: "user", Password: "pass"},
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}
+	defer tr.CloseIdleConnections()
+	start := time.Now()
+	res, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("RoundTrip took %v; want the 407 body drain to be bounded", d)
+	}
+	if got := conns.Load(); got != 2 {
+		t.Errorf("proxy connections = %d; want 2", got)
+	}
+}
+
+func TestTransportExpectContinueTimeoutZero(t *testing.T) {
+	gotBody := make(chan bool, 1)
+	addr := newRawServer(t, func(c net.Conn) {
+		br := bufio.NewReader(c)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		// Do not send 100 Continue; see whether the body comes anyway.
+		c.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		b, _ := io.ReadAll(req.Body)
+		gotBody <- string(b) == "body"
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+	})
+	for _, tt := range []struct {
+		timeout  time.Duration
+		wantBody bool
+	}{
+		{0, true},
+		{time.Hour, false},
+	} {
+		tr := &Transport{ExpectContinueTimeout: tt.timeout}
+		req := mustNewRequest(t, "POST", "http://"+addr+"/", strings.NewReader("body"))
+		req.Header.Set("Expect", "100-continue")
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if got := <-gotBody; got != tt.wantBody {
+			t.Errorf("ExpectContinueTimeout %v: body sent before 100 Continue = %v; want %v", tt.timeout, got, tt.wantBody)
+		}
+		tr.CloseIdleConnections()
+	}
+}
+
+func TestTransportHTTP2Settings(t *testing.T) {
+	type preface struct {
+		settings []http2Setting
+		incr     uint32
+	}
+	got := make(chan preface, 1)
+	var p preface
+	addr := newH2CaptureServer(t, func(fr *http2Framer, f http2Frame) bool {
+		switch f := f.(type) {
+		case *http2SettingsFrame:
+			if !f.IsAck() {
+				f.ForeachSetting(func(s http2Setting) error {
+					p.settings = append(p.settings, s)
+					return nil
+				})
+			}
+		case *http2WindowUpdateFrame:
+			if f.StreamID == 0 {
+				p.incr = f.Increment
+				got <- p
+				return false
+			}
+		}
+		return true
+	})
+	settings := []HTTP2Setting{
+		{ID: uint16(http2SettingInitialWindowSize), Val: 1 << 20},
+		{ID: uint16(http2SettingEnablePush), Val: 0},
+		{ID: 0x4242, Val: 7}, // unknown identifiers are sent as is
+	}
+	tr := &Transport{
+		Protocols: h2cProtocols(),
+		HTTP2: &HTTP2Config{
+			MaxReadFrameSize:          1 << 20,
+			Settings:                  settings,
+			ConnectionWindowIncrement: 1 << 24,
+		},
+	}
+	go func() {
+		if res, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://"+addr+"/", nil)); err == nil {
+			res.Body.Close()
+		}
+	}()
+	g := <-got
+	want := []http2Setting{{http2SettingInitialWindowSize, 1 << 20}, {http2SettingEnablePush, 0}, {0x4242, 7}}
+	if !slices.Equal(g.settings, want) {
+		t.Errorf("SETTINGS = %v; want %v", g.settings, want)
+	}
+	if g.incr != 1<<24 {
+		t.Errorf("connection WINDOW_UPDATE = %d; want %d", g.incr, 1<<24)
+	}
+
+	tr2 := tr.Clone()
+	tr2.HTTP2.Settings[0].Val = 1
+	if tr.HTTP2.Settings[0].Val != 1<<20 {
+		t.Error("Clone shares HTTP2.Settings")
+	}
+}
+
+func TestTransportHTTP2SettingsInvalid(t *testing.T) {
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), func(s *Server) {
+		s.Protocols = h2cProtocols()
+	})
+	tests := []struct {
+		name string
+		conf *HTTP2Config
+	}{
+		{"push", &HTTP2Config{Settings: []HTTP2Setting{{ID: uint16(http2SettingEnablePush), Val: 1}}}},
+		{"window size", &HTTP2Config{Settings: []HTTP2Setting{{ID: uint16(http2SettingInitialWindowSize), Val: 1 << 31}}}},
+		{"frame size", &HTTP2Config{Settings: []HTTP2Setting{{ID: uint16(http2SettingMaxFrameSize), Val: 1 << 10}}}},
+		{"window increment", &HTTP2Config{ConnectionWindowIncrement: 1<<31 - 1}},
+	}
+	for _, tt := range tests {
+		tr := &Transport{Protocols: h2cProtocols(), HTTP2: tt.conf}
+		if res, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil)); err == nil {
+			res.Body.Close()
+			t.Errorf("%s: RoundTrip succeeded", tt.name)
+		}
+	}
+}
+
+// TestTransportHTTP2SettingsDefaults checks that limits derived from
+// the config do not outlive Settings that leave them out.
+func TestTransportHTTP2SettingsDefaults(t *testing.T) {
+	// The server answers with a header field of 2 KB and a DATA
+	// frame larger than the default maximum frame size.
+	addr := newH2CaptureServer(t, func(fr *http2Framer, f http2Frame) bool {
+		hf, ok := f.(*http2HeadersFrame)
+		if !ok {
+			return true
+		}
+		var buf bytes.Buffer
+		enc := hpack.NewEncoder(&buf)
+		enc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+		enc.WriteField(hpack.HeaderField{Name: "x-big", Value: strings.Repeat("a", 2<<10)})
+		fr.WriteHeaders(http2HeadersFrameParam{StreamID: hf.StreamID, BlockFragment: buf.Bytes(), EndHeaders: true})
+		fr.WriteData(hf.StreamID, true, make([]byte, http2minMaxFrameSize+1))
+		return true
+	})
+	url := "http://" + addr + "/"
+
+	// From the config alone, the large frame would be allowed but
+	// the large header is not.
+	tr := &Transport{
+		Protocols:              h2cProtocols(),
+		MaxResponseHeaderBytes: 1 << 10,
+		HTTP2:                  &HTTP2Config{MaxReadFrameSize: 1 << 20},
+	}
+	if res, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil)); err == nil {
+		res.Body.Close()
+		t.Error("RoundTrip without Settings succeeded despite the large header")
+	}
+
+	// Settings without either limit bring back the protocol defaults:
+	// the header fits, but the frame is too large.
+	tr = tr.Clone()
+	tr.HTTP2.Settings = []HTTP2Setting{{ID: uint16(http2SettingEnablePush), Val: 0}}
+	res, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil))
+	if err != nil {
+		t.Fatalf("RoundTrip with Settings = %v; want the large header accepted", err)
+	}
+	defer res.Body.Close()
+	if _, err := io.ReadAll(res.Body); err == nil {
+		t.Error("reading the body succeeded despite the large frame")
+	}
+}
+
+func TestTransportHTTP2SendGREASE(t *testing.T) {
+	type grease struct {
+		setting   http2SettingID
+		frameType http2FrameType
+	}
+	got := make(chan grease, 1)
+	var g grease
+	addr := newH2CaptureServer(t, func(fr *http2Framer, f http2Frame) bool {
+		switch f := f.(type) {
+		case *http2SettingsFrame:
+			if !f.IsAck() {
+				f.ForeachSetting(func(s http2Setting) error {
+					g.setting = s.ID
+					return nil
+				})
+			}
+		case *http2UnknownFrame:
+			g.frameType = f.Type
+			got <- g
+			return false
+		}
+		return true
+	})
+	tr := &Transport{
+		Protocols: h2cProtocols(),
+		HTTP2: &HTTP2Config{
+			Settings:   []HTTP2Setting{{ID: uint16(http2SettingEnablePush), Val: 0}},
+			SendGREASE: true,
+		},
+	}
+	go func() {
+		if res, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://"+addr+"/", nil)); err == nil {
+			res.Body.Close()
+		}
+	}()
+	r := <-got
+	if r.setting&0x0f0f != 0x0a0a {
+		t.Errorf("last setting ID = %#x; want a GREASE ID of the form 0x?a?a", uint16(r.setting))
+	}
+	if (r.frameType-0x0b)%0x1f != 0 {
+		t.Errorf("frame type = %#x; want a GREASE type of the form 0x0b + 0x1f*N", uint8(r.frameType))
+	}
+	if tr.HTTP2.Settings[len(tr.HTTP2.Settings)-1].ID != uint16(http2SettingEnablePush) {
+		t.Error("GREASE setting was added to HTTP2Config.Settings")
+	}
+
+	// A conforming server ignores both.
+	_, url := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), func(s *Server) {
+		s.Protocols = h2cProtocols()
+	})
+	tr = &Transport{Protocols: h2cProtocols(), HTTP2: &HTTP2Config{SendGREASE: true}}
+	defer tr.CloseIdleConnections()
+	res, err := tr.RoundTrip(mustNewRequest(t, "GET", url, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+}
+
+func TestHTTP2FramerGREASEFrames(t *testing.T) {
+	for n := range 8 {
+		typ := http2FrameType(0x0b + 0x1f*n)
+		var buf bytes.Buffer
+		fr := http2NewFramer(&buf, &buf)
+		if err := fr.WriteRawFrame(typ, 0, 0, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		f, err := fr.ReadFrame()
+		if err != nil {
+			t.Fatalf("type %#x: ReadFrame = %v", uint8(typ), err)
+		}
+		if _, ok := f.(*http2UnknownFrame); !ok {
+			t.Errorf("type %#x: ReadFrame = %T; want *http2UnknownFrame", uint8(typ), f)
+		}
+	}
+}